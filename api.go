@@ -1,12 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"math"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -19,6 +26,7 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/rubiojr/whereami/pkg/gominatim"
 	"github.com/rubiojr/whereami/pkg/logger"
@@ -29,7 +37,7 @@ import (
 // Shared / external symbols expected elsewhere in the project:
 //
 //   type Waypoint struct { Name string; Lat,Lon float64; Desc string; Bookmark bool; ... }
-//   var (allWaypoints []Waypoint; allWaypointsMu sync.RWMutex)
+//   var waypointStore *WaypointStore (waypointstore.go)
 //   func appendBookmark(path string, wp Waypoint) (Waypoint, error)
 //   func deleteBookmark(path, name string, lat, lon float64) (bool, error)
 //   func renameBookmark(path, oldName string, lat, lon float64, newName string) (bool, error)
@@ -51,19 +59,31 @@ var (
 	tileDiskTTLEnv           = "WHEREAMI_TILE_DISK_TTL"
 	tileCacheMaxEntriesEnv   = "WHEREAMI_TILE_CACHE_MAX"
 	tileUpstreamEnv          = "WHEREAMI_TILE_UPSTREAM"
+	tileRetinaEnv            = "WHEREAMI_TILE_RETINA"
 	tileTimeoutEnv           = "WHEREAMI_TILE_TIMEOUT"
 	tileDiskPruneIntervalEnv = "WHEREAMI_TILE_PRUNE_INTERVAL"
 	tileCacheMaxBytesEnv     = "WHEREAMI_TILE_CACHE_MAX_BYTES"
+	tileCacheDirFastEnv      = "WHEREAMI_TILE_CACHE_DIR_FAST"
+	tileCacheDirSlowEnv      = "WHEREAMI_TILE_CACHE_DIR_SLOW"
+	tileCacheMaxBytesFastEnv = "WHEREAMI_TILE_CACHE_MAX_BYTES_FAST"
+	tileCacheMaxBytesSlowEnv = "WHEREAMI_TILE_CACHE_MAX_BYTES_SLOW"
+	tileBreakerThresholdEnv  = "WHEREAMI_TILE_BREAKER_THRESHOLD"
+	tileBreakerWindowEnv     = "WHEREAMI_TILE_BREAKER_WINDOW"
+	tileBreakerCooldownEnv   = "WHEREAMI_TILE_BREAKER_COOLDOWN"
 )
 
 // Defaults
 const (
-	defaultTileCacheMaxBytes int64 = 256 * 1024 * 1024
-	defaultCacheTTL                = 1 * time.Hour
-	defaultDiskTTL                 = 0 // Never expire disk cache (0 = infinite)
-	defaultDiskPruneInterval       = 3 * time.Minute
-	defaultMaxEntries              = 20000
-	defaultUpstreamTemplate        = "https://cartodb-basemaps-a.global.ssl.fastly.net/rastertiles/voyager/%d/%d/%d@2x.png"
+	defaultTileCacheMaxBytes     int64 = 256 * 1024 * 1024
+	defaultTileCacheSlowMaxBytes int64 = 2 * 1024 * 1024 * 1024
+	defaultCacheTTL                    = 1 * time.Hour
+	defaultDiskTTL                     = 0 // Never expire disk cache (0 = infinite)
+	defaultDiskPruneInterval           = 3 * time.Minute
+	defaultMaxEntries                  = 20000
+	defaultUpstreamTemplate            = "https://cartodb-basemaps-a.global.ssl.fastly.net/rastertiles/voyager/%d/%d/%d@2x.png"
+	defaultBreakerThreshold            = 5
+	defaultBreakerWindow               = 30 * time.Second
+	defaultBreakerCooldown             = 1 * time.Minute
 )
 
 var (
@@ -73,8 +93,13 @@ var (
 	tileCacheMaxEntries                 = defaultMaxEntries
 	tileDiskPruneInterval               = defaultDiskPruneInterval
 	tileCacheMaxBytes                   = defaultTileCacheMaxBytes
-	tileUpstreamTemplate                = defaultUpstreamTemplate
+	tileCacheSlowDir                    = ""
+	tileCacheSlowMaxBytes               = defaultTileCacheSlowMaxBytes
+	tileUpstreamTemplates               = []string{defaultUpstreamTemplate}
 	tileHTTPClient                      = &http.Client{Timeout: 12 * time.Second}
+	tileBreakerThreshold                = defaultBreakerThreshold
+	tileBreakerWindow                   = defaultBreakerWindow
+	tileBreakerCooldown                 = defaultBreakerCooldown
 )
 
 // Metrics
@@ -86,8 +111,29 @@ var (
 	tileErrors  uint64
 	tileWaitHit uint64
 	tileEvicts  uint64
+	tileSlowHit uint64 // disk hits served from the slow tier
+	tilePromote uint64 // slow -> fast moves
+	tileDemote  uint64 // fast -> slow moves
+	tileBreak   uint64 // requests short-circuited by an open breaker
 )
 
+// Per-zoom metrics: sharded atomics indexed by zoom, then by zoomHit/zoomMiss/zoomStored.
+// Zooms above maxTileZoom are not tracked per zoom.
+const (
+	zoomHit = iota
+	zoomMiss
+	zoomStored
+)
+
+var tileZoomStats [maxTileZoom + 1][3]uint64
+
+// addZoomStat increments the per-zoom counter of the given kind.
+func addZoomStat(z, kind int) {
+	if z >= 0 && z <= maxTileZoom {
+		atomic.AddUint64(&tileZoomStats[z][kind], 1)
+	}
+}
+
 // tileKey + cache entry
 type tileKey struct {
 	z, x, y int
@@ -107,33 +153,95 @@ type tileProxy struct {
 	mu             sync.Mutex
 	cache          map[tileKey]*tileEntry
 	inFlight       map[tileKey][]chan resultTile
-	upstreamFormat string
+	upstreams      []*tileUpstream // tried in order until one succeeds
 	ttl            time.Duration
 	diskTTL        time.Duration
 	maxEntries     int
-	diskDir        string
+	diskDir        string // primary (fast) disk tier
+	slowDir        string // optional overflow (slow) disk tier
 	diskPruneEvery time.Duration
 	maxBytes       int64
+	slowMaxBytes   int64
 	client         *http.Client
 	debug          bool
 	prunerStarted  bool
+
+	// Per-upstream circuit breakers (guarded by breakerMu)
+	breakerMu        sync.Mutex
+	breakers         map[string]*upstreamBreaker
+	breakerThreshold int
+	breakerWindow    time.Duration
+	breakerCooldown  time.Duration
 }
 
+// tileUpstream is one configured upstream template with its fetch counters.
+type tileUpstream struct {
+	format    string
+	successes uint64 // atomic
+	errors    uint64 // atomic
+}
+
+// upstreamBreaker tracks consecutive failures for one upstream template. State
+// is kept in memory only and starts closed on every launch.
+type upstreamBreaker struct {
+	failures     int       // consecutive failures within the window
+	firstFailure time.Time // start of the current failure window
+	openUntil    time.Time // short-circuit requests until this time
+	probing      bool      // a half-open probe is in flight
+	trips        uint64    // times the breaker has opened
+}
+
+// errBreakerOpen is reported to in-flight waiters when a breaker short-circuits a fetch.
+var errBreakerOpen = errors.New("upstream circuit breaker open")
+
 var (
 	tileProxyOnce sync.Once
 	globalProxy   *tileProxy
 )
 
-// Tag database (separate lightweight SQLite store)
+// Tag database (separate lightweight SQLite store). A failed open is retried
+// by initTagDB at most once per tagDBRetryInterval. tagDBMu only serialises
+// opening; readers Load the handle, which is set once and never reset.
 var (
-	tagDB     *sql.DB
-	tagDBOnce sync.Once
+	tagDB        atomic.Pointer[sql.DB]
+	tagDBMu      sync.Mutex
+	tagDBLastTry time.Time
 
 	// History database (stores every search query for recency list)
 	historyDB     *sql.DB
 	historyDBOnce sync.Once
 )
 
+// Secondary indices per database, (re)created idempotently at open time and by
+// POST /api/maintenance/reindex-db.
+var (
+	// Speeds up recent distinct queries (GROUP BY query, MAX(id)) and lookups by timestamp.
+	historyIndexes = []string{
+		`CREATE INDEX IF NOT EXISTS idx_search_history_query_id ON search_history(query, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_search_history_at ON search_history(at)`,
+	}
+	// Supports pruning / ordering by fetched_at (query is already the PRIMARY KEY).
+	geocodeIndexes = []string{
+		`CREATE INDEX IF NOT EXISTS idx_geocode_cache_fetched_at ON geocode_cache(fetched_at)`,
+	}
+	// Lookups by tag alone (colors, the color subquery in insertTagSQL, tag
+	// filters); the primary key only helps when name is known.
+	tagIndexes = []string{
+		`CREATE INDEX IF NOT EXISTS idx_waypoint_tags_tag ON waypoint_tags(tag)`,
+	}
+)
+
+// ensureIndexes runs the given CREATE INDEX IF NOT EXISTS statements, returning the first error.
+func ensureIndexes(db *sql.DB, stmts []string) error {
+	var firstErr error
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
 // initHistoryDB initializes (idempotently) the persistent query history DB (history.sqlite).
 func initHistoryDB() {
 	historyDBOnce.Do(func() {
@@ -163,16 +271,88 @@ func initHistoryDB() {
 		// In case an older DB already existed without lat/lon, attempt to add them (ignore errors if they exist).
 		_, _ = db.Exec(`ALTER TABLE search_history ADD COLUMN lat REAL`)
 		_, _ = db.Exec(`ALTER TABLE search_history ADD COLUMN lon REAL`)
-		// Performance indices (idempotent):
-		// Speeds up:
-		//  - Recent distinct queries (GROUP BY query, MAX(id))
-		//  - Potential future lookups by timestamp
-		_, _ = db.Exec(`CREATE INDEX IF NOT EXISTS idx_search_history_query_id ON search_history(query, id)`)
-		_, _ = db.Exec(`CREATE INDEX IF NOT EXISTS idx_search_history_at ON search_history(at)`)
+		_ = ensureIndexes(db, historyIndexes)
 		historyDB = db
 	})
 }
 
+// History retention (WHEREAMI_HISTORY_MAX_ROWS / WHEREAMI_HISTORY_MAX_AGE); 0 = unlimited.
+const defaultHistoryPruneInterval = 1 * time.Hour
+
+var historyPrunerOnce sync.Once
+
+// historyLimits reads the configured history retention limits.
+func historyLimits() (maxRows int, maxAge time.Duration) {
+	if v := os.Getenv("WHEREAMI_HISTORY_MAX_ROWS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			maxRows = n
+		}
+	}
+	if v := os.Getenv("WHEREAMI_HISTORY_MAX_AGE"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			maxAge = d
+		}
+	}
+	return maxRows, maxAge
+}
+
+// pruneHistory deletes search history rows older than maxAge and the oldest
+// rows beyond maxRows (ordered via idx_search_history_at).
+func pruneHistory(maxRows int, maxAge time.Duration) {
+	if historyDB == nil {
+		return
+	}
+	var removed int64
+	if maxAge > 0 {
+		cutoff := time.Now().UTC().Add(-maxAge).Format("2006-01-02 15:04:05")
+		if res, err := historyDB.Exec(`DELETE FROM search_history WHERE at < ?`, cutoff); err != nil {
+			logger.Error("history prune (age) failed: %v", err)
+		} else if n, err := res.RowsAffected(); err == nil {
+			removed += n
+		}
+	}
+	if maxRows > 0 {
+		res, err := historyDB.Exec(`DELETE FROM search_history WHERE id IN (
+			SELECT id FROM search_history ORDER BY at ASC, id ASC
+			LIMIT max(0, (SELECT COUNT(*) FROM search_history) - ?)
+		)`, maxRows)
+		if err != nil {
+			logger.Error("history prune (rows) failed: %v", err)
+		} else if n, err := res.RowsAffected(); err == nil {
+			removed += n
+		}
+	}
+	if removed > 0 {
+		logger.Debug("history prune removed %d row(s) (maxRows=%d maxAge=%v)", removed, maxRows, maxAge)
+	}
+}
+
+// startHistoryPruner prunes once at startup and then periodically, when any
+// retention limit is configured.
+func startHistoryPruner() {
+	historyPrunerOnce.Do(func() {
+		maxRows, maxAge := historyLimits()
+		if maxRows == 0 && maxAge == 0 {
+			return
+		}
+		initHistoryDB()
+		interval := defaultHistoryPruneInterval
+		if v := os.Getenv("WHEREAMI_HISTORY_PRUNE_INTERVAL"); v != "" {
+			if d, err := time.ParseDuration(v); err == nil && d >= time.Minute {
+				interval = d
+			}
+		}
+		pruneHistory(maxRows, maxAge)
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for range ticker.C {
+				pruneHistory(maxRows, maxAge)
+			}
+		}()
+	})
+}
+
 // initialize tile proxy (idempotent via tileProxyOnce in RegisterAPI)
 func initTileProxy(debug bool) *tileProxy {
 	// Read env overrides (soft validation)
@@ -204,9 +384,44 @@ func initTileProxy(debug bool) *tileProxy {
 			tileCacheMaxBytes = n
 		}
 	}
+	// Two-tier disk cache: the fast dir replaces the primary cache dir and the
+	// slow dir receives tiles demoted by the pruner.
+	if v := os.Getenv(tileCacheDirFastEnv); v != "" {
+		tileCacheDir = v
+	}
+	if v := os.Getenv(tileCacheDirSlowEnv); v != "" {
+		tileCacheSlowDir = v
+	}
+	if v := os.Getenv(tileCacheMaxBytesFastEnv); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			tileCacheMaxBytes = n
+		}
+	}
+	if v := os.Getenv(tileCacheMaxBytesSlowEnv); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			tileCacheSlowMaxBytes = n
+		}
+	}
+	// Low-DPI displays: use the @1x variant of the built-in default upstream.
+	// A user-supplied WHEREAMI_TILE_UPSTREAM below is left untouched.
+	if v := os.Getenv(tileRetinaEnv); v != "" {
+		if retina, err := strconv.ParseBool(v); err == nil && !retina {
+			tileUpstreamTemplates = []string{strings.Replace(defaultUpstreamTemplate, "@2x", "", 1)}
+		}
+	}
 	if v := os.Getenv(tileUpstreamEnv); v != "" {
-		if strings.Count(v, "%d") == 3 {
-			tileUpstreamTemplate = v
+		// Comma-separated list of templates, tried in order (failover).
+		var templates []string
+		for _, t := range strings.Split(v, ",") {
+			t = strings.TrimSpace(t)
+			if strings.Count(t, "%d") == 3 {
+				templates = append(templates, t)
+			} else if t != "" {
+				logger.Error("ignoring tile upstream template without 3 %%d placeholders: %s", t)
+			}
+		}
+		if len(templates) > 0 {
+			tileUpstreamTemplates = templates
 		}
 	}
 	if v := os.Getenv(tileTimeoutEnv); v != "" {
@@ -214,6 +429,23 @@ func initTileProxy(debug bool) *tileProxy {
 			tileHTTPClient = &http.Client{Timeout: d}
 		}
 	}
+	if v := os.Getenv(tileBreakerThresholdEnv); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			tileBreakerThreshold = n // 0 disables the breaker
+		}
+	}
+	if v := os.Getenv(tileBreakerWindowEnv); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			tileBreakerWindow = d
+		}
+	}
+	if v := os.Getenv(tileBreakerCooldownEnv); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			tileBreakerCooldown = d
+		}
+	}
+
+	resolveTileFormat()
 
 	// Derive cache dir if still empty (use effective cache directory)
 	if tileCacheDir == "" {
@@ -222,19 +454,34 @@ func initTileProxy(debug bool) *tileProxy {
 	if tileCacheDir != "" {
 		_ = os.MkdirAll(tileCacheDir, 0o755)
 	}
+	if tileCacheSlowDir != "" {
+		if filepath.Clean(tileCacheSlowDir) == filepath.Clean(tileCacheDir) {
+			logger.Error("slow tile cache dir equals fast dir (%s); tiering disabled", tileCacheSlowDir)
+			tileCacheSlowDir = ""
+		} else {
+			_ = os.MkdirAll(tileCacheSlowDir, 0o755)
+		}
+	}
 
 	return &tileProxy{
 		cache:          make(map[tileKey]*tileEntry),
 		inFlight:       make(map[tileKey][]chan resultTile),
-		upstreamFormat: tileUpstreamTemplate,
+		upstreams:      newTileUpstreams(tileUpstreamTemplates),
 		ttl:            tileCacheTTL,
 		diskTTL:        tileDiskTTL,
 		maxEntries:     tileCacheMaxEntries,
 		diskDir:        tileCacheDir,
+		slowDir:        tileCacheSlowDir,
 		diskPruneEvery: tileDiskPruneInterval,
 		maxBytes:       tileCacheMaxBytes,
+		slowMaxBytes:   tileCacheSlowMaxBytes,
 		client:         tileHTTPClient,
 		debug:          debug,
+
+		breakers:         make(map[string]*upstreamBreaker),
+		breakerThreshold: tileBreakerThreshold,
+		breakerWindow:    tileBreakerWindow,
+		breakerCooldown:  tileBreakerCooldown,
 	}
 }
 
@@ -257,24 +504,40 @@ func (p *tileProxy) pruneLoop() {
 }
 
 func (p *tileProxy) pruneDisk() {
-	if p.diskDir == "" || p.diskTTL == 0 {
-		return // No disk cache or never expire
+	if p.diskDir == "" {
+		return // No disk cache
+	}
+	if p.slowDir != "" {
+		// Tiered: overflow moves down instead of being deleted; only the slow tier loses tiles.
+		p.demoteOverflow()
+		p.pruneTier(p.slowDir, p.slowMaxBytes)
+		return
 	}
+	if p.diskTTL == 0 {
+		return // Never expire
+	}
+	p.pruneTier(p.diskDir, p.maxBytes)
+}
+
+// pruneTier removes expired tiles from dir, then trims it to maxEntries / maxBytes (oldest first).
+func (p *tileProxy) pruneTier(dir string, maxBytes int64) {
 	// Remove expired
-	_ = filepath.WalkDir(p.diskDir, func(path string, d os.DirEntry, err error) error {
-		if err != nil || d.IsDir() {
-			return nil
-		}
-		if info, err := d.Info(); err == nil {
-			if time.Since(info.ModTime()) > p.diskTTL {
-				_ = os.Remove(path)
+	if p.diskTTL > 0 {
+		_ = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
 			}
-		}
-		return nil
-	})
+			if info, err := d.Info(); err == nil {
+				if time.Since(info.ModTime()) > p.diskTTL {
+					_ = os.Remove(path)
+				}
+			}
+			return nil
+		})
+	}
 	// Collect paths
 	var paths []string
-	_ = filepath.WalkDir(p.diskDir, func(path string, d os.DirEntry, err error) error {
+	_ = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
 		if err != nil || d.IsDir() {
 			return nil
 		}
@@ -326,7 +589,7 @@ func (p *tileProxy) pruneDisk() {
 			list2 = append(list2, ft2{pth, fi.ModTime(), fi.Size()})
 		}
 	}
-	if total <= p.maxBytes {
+	if total <= maxBytes {
 		return
 	}
 	// sort oldest first
@@ -342,7 +605,7 @@ func (p *tileProxy) pruneDisk() {
 		}
 	}
 	for _, e := range list2 {
-		if total <= p.maxBytes {
+		if total <= maxBytes {
 			break
 		}
 		_ = os.Remove(e.path)
@@ -350,6 +613,99 @@ func (p *tileProxy) pruneDisk() {
 	}
 }
 
+// demoteOverflow moves the least recently used fast-tier tiles to the slow tier
+// until the fast tier fits within maxEntries / maxBytes.
+func (p *tileProxy) demoteOverflow() {
+	type ft struct {
+		rel string
+		t   time.Time
+		sz  int64
+	}
+	var list []ft
+	var total int64
+	_ = filepath.WalkDir(p.diskDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(p.diskDir, path)
+		if err != nil {
+			return nil
+		}
+		list = append(list, ft{rel, info.ModTime(), info.Size()})
+		total += info.Size()
+		return nil
+	})
+	if len(list) <= p.maxEntries && total <= p.maxBytes {
+		return
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].t.Before(list[j].t) })
+	count := len(list)
+	for _, e := range list {
+		if count <= p.maxEntries && total <= p.maxBytes {
+			break
+		}
+		if err := moveFile(filepath.Join(p.diskDir, e.rel), filepath.Join(p.slowDir, e.rel)); err != nil {
+			logger.Debug("TILE demote failed path=%s err=%v", e.rel, err)
+			continue
+		}
+		atomic.AddUint64(&tileDemote, 1)
+		count--
+		total -= e.sz
+	}
+}
+
+// promoteTile moves a tile served from the slow tier back to the fast tier (best effort).
+func (p *tileProxy) promoteTile(key tileKey) {
+	src := tilePath(p.slowDir, key)
+	dst := tilePath(p.diskDir, key)
+	if err := moveFile(src, dst); err != nil {
+		logger.Debug("TILE promote failed z=%d x=%d y=%d err=%v", key.z, key.x, key.y, err)
+		return
+	}
+	// Mark as recently used so the next prune does not demote it straight away.
+	now := time.Now()
+	_ = os.Chtimes(dst, now, now)
+	atomic.AddUint64(&tilePromote, 1)
+}
+
+// tilePath returns the on-disk location of a tile inside a cache tier.
+func tilePath(dir string, key tileKey) string {
+	return filepath.Join(dir, fmt.Sprintf("%d", key.z), fmt.Sprintf("%d", key.x), fmt.Sprintf("%d", key.y)+tileFileExt())
+}
+
+// moveFile renames src to dst, falling back to copy + remove across filesystems.
+// The modification time is preserved so tier ordering survives the move.
+func moveFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	tmp := dst + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	_ = os.Chtimes(dst, fi.ModTime(), fi.ModTime())
+	return os.Remove(src)
+}
+
 func (p *tileProxy) evictIfNeeded() {
 	if len(p.cache) <= p.maxEntries {
 		return
@@ -396,6 +752,32 @@ func (p *tileProxy) serveTile(w http.ResponseWriter, r *http.Request) {
 	}
 	key := tileKey{z, x, y}
 
+	data, _, err := p.getTile(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, errBreakerOpen) {
+			http.Error(w, "upstream unavailable (circuit open)", http.StatusBadGateway)
+			return
+		}
+		http.Error(w, "upstream error", http.StatusBadGateway)
+		return
+	}
+	writeTile(w, r, data)
+}
+
+// Tile sources reported by getTile.
+const (
+	tileFromMemory   = "memory"
+	tileFromDisk     = "disk"
+	tileFromSlow     = "slow"
+	tileFromWait     = "wait"
+	tileFromUpstream = "upstream"
+)
+
+// getTile returns the tile for key from the memory cache, the disk tiers, an
+// in-flight fetch of the same tile, or the upstreams (storing the result),
+// along with which of those served it.
+func (p *tileProxy) getTile(ctx context.Context, key tileKey) ([]byte, string, error) {
+	z, x, y := key.z, key.x, key.y
 	start := time.Now()
 	p.mu.Lock()
 	// Memory hit
@@ -403,36 +785,53 @@ func (p *tileProxy) serveTile(w http.ResponseWriter, r *http.Request) {
 		data := ent.data
 		p.mu.Unlock()
 		atomic.AddUint64(&tileHits, 1)
-		logger.Debug("TILE mem-hit z=%d x=%d y=%d age=%v", z, x, y, time.Since(ent.timestamp))
-		w.Header().Set("Content-Type", "image/png")
-		w.Header().Set("Cache-Control", "public, max-age=120")
-		_, _ = w.Write(data)
-		return
+		addZoomStat(z, zoomHit)
+		logger.DebugCtx(ctx, "TILE mem-hit z=%d x=%d y=%d age=%v", z, x, y, time.Since(ent.timestamp))
+		return data, tileFromMemory, nil
 	}
 	// Disk hit (with detailed miss diagnostics when debug enabled)
 	if p.diskDir != "" {
-		diskPath := filepath.Join(p.diskDir, fmt.Sprintf("%d", z), fmt.Sprintf("%d", x), fmt.Sprintf("%d.png", y))
+		diskPath := tilePath(p.diskDir, key)
 		if fi, err := os.Stat(diskPath); err == nil {
 			age := time.Since(fi.ModTime())
 			// Check if disk cache never expires (diskTTL == 0) or is still valid
 			if p.diskTTL == 0 || age < p.diskTTL {
 				if data, err := os.ReadFile(diskPath); err == nil {
 					p.mu.Unlock()
+					if p.slowDir != "" {
+						// Tiered mode tracks recency via mtime for demotion ordering.
+						now := time.Now()
+						_ = os.Chtimes(diskPath, now, now)
+					}
 					atomic.AddUint64(&tileHits, 1)
+					addZoomStat(z, zoomHit)
 					atomic.AddUint64(&tileDiskHit, 1)
-					logger.Debug("TILE disk-hit z=%d x=%d y=%d age=%v", z, x, y, age)
-					w.Header().Set("Content-Type", "image/png")
-					w.Header().Set("Cache-Control", "public, max-age=120")
-					_, _ = w.Write(data)
-					return
+					logger.DebugCtx(ctx, "TILE disk-hit z=%d x=%d y=%d age=%v", z, x, y, age)
+					return data, tileFromDisk, nil
 				} else {
-					logger.Debug("TILE disk-miss z=%d x=%d y=%d reason=read-error err=%v", z, x, y, err)
+					logger.DebugCtx(ctx, "TILE disk-miss z=%d x=%d y=%d reason=read-error err=%v", z, x, y, err)
 				}
 			} else {
-				logger.Debug("TILE disk-miss z=%d x=%d y=%d reason=expired age=%v diskTTL=%v", z, x, y, age, p.diskTTL)
+				logger.DebugCtx(ctx, "TILE disk-miss z=%d x=%d y=%d reason=expired age=%v diskTTL=%v", z, x, y, age, p.diskTTL)
 			}
 		} else {
-			logger.Debug("TILE disk-miss z=%d x=%d y=%d reason=not-found err=%v", z, x, y, err)
+			logger.DebugCtx(ctx, "TILE disk-miss z=%d x=%d y=%d reason=not-found err=%v", z, x, y, err)
+		}
+	}
+	// Slow tier hit (promoted back to the fast tier after serving)
+	if p.slowDir != "" {
+		slowPath := tilePath(p.slowDir, key)
+		if fi, err := os.Stat(slowPath); err == nil && (p.diskTTL == 0 || time.Since(fi.ModTime()) < p.diskTTL) {
+			if data, err := os.ReadFile(slowPath); err == nil {
+				p.mu.Unlock()
+				atomic.AddUint64(&tileHits, 1)
+				addZoomStat(z, zoomHit)
+				atomic.AddUint64(&tileDiskHit, 1)
+				atomic.AddUint64(&tileSlowHit, 1)
+				logger.DebugCtx(ctx, "TILE slow-hit z=%d x=%d y=%d age=%v", z, x, y, time.Since(fi.ModTime()))
+				p.promoteTile(key)
+				return data, tileFromSlow, nil
+			}
 		}
 	}
 	// In-flight wait
@@ -442,89 +841,169 @@ func (p *tileProxy) serveTile(w http.ResponseWriter, r *http.Request) {
 		p.mu.Unlock()
 		res := <-ch
 		if res.err != nil {
-			logger.Debug("TILE wait-hit upstream error z=%d x=%d y=%d err=%v", z, x, y, res.err)
-			http.Error(w, "upstream error", http.StatusBadGateway)
-			return
+			logger.DebugCtx(ctx, "TILE wait-hit upstream error z=%d x=%d y=%d err=%v", z, x, y, res.err)
+			return nil, tileFromWait, res.err
 		}
 		atomic.AddUint64(&tileWaitHit, 1)
-		logger.Debug("TILE wait-hit z=%d x=%d y=%d waited=%v", z, x, y, time.Since(start))
-		w.Header().Set("Content-Type", "image/png")
-		w.Header().Set("Cache-Control", "public, max-age=120")
-		_, _ = w.Write(res.data)
-		return
+		logger.DebugCtx(ctx, "TILE wait-hit z=%d x=%d y=%d waited=%v", z, x, y, time.Since(start))
+		return res.data, tileFromWait, nil
 	}
 	// Miss path: record + mark inflight
 	atomic.AddUint64(&tileMisses, 1)
+	addZoomStat(z, zoomMiss)
 	mainCh := make(chan resultTile, 1)
 	p.inFlight[key] = []chan resultTile{mainCh}
 	p.mu.Unlock()
 
-	upURL := fmt.Sprintf(p.upstreamFormat, z, x, y)
-	if _, err := url.Parse(upURL); err != nil {
-		p.mu.Lock()
-		delete(p.inFlight, key)
-		p.mu.Unlock()
-		atomic.AddUint64(&tileErrors, 1)
-		logger.Debug("TILE bad-upstream-url z=%d x=%d y=%d url=%s err=%v", z, x, y, upURL, err)
-		http.Error(w, "bad upstream url", http.StatusInternalServerError)
+	body, err := p.fetchUpstream(ctx, key)
+	if err != nil {
+		p.finishInflightWithError(key, err)
+		if errors.Is(err, errBreakerOpen) {
+			atomic.AddUint64(&tileBreak, 1)
+		} else {
+			atomic.AddUint64(&tileErrors, 1)
+		}
+		return nil, tileFromUpstream, err
+	}
+	if out, err := transcodeTile(body); err != nil {
+		logger.DebugCtx(ctx, "TILE transcode-fallback z=%d x=%d y=%d format=%s err=%v", z, x, y, tileFormat, err)
+	} else {
+		body = out
+	}
+
+	// Store + persist (best effort)
+	p.mu.Lock()
+	p.storeTileLocked(ctx, key, body)
+	p.evictIfNeeded()
+	waiters := p.inFlight[key]
+	delete(p.inFlight, key)
+	p.mu.Unlock()
+
+	for _, ch := range waiters {
+		ch <- resultTile{data: body, err: nil}
+	}
+
+	logger.DebugCtx(ctx, "TILE upstream-success z=%d x=%d y=%d size=%dB elapsed=%v", z, x, y, len(body), time.Since(start))
+	return body, tileFromUpstream, nil
+}
+
+// storeTileLocked puts a tile in the memory cache and (best effort) writes it
+// to the fast disk tier. Callers hold p.mu.
+func (p *tileProxy) storeTileLocked(ctx context.Context, key tileKey, data []byte) {
+	p.cache[key] = &tileEntry{data: data, timestamp: time.Now()}
+	if p.diskDir == "" {
 		return
 	}
-	logger.Debug("TILE miss -> upstream fetch z=%d x=%d y=%d url=%s", z, x, y, upURL)
+	final := tilePath(p.diskDir, key)
+	_ = os.MkdirAll(filepath.Dir(final), 0o755)
+	tmp := final + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err == nil {
+		if err := os.Rename(tmp, final); err == nil {
+			atomic.AddUint64(&tileStored, 1)
+			addZoomStat(key.z, zoomStored)
+			logger.DebugCtx(ctx, "TILE stored z=%d x=%d y=%d size=%dB path=%s", key.z, key.x, key.y, len(data), final)
+		}
+	}
+}
+
+// newTileUpstreams wraps the configured templates in counter-carrying upstreams.
+func newTileUpstreams(templates []string) []*tileUpstream {
+	out := make([]*tileUpstream, 0, len(templates))
+	for _, t := range templates {
+		out = append(out, &tileUpstream{format: t})
+	}
+	return out
+}
+
+// fetchUpstream fetches the tile from the configured upstreams in order,
+// falling over to the next one on a fetch error or non-200 status. Upstreams
+// with an open breaker are skipped; errBreakerOpen is returned when every
+// upstream was skipped, otherwise the last failure.
+func (p *tileProxy) fetchUpstream(ctx context.Context, key tileKey) ([]byte, error) {
+	lastErr := errBreakerOpen
+	for i, up := range p.upstreams {
+		if !p.breakerAllow(up.format) {
+			logger.DebugCtx(ctx, "TILE breaker-open z=%d x=%d y=%d upstream=%s", key.z, key.x, key.y, up.format)
+			continue
+		}
+		upURL := fmt.Sprintf(up.format, key.z, key.x, key.y)
+		logger.DebugCtx(ctx, "TILE miss -> upstream fetch z=%d x=%d y=%d url=%s attempt=%d/%d", key.z, key.x, key.y, upURL, i+1, len(p.upstreams))
+		body, err := p.fetchOne(up, upURL)
+		if err != nil {
+			atomic.AddUint64(&up.errors, 1)
+			logger.DebugCtx(ctx, "TILE upstream-error z=%d x=%d y=%d url=%s err=%v", key.z, key.x, key.y, upURL, err)
+			lastErr = err
+			continue
+		}
+		atomic.AddUint64(&up.successes, 1)
+		if i > 0 {
+			logger.DebugCtx(ctx, "TILE fallback-success z=%d x=%d y=%d upstream=%s", key.z, key.x, key.y, up.format)
+		}
+		return body, nil
+	}
+	return nil, lastErr
+}
+
+// fetchOne performs a single upstream GET and feeds the outcome to its breaker.
+func (p *tileProxy) fetchOne(up *tileUpstream, upURL string) ([]byte, error) {
+	if _, err := url.Parse(upURL); err != nil {
+		p.breakerRelease(up.format)
+		return nil, fmt.Errorf("bad upstream url: %w", err)
+	}
 	req, _ := http.NewRequest(http.MethodGet, upURL, nil)
 	req.Header.Set("User-Agent", "WhereAmI Tile Proxy/1.0")
 	resp, err := p.client.Do(req)
 	if err != nil {
-		p.finishInflightWithError(key, err)
-		atomic.AddUint64(&tileErrors, 1)
-		logger.Debug("TILE fetch-error z=%d x=%d y=%d err=%v", z, x, y, err)
-		http.Error(w, "fetch error", http.StatusBadGateway)
-		return
+		p.breakerRecord(up.format, false)
+		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		p.finishInflightWithError(key, fmt.Errorf("status %d", resp.StatusCode))
-		atomic.AddUint64(&tileErrors, 1)
-		logger.Debug("TILE upstream-status z=%d x=%d y=%d status=%d", z, x, y, resp.StatusCode)
-		http.Error(w, "upstream status", http.StatusBadGateway)
-		return
+		// Only server-side trouble counts towards the breaker (404s for missing tiles do not).
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			p.breakerRecord(up.format, false)
+		} else {
+			p.breakerRelease(up.format)
+		}
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
 	}
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		p.finishInflightWithError(key, err)
-		atomic.AddUint64(&tileErrors, 1)
-		logger.Debug("TILE read-error z=%d x=%d y=%d err=%v", z, x, y, err)
-		http.Error(w, "read error", http.StatusBadGateway)
-		return
+		p.breakerRecord(up.format, false)
+		return nil, err
 	}
+	p.breakerRecord(up.format, true)
+	return body, nil
+}
 
-	// Store + persist (best effort)
-	p.mu.Lock()
-	p.cache[key] = &tileEntry{data: body, timestamp: time.Now()}
-	if p.diskDir != "" {
-		dir := filepath.Join(p.diskDir, fmt.Sprintf("%d", z), fmt.Sprintf("%d", x))
-		_ = os.MkdirAll(dir, 0o755)
-		final := filepath.Join(dir, fmt.Sprintf("%d.png", y))
-		tmp := final + ".tmp"
-		if err := os.WriteFile(tmp, body, 0o644); err == nil {
-			if err := os.Rename(tmp, final); err == nil {
-				atomic.AddUint64(&tileStored, 1)
-				logger.Debug("TILE stored z=%d x=%d y=%d size=%dB path=%s", z, x, y, len(body), final)
-			}
-		}
+// upstreamStats returns per-upstream fetch counters in configured order.
+func (p *tileProxy) upstreamStats() []map[string]any {
+	out := make([]map[string]any, 0, len(p.upstreams))
+	for _, up := range p.upstreams {
+		out = append(out, map[string]any{
+			"template":  redactTemplate(up.format),
+			"successes": atomic.LoadUint64(&up.successes),
+			"errors":    atomic.LoadUint64(&up.errors),
+		})
 	}
-	p.evictIfNeeded()
-	waiters := p.inFlight[key]
-	delete(p.inFlight, key)
-	p.mu.Unlock()
+	return out
+}
 
-	for _, ch := range waiters {
-		ch <- resultTile{data: body, err: nil}
-	}
+// tileETag returns a strong ETag for tile bytes (truncated SHA-256), identical
+// whether the tile comes from memory, disk or upstream.
+func tileETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
 
-	w.Header().Set("Content-Type", "image/png")
+// writeTile sends a tile with its sniffed (PNG or WebP) content type.
+// http.ServeContent adds Accept-Ranges and handles Range / If-Range (206
+// Partial Content) plus conditional requests against the content ETag: a matching If-None-Match gets 304 Not Modified with no body.
+func writeTile(w http.ResponseWriter, r *http.Request, data []byte) {
+	w.Header().Set("Content-Type", http.DetectContentType(data))
 	w.Header().Set("Cache-Control", "public, max-age=120")
-	logger.Debug("TILE upstream-success z=%d x=%d y=%d size=%dB elapsed=%v", z, x, y, len(body), time.Since(start))
-	_, _ = w.Write(body)
+	w.Header().Set("ETag", tileETag(data))
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
 }
 
 func (p *tileProxy) finishInflightWithError(key tileKey, err error) {
@@ -537,61 +1016,932 @@ func (p *tileProxy) finishInflightWithError(key tileKey, err error) {
 	}
 }
 
-func (p *tileProxy) serveStats(w http.ResponseWriter, _ *http.Request) {
-	p.mu.Lock()
-	memEntries := len(p.cache)
-	p.mu.Unlock()
-	diskTTLSeconds := int(p.diskTTL.Seconds())
-	if p.diskTTL == 0 {
-		diskTTLSeconds = -1 // Indicate never expires
+// breakerAllow reports whether a fetch from upstream may proceed. Once the
+// cooldown elapses the breaker half-opens: a single request is let through as
+// a probe (others keep being short-circuited) and its outcome decides whether
+// the breaker closes or re-opens.
+func (p *tileProxy) breakerAllow(upstream string) bool {
+	if p.breakerThreshold <= 0 {
+		return true
 	}
-	stats := map[string]any{
-		"memory_cache_entries":     memEntries,
-		"memory_cache_ttl_seconds": int(p.ttl.Seconds()),
-		"memory_cache_max_entries": p.maxEntries,
-		"disk_cache_dir":           p.diskDir,
-		"disk_cache_ttl_seconds":   diskTTLSeconds,
-		"disk_cache_max_entries":   p.maxEntries,
-		"disk_cache_max_bytes":     p.maxBytes,
-		"cache_hits":               atomic.LoadUint64(&tileHits),
-		"cache_disk_hits":          atomic.LoadUint64(&tileDiskHit),
-		"cache_misses":             atomic.LoadUint64(&tileMisses),
-		"cache_wait_hit":           atomic.LoadUint64(&tileWaitHit),
-		"tiles_stored":             atomic.LoadUint64(&tileStored),
-		"errors":                   atomic.LoadUint64(&tileErrors),
-		"evictions":                atomic.LoadUint64(&tileEvicts),
+	p.breakerMu.Lock()
+	defer p.breakerMu.Unlock()
+	b := p.breakers[upstream]
+	if b == nil || b.openUntil.IsZero() {
+		return true
 	}
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(stats)
+	if b.probing || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
 }
 
-// ----------------- Bookmark Handlers -----------------
+// breakerRelease ends a half-open probe whose outcome says nothing about the
+// upstream's health (e.g. a 404), so the next request may probe again.
+func (p *tileProxy) breakerRelease(upstream string) {
+	if p.breakerThreshold <= 0 {
+		return
+	}
+	p.breakerMu.Lock()
+	defer p.breakerMu.Unlock()
+	if b := p.breakers[upstream]; b != nil {
+		b.probing = false
+	}
+}
 
-func handlePostBookmark(bookmarksPath string) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		corsHeaders(w)
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusNoContent)
-			return
+// breakerRecord updates the breaker for upstream with the outcome of a fetch.
+func (p *tileProxy) breakerRecord(upstream string, ok bool) {
+	if p.breakerThreshold <= 0 {
+		return
+	}
+	p.breakerMu.Lock()
+	defer p.breakerMu.Unlock()
+	b := p.breakers[upstream]
+	if b == nil {
+		b = &upstreamBreaker{}
+		p.breakers[upstream] = b
+	}
+	now := time.Now()
+	b.probing = false
+	if ok {
+		if !b.openUntil.IsZero() {
+			logger.Info("tile upstream recovered, closing breaker: %s", redactTemplate(upstream))
 		}
-		var req struct {
+		b.failures = 0
+		b.openUntil = time.Time{}
+		return
+	}
+	if b.failures == 0 || now.Sub(b.firstFailure) > p.breakerWindow {
+		b.failures = 0
+		b.firstFailure = now
+	}
+	b.failures++
+	// Trip on reaching the threshold, or immediately when a half-open probe fails.
+	if b.failures >= p.breakerThreshold || !b.openUntil.IsZero() {
+		b.openUntil = now.Add(p.breakerCooldown)
+		b.trips++
+		logger.Error("tile upstream failing (%d consecutive errors), breaker open for %v: %s", b.failures, p.breakerCooldown, redactTemplate(upstream))
+	}
+}
+
+// breakerStates returns a JSON-friendly snapshot of all upstream breakers,
+// keyed by redacted template.
+func (p *tileProxy) breakerStates() map[string]any {
+	p.breakerMu.Lock()
+	defer p.breakerMu.Unlock()
+	out := make(map[string]any, len(p.breakers))
+	now := time.Now()
+	for upstream, b := range p.breakers {
+		state := "closed"
+		entry := map[string]any{
+			"consecutive_failures": b.failures,
+			"trips":                b.trips,
+		}
+		if !b.openUntil.IsZero() {
+			if now.Before(b.openUntil) {
+				state = "open"
+				entry["retry_in_seconds"] = int(b.openUntil.Sub(now).Seconds()) + 1
+			} else {
+				state = "half-open"
+			}
+		}
+		entry["state"] = state
+		out[redactTemplate(upstream)] = entry
+	}
+	return out
+}
+
+// resetMetrics zeroes the global, per-zoom and per-upstream tile counters.
+func (p *tileProxy) resetMetrics() {
+	for _, c := range []*uint64{
+		&tileHits, &tileMisses, &tileDiskHit, &tileStored, &tileErrors, &tileWaitHit,
+		&tileEvicts, &tileSlowHit, &tilePromote, &tileDemote, &tileBreak,
+		&tileBytesSaved, &tileTranscoded, &tileFallbacks,
+	} {
+		atomic.StoreUint64(c, 0)
+	}
+	for z := range tileZoomStats {
+		for kind := range tileZoomStats[z] {
+			atomic.StoreUint64(&tileZoomStats[z][kind], 0)
+		}
+	}
+	for _, up := range p.upstreams {
+		atomic.StoreUint64(&up.successes, 0)
+		atomic.StoreUint64(&up.errors, 0)
+	}
+}
+
+// zoomStats returns hit/miss/stored counts for every zoom with any activity.
+func zoomStats() []map[string]any {
+	out := []map[string]any{}
+	for z := range tileZoomStats {
+		hits := atomic.LoadUint64(&tileZoomStats[z][zoomHit])
+		misses := atomic.LoadUint64(&tileZoomStats[z][zoomMiss])
+		stored := atomic.LoadUint64(&tileZoomStats[z][zoomStored])
+		if hits == 0 && misses == 0 && stored == 0 {
+			continue
+		}
+		out = append(out, map[string]any{
+			"zoom":   z,
+			"hits":   hits,
+			"misses": misses,
+			"stored": stored,
+		})
+	}
+	return out
+}
+
+// GET /api/tiles/stats[?byZoom=true]
+func (p *tileProxy) serveStats(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	memEntries := len(p.cache)
+	p.mu.Unlock()
+	diskTTLSeconds := int(p.diskTTL.Seconds())
+	if p.diskTTL == 0 {
+		diskTTLSeconds = -1 // Indicate never expires
+	}
+	stats := map[string]any{
+		"memory_cache_entries":      memEntries,
+		"memory_cache_ttl_seconds":  int(p.ttl.Seconds()),
+		"memory_cache_max_entries":  p.maxEntries,
+		"disk_cache_dir":            p.diskDir,
+		"disk_cache_ttl_seconds":    diskTTLSeconds,
+		"disk_cache_max_entries":    p.maxEntries,
+		"disk_cache_max_bytes":      p.maxBytes,
+		"disk_cache_slow_dir":       p.slowDir,
+		"disk_cache_slow_max_bytes": p.slowMaxBytes,
+		"cache_hits":                atomic.LoadUint64(&tileHits),
+		"cache_disk_hits":           atomic.LoadUint64(&tileDiskHit),
+		"cache_misses":              atomic.LoadUint64(&tileMisses),
+		"cache_wait_hit":            atomic.LoadUint64(&tileWaitHit),
+		"tiles_stored":              atomic.LoadUint64(&tileStored),
+		"errors":                    atomic.LoadUint64(&tileErrors),
+		"evictions":                 atomic.LoadUint64(&tileEvicts),
+		"cache_slow_hits":           atomic.LoadUint64(&tileSlowHit),
+		"tiles_promoted":            atomic.LoadUint64(&tilePromote),
+		"tiles_demoted":             atomic.LoadUint64(&tileDemote),
+		"breaker_rejections":        atomic.LoadUint64(&tileBreak),
+		"tile_format":               tileFormat,
+		"tiles_transcoded":          atomic.LoadUint64(&tileTranscoded),
+		"transcode_fallbacks":       atomic.LoadUint64(&tileFallbacks),
+		"bytes_saved":               atomic.LoadUint64(&tileBytesSaved),
+		"upstream_breakers":         p.breakerStates(),
+		"upstreams":                 p.upstreamStats(),
+	}
+	if isTruthy(r.URL.Query().Get("byZoom")) {
+		stats["by_zoom"] = zoomStats()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(stats)
+}
+
+// tileEnvKeys lists every environment variable that configures the tile proxy.
+var tileEnvKeys = []string{
+	tileCacheDirEnv, tileCacheTTLEnv, tileDiskTTLEnv, tileCacheMaxEntriesEnv,
+	tileUpstreamEnv, tileRetinaEnv, tileTimeoutEnv, tileDiskPruneIntervalEnv,
+	tileCacheMaxBytesEnv, tileCacheDirFastEnv, tileCacheDirSlowEnv,
+	tileCacheMaxBytesFastEnv, tileCacheMaxBytesSlowEnv, tileBreakerThresholdEnv,
+	tileBreakerWindowEnv, tileBreakerCooldownEnv, tileFormatEnv,
+	tilePrewarmGPXEnv, tilePrewarmZoomsEnv, tilePrewarmCorridorEnv,
+}
+
+// secretQueryParams are query parameter names whose values are redacted from
+// upstream templates (compared case-insensitively).
+var secretQueryParams = map[string]bool{
+	"key": true, "apikey": true, "api_key": true, "access_token": true,
+	"token": true, "secret": true, "password": true, "signature": true,
+}
+
+// redactTemplate hides credentials embedded in an upstream template: URL
+// userinfo and the values of secretQueryParams. Templates are not parsed with
+// net/url because their %d placeholders are not valid escapes.
+func redactTemplate(t string) string {
+	if i := strings.Index(t, "://"); i >= 0 {
+		rest := t[i+3:]
+		host := rest
+		if j := strings.IndexAny(rest, "/?#"); j >= 0 {
+			host = rest[:j]
+		}
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			t = t[:i+3] + "REDACTED@" + rest[at+1:]
+		}
+	}
+	q := strings.Index(t, "?")
+	if q < 0 {
+		return t
+	}
+	frag := ""
+	if h := strings.Index(t[q:], "#"); h >= 0 {
+		frag = t[q+h:]
+		t = t[:q+h]
+	}
+	params := strings.Split(t[q+1:], "&")
+	for n, kv := range params {
+		if k, _, ok := strings.Cut(kv, "="); ok && secretQueryParams[strings.ToLower(k)] {
+			params[n] = k + "=REDACTED"
+		}
+	}
+	return t[:q+1] + strings.Join(params, "&") + frag
+}
+
+// GET /api/tiles/config
+// Reports the effective tile proxy settings (after env overrides) and which
+// tile environment variables are set, with credentials in upstream templates
+// redacted. Durations are in seconds; a disk TTL of -1 means never expire.
+func (p *tileProxy) serveConfig(w http.ResponseWriter, r *http.Request) {
+	upstreams := make([]string, 0, len(p.upstreams))
+	for _, up := range p.upstreams {
+		upstreams = append(upstreams, redactTemplate(up.format))
+	}
+	diskTTLSeconds := int(p.diskTTL.Seconds())
+	if p.diskTTL == 0 {
+		diskTTLSeconds = -1
+	}
+	env := map[string]string{}
+	for _, k := range tileEnvKeys {
+		v, ok := os.LookupEnv(k)
+		if !ok {
+			continue
+		}
+		if k == tileUpstreamEnv {
+			parts := strings.Split(v, ",")
+			for i, t := range parts {
+				parts[i] = redactTemplate(strings.TrimSpace(t))
+			}
+			v = strings.Join(parts, ",")
+		}
+		env[k] = v
+	}
+	cfg := map[string]any{
+		"upstreams":                   upstreams,
+		"default_upstream":            redactTemplate(defaultUpstreamTemplate),
+		"tile_format":                 tileFormat,
+		"disk_cache_dir":              p.diskDir,
+		"disk_cache_slow_dir":         p.slowDir,
+		"disk_cache_ttl_seconds":      diskTTLSeconds,
+		"disk_cache_max_bytes":        p.maxBytes,
+		"disk_cache_slow_max_bytes":   p.slowMaxBytes,
+		"disk_prune_interval_seconds": int(p.diskPruneEvery.Seconds()),
+		"memory_cache_ttl_seconds":    int(p.ttl.Seconds()),
+		"memory_cache_max_entries":    p.maxEntries,
+		"upstream_timeout_seconds":    p.client.Timeout.Seconds(),
+		"breaker_threshold":           p.breakerThreshold,
+		"breaker_window_seconds":      p.breakerWindow.Seconds(),
+		"breaker_cooldown_seconds":    p.breakerCooldown.Seconds(),
+		"debug":                       p.debug,
+		"env":                         env,
+	}
+	logger.DebugCtx(r.Context(), "GET /api/tiles/config upstreams=%d env=%d", len(upstreams), len(env))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(cfg)
+}
+
+// maxCoverageTiles caps the number of tiles a single coverage query may stat.
+const maxCoverageTiles = 1 << 20
+
+// maxTileZoom is the highest zoom level accepted by region-based tile endpoints.
+const maxTileZoom = 22
+
+// parseBBox parses "minLon,minLat,maxLon,maxLat" into its components.
+func parseBBox(s string) (minLon, minLat, maxLon, maxLat float64, err error) {
+	minLon, minLat, maxLon, maxLat, err = parseWrappingBBox(s)
+	if err == nil {
+		err = checkBBox(minLon, minLat, maxLon, maxLat, false)
+	}
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	return minLon, minLat, maxLon, maxLat, nil
+}
+
+// parseWrappingBBox is parseBBox but allows minLon > maxLon for boxes that
+// cross the antimeridian (see bboxContains).
+func parseWrappingBBox(s string) (minLon, minLat, maxLon, maxLat float64, err error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return 0, 0, 0, 0, errors.New("bbox must be minLon,minLat,maxLon,maxLat")
+	}
+	var v [4]float64
+	for i, p := range parts {
+		if v[i], err = strconv.ParseFloat(strings.TrimSpace(p), 64); err != nil {
+			return 0, 0, 0, 0, fmt.Errorf("invalid bbox value %q", p)
+		}
+	}
+	if err := checkBBox(v[0], v[1], v[2], v[3], true); err != nil {
+		return 0, 0, 0, 0, err
+	}
+	return v[0], v[1], v[2], v[3], nil
+}
+
+// checkBBox validates bbox bounds; allowWrap permits minLon > maxLon.
+func checkBBox(minLon, minLat, maxLon, maxLat float64, allowWrap bool) error {
+	if minLat > maxLat || (!allowWrap && minLon > maxLon) {
+		return errors.New("bbox min must not exceed max")
+	}
+	if minLat < -90 || maxLat > 90 || minLon < -180 || maxLon > 180 {
+		return errors.New("bbox out of range")
+	}
+	return nil
+}
+
+// bboxContains reports whether lat/lon lies in the box. When minLon > maxLon
+// the box crosses the antimeridian and is tested as [minLon,180] ∪ [-180,maxLon].
+func bboxContains(minLon, minLat, maxLon, maxLat, lat, lon float64) bool {
+	if lat < minLat || lat > maxLat {
+		return false
+	}
+	if minLon <= maxLon {
+		return lon >= minLon && lon <= maxLon
+	}
+	return lon >= minLon || lon <= maxLon
+}
+
+// lonLatToTile converts a coordinate to slippy-map tile indices at zoom z.
+func lonLatToTile(lon, lat float64, z int) (x, y int) {
+	n := math.Exp2(float64(z))
+	// Clamp to the Web Mercator latitude limits
+	lat = math.Max(math.Min(lat, 85.05112878), -85.05112878)
+	sinLat := math.Sin(lat * math.Pi / 180)
+	x = int((lon + 180.0) / 360.0 * n)
+	y = int((0.5 - math.Log((1+sinLat)/(1-sinLat))/(4*math.Pi)) * n)
+	maxIdx := int(n) - 1
+	x = max(0, min(x, maxIdx))
+	y = max(0, min(y, maxIdx))
+	return x, y
+}
+
+// tileRange returns the inclusive tile index range covering a bbox at zoom z.
+func tileRange(minLon, minLat, maxLon, maxLat float64, z int) (minX, minY, maxX, maxY int) {
+	minX, minY = lonLatToTile(minLon, maxLat, z) // top-left
+	maxX, maxY = lonLatToTile(maxLon, minLat, z) // bottom-right
+	return minX, minY, maxX, maxY
+}
+
+// parseZoomRange reads minZoom/maxZoom query params (maxZoom defaults to minZoom).
+func parseZoomRange(q url.Values) (minZoom, maxZoom int, err error) {
+	minZoom, err = strconv.Atoi(q.Get("minZoom"))
+	if err != nil {
+		return 0, 0, errors.New("invalid minZoom")
+	}
+	maxZoom = minZoom
+	if v := q.Get("maxZoom"); v != "" {
+		if maxZoom, err = strconv.Atoi(v); err != nil {
+			return 0, 0, errors.New("invalid maxZoom")
+		}
+	}
+	if err := checkZoomRange(minZoom, maxZoom); err != nil {
+		return 0, 0, err
+	}
+	return minZoom, maxZoom, nil
+}
+
+// checkZoomRange validates 0 <= minZoom <= maxZoom <= maxTileZoom.
+func checkZoomRange(minZoom, maxZoom int) error {
+	if minZoom < 0 || maxZoom > maxTileZoom || minZoom > maxZoom {
+		return fmt.Errorf("zoom range must satisfy 0 <= minZoom <= maxZoom <= %d", maxTileZoom)
+	}
+	return nil
+}
+
+// hasDiskTile reports whether a tile exists in any disk tier.
+func (p *tileProxy) hasDiskTile(key tileKey) bool {
+	for _, dir := range []string{p.diskDir, p.slowDir} {
+		if dir == "" {
+			continue
+		}
+		if fileExists(tilePath(dir, key)) {
+			return true
+		}
+	}
+	return false
+}
+
+// tileRegion is a validated bbox + zoom range used by region-based tile endpoints.
+type tileRegion struct {
+	minLon, minLat, maxLon, maxLat float64
+	minZoom, maxZoom               int
+}
+
+// parseTileRegion reads bbox/minZoom/maxZoom query params and rejects regions
+// spanning more than maxCoverageTiles tiles.
+func parseTileRegion(q url.Values) (tileRegion, error) {
+	var reg tileRegion
+	var err error
+	reg.minLon, reg.minLat, reg.maxLon, reg.maxLat, err = parseBBox(q.Get("bbox"))
+	if err != nil {
+		return reg, err
+	}
+	reg.minZoom, reg.maxZoom, err = parseZoomRange(q)
+	if err != nil {
+		return reg, err
+	}
+	if reg.tileCount(maxCoverageTiles) > maxCoverageTiles {
+		return reg, errors.New("region too large")
+	}
+	return reg, nil
+}
+
+// tileCount returns the number of tiles in the region across all zooms,
+// stopping early once it exceeds limit.
+func (reg tileRegion) tileCount(limit int) int {
+	total := 0
+	for z := reg.minZoom; z <= reg.maxZoom && total <= limit; z++ {
+		total += reg.zoomCount(z)
+	}
+	return total
+}
+
+// zoomCount returns the number of tiles covering the region at zoom z.
+func (reg tileRegion) zoomCount(z int) int {
+	x0, y0, x1, y1 := tileRange(reg.minLon, reg.minLat, reg.maxLon, reg.maxLat, z)
+	return (x1 - x0 + 1) * (y1 - y0 + 1)
+}
+
+// forEachTile calls fn for every tile covering the region at zoom z.
+func (reg tileRegion) forEachTile(z int, fn func(key tileKey)) {
+	x0, y0, x1, y1 := tileRange(reg.minLon, reg.minLat, reg.maxLon, reg.maxLat, z)
+	for x := x0; x <= x1; x++ {
+		for y := y0; y <= y1; y++ {
+			fn(tileKey{z, x, y})
+		}
+	}
+}
+
+// bbox returns the region bounds as [minLon, minLat, maxLon, maxLat].
+func (reg tileRegion) bbox() []float64 {
+	return []float64{reg.minLon, reg.minLat, reg.maxLon, reg.maxLat}
+}
+
+// GET /api/tiles/coverage?minZoom=&maxZoom=&bbox=minLon,minLat,maxLon,maxLat
+// Reports, per zoom, how many of the tiles covering bbox are cached on disk.
+func (p *tileProxy) serveCoverage(w http.ResponseWriter, r *http.Request) {
+	corsHeaders(w)
+	reg, err := parseTileRegion(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	minZoom, maxZoom := reg.minZoom, reg.maxZoom
+
+	var zooms []map[string]any
+	var allPresent, allExpected int
+	for z := minZoom; z <= maxZoom; z++ {
+		present := 0
+		reg.forEachTile(z, func(key tileKey) {
+			if p.hasDiskTile(key) {
+				present++
+			}
+		})
+		expected := reg.zoomCount(z)
+		allPresent += present
+		allExpected += expected
+		zooms = append(zooms, map[string]any{
+			"zoom":     z,
+			"expected": expected,
+			"present":  present,
+			"missing":  expected - present,
+			"complete": present == expected,
+		})
+	}
+	logger.DebugCtx(r.Context(), "/api/tiles/coverage zoom=%d..%d present=%d/%d", minZoom, maxZoom, allPresent, allExpected)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"bbox":     reg.bbox(),
+		"zooms":    zooms,
+		"expected": allExpected,
+		"present":  allPresent,
+		"missing":  allExpected - allPresent,
+		"complete": allPresent == allExpected,
+	})
+}
+
+// defaultAvgTileBytes is the per-tile size assumed by /api/tiles/estimate
+// while the memory cache is empty (typical raster OSM tile).
+const defaultAvgTileBytes = 15 * 1024
+
+// avgTileBytes returns the mean size of the tiles in the memory cache, or
+// defaultAvgTileBytes (and false) when it is empty.
+func (p *tileProxy) avgTileBytes() (int64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var total int64
+	for _, e := range p.cache {
+		total += int64(len(e.data))
+	}
+	if len(p.cache) == 0 || total == 0 {
+		return defaultAvgTileBytes, false
+	}
+	return total / int64(len(p.cache)), true
+}
+
+// GET /api/tiles/estimate?bbox=minLon,minLat,maxLon,maxLat&minZoom=&maxZoom=
+// Counts the tiles covering bbox per zoom and estimates the bytes needed to
+// cache them (average tile size of the memory cache). Nothing is fetched;
+// prefetch_allowed tells whether POST /api/tiles/prefetch would accept it.
+func (p *tileProxy) serveEstimate(w http.ResponseWriter, r *http.Request) {
+	corsHeaders(w)
+	q := r.URL.Query()
+	var reg tileRegion
+	var err error
+	reg.minLon, reg.minLat, reg.maxLon, reg.maxLat, err = parseBBox(q.Get("bbox"))
+	if err == nil {
+		reg.minZoom, reg.maxZoom, err = parseZoomRange(q)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	avg, measured := p.avgTileBytes()
+	var zooms []map[string]any
+	var total int64
+	for z := reg.minZoom; z <= reg.maxZoom; z++ {
+		n := int64(reg.zoomCount(z))
+		total += n
+		zooms = append(zooms, map[string]any{
+			"zoom":            z,
+			"tiles":           n,
+			"estimated_bytes": n * avg,
+		})
+	}
+	source := "default"
+	if measured {
+		source = "memory_cache"
+	}
+	logger.DebugCtx(r.Context(), "/api/tiles/estimate zoom=%d..%d tiles=%d avg=%d (%s)", reg.minZoom, reg.maxZoom, total, avg, source)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"bbox":               reg.bbox(),
+		"zooms":              zooms,
+		"tiles":              total,
+		"avg_tile_bytes":     avg,
+		"avg_source":         source,
+		"estimated_bytes":    total * avg,
+		"prefetch_allowed":   total <= maxPrefetchTiles,
+		"max_prefetch_tiles": maxPrefetchTiles,
+	})
+}
+
+// maxPrefetchTiles caps a single prefetch job.
+const maxPrefetchTiles = 50000
+
+// prefetchResult summarizes a prefetch run.
+type prefetchResult struct {
+	Requested int `json:"requested"`
+	Fetched   int `json:"fetched"` // downloaded from an upstream
+	Cached    int `json:"cached"`  // already in memory / on disk
+	Errors    int `json:"errors"`
+}
+
+// prefetchWorkers returns the prefetch pool size (WHEREAMI_TILE_PREFETCH_WORKERS, default 4).
+func prefetchWorkers() int {
+	if v := os.Getenv("WHEREAMI_TILE_PREFETCH_WORKERS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 64 {
+			return n
+		}
+	}
+	return 4
+}
+
+// prefetchTiles loads keys through getTile (so cached tiles are not refetched and
+// concurrent requests share in-flight fetches) using a bounded worker pool.
+// Dispatch stops early when ctx is cancelled.
+func (p *tileProxy) prefetchTiles(ctx context.Context, keys []tileKey) prefetchResult {
+	res := prefetchResult{Requested: len(keys)}
+	var mu sync.Mutex
+	jobs := make(chan tileKey)
+	var wg sync.WaitGroup
+	for i := 0; i < min(prefetchWorkers(), len(keys)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range jobs {
+				_, src, err := p.getTile(ctx, key)
+				mu.Lock()
+				switch {
+				case err != nil:
+					res.Errors++
+				case src == tileFromUpstream || src == tileFromWait:
+					res.Fetched++
+				default:
+					res.Cached++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+dispatch:
+	for _, key := range keys {
+		select {
+		case jobs <- key:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return res
+}
+
+// POST /api/tiles/prefetch  JSON: { "bbox": [minLon,minLat,maxLon,maxLat], "minZoom": z1, "maxZoom": z2 }
+// Warms the cache for a region (at most maxPrefetchTiles tiles) and returns
+// { requested, fetched, cached, errors } once done.
+func (p *tileProxy) servePrefetch(w http.ResponseWriter, r *http.Request) {
+	corsHeaders(w)
+	var req struct {
+		BBox    []float64 `json:"bbox"`
+		MinZoom int       `json:"minZoom"`
+		MaxZoom *int      `json:"maxZoom"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if len(req.BBox) != 4 {
+		http.Error(w, "bbox must be [minLon,minLat,maxLon,maxLat]", http.StatusBadRequest)
+		return
+	}
+	reg := tileRegion{
+		minLon: req.BBox[0], minLat: req.BBox[1], maxLon: req.BBox[2], maxLat: req.BBox[3],
+		minZoom: req.MinZoom, maxZoom: req.MinZoom,
+	}
+	if req.MaxZoom != nil {
+		reg.maxZoom = *req.MaxZoom
+	}
+	if err := checkBBox(reg.minLon, reg.minLat, reg.maxLon, reg.maxLat, false); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkZoomRange(reg.minZoom, reg.maxZoom); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if n := reg.tileCount(maxPrefetchTiles); n > maxPrefetchTiles {
+		http.Error(w, fmt.Sprintf("region too large (max %d tiles)", maxPrefetchTiles), http.StatusBadRequest)
+		return
+	}
+
+	var keys []tileKey
+	for z := reg.minZoom; z <= reg.maxZoom; z++ {
+		reg.forEachTile(z, func(key tileKey) { keys = append(keys, key) })
+	}
+	start := time.Now()
+	res := p.prefetchTiles(r.Context(), keys)
+	logger.InfoCtx(r.Context(), "tile prefetch bbox=%v zooms=%d-%d requested=%d fetched=%d cached=%d errors=%d elapsed=%v",
+		reg.bbox(), reg.minZoom, reg.maxZoom, res.Requested, res.Fetched, res.Cached, res.Errors, time.Since(start))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// GET /api/tiles/cache?bbox=&minZoom=&maxZoom=     (list cached tiles in region)
+// DELETE /api/tiles/cache?bbox=&minZoom=&maxZoom=  (remove them from disk + memory)
+func (p *tileProxy) serveRegionCache(w http.ResponseWriter, r *http.Request) {
+	corsHeaders(w)
+	reg, err := parseTileRegion(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	remove := r.Method == http.MethodDelete
+
+	var files int
+	var bytes int64
+	var memRemoved int
+	var tiles [][3]int
+	for z := reg.minZoom; z <= reg.maxZoom; z++ {
+		reg.forEachTile(z, func(key tileKey) {
+			for _, dir := range []string{p.diskDir, p.slowDir} {
+				if dir == "" {
+					continue
+				}
+				path := tilePath(dir, key)
+				fi, err := os.Stat(path)
+				if err != nil || fi.IsDir() {
+					continue
+				}
+				if remove {
+					if err := os.Remove(path); err != nil {
+						logger.DebugCtx(r.Context(), "TILE region-delete failed path=%s err=%v", path, err)
+						continue
+					}
+				} else {
+					tiles = append(tiles, [3]int{key.z, key.x, key.y})
+				}
+				files++
+				bytes += fi.Size()
+			}
+		})
+	}
+	if remove {
+		p.mu.Lock()
+		for k := range p.cache {
+			if k.z < reg.minZoom || k.z > reg.maxZoom {
+				continue
+			}
+			x0, y0, x1, y1 := tileRange(reg.minLon, reg.minLat, reg.maxLon, reg.maxLat, k.z)
+			if k.x >= x0 && k.x <= x1 && k.y >= y0 && k.y <= y1 {
+				delete(p.cache, k)
+				memRemoved++
+			}
+		}
+		p.mu.Unlock()
+		logger.InfoCtx(r.Context(), "tile cache region delete: %d file(s), %d bytes freed, %d memory entries", files, bytes, memRemoved)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if remove {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"bbox":           reg.bbox(),
+			"min_zoom":       reg.minZoom,
+			"max_zoom":       reg.maxZoom,
+			"files_removed":  files,
+			"bytes_freed":    bytes,
+			"memory_removed": memRemoved,
+		})
+		return
+	}
+	if tiles == nil {
+		tiles = [][3]int{}
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"bbox":     reg.bbox(),
+		"min_zoom": reg.minZoom,
+		"max_zoom": reg.maxZoom,
+		"files":    files,
+		"bytes":    bytes,
+		"tiles":    tiles, // [z, x, y] triples
+	})
+}
+
+// purgeTier removes cached tiles under dir (all of them, or only those last
+// modified before cutoff when non-zero) and returns the files / bytes removed.
+func purgeTier(dir string, cutoff time.Time) (files int, bytes int64) {
+	_ = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || (!cutoff.IsZero() && !info.ModTime().Before(cutoff)) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			logger.Debug("TILE purge failed path=%s err=%v", path, err)
+			return nil
+		}
+		files++
+		bytes += info.Size()
+		return nil
+	})
+	return files, bytes
+}
+
+// POST /api/tiles/purge?olderThan=24h
+// Deletes cached tiles from both disk tiers and memory (optionally only those
+// older than olderThan) and resets the tile metrics.
+func (p *tileProxy) servePurge(w http.ResponseWriter, r *http.Request) {
+	corsHeaders(w)
+	var cutoff time.Time
+	if v := r.URL.Query().Get("olderThan"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid olderThan duration", http.StatusBadRequest)
+			return
+		}
+		cutoff = time.Now().Add(-d)
+	}
+
+	var files int
+	var bytes int64
+	for _, dir := range []string{p.diskDir, p.slowDir} {
+		if dir == "" {
+			continue
+		}
+		n, b := purgeTier(dir, cutoff)
+		files += n
+		bytes += b
+	}
+	p.mu.Lock()
+	memRemoved := 0
+	for k, e := range p.cache {
+		if cutoff.IsZero() || e.timestamp.Before(cutoff) {
+			delete(p.cache, k)
+			memRemoved++
+		}
+	}
+	p.mu.Unlock()
+	p.resetMetrics()
+
+	logger.InfoCtx(r.Context(), "tile cache purge olderThan=%q: %d file(s), %d bytes, %d memory entries", r.URL.Query().Get("olderThan"), files, bytes, memRemoved)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"removed_files":  files,
+		"removed_bytes":  bytes,
+		"memory_removed": memRemoved,
+	})
+}
+
+// POST /api/tiles/inject  JSON: { "tiles": [ { "z", "x", "y", "data": "<base64 PNG>" } ] }
+// Writes the given tiles straight into the memory and disk caches, bypassing
+// the upstreams, so tests can exercise the hit paths without a network. Only
+// registered when the server runs with --debug.
+func (p *tileProxy) serveInject(w http.ResponseWriter, r *http.Request) {
+	corsHeaders(w)
+	var req struct {
+		Tiles []struct {
+			Z    int    `json:"z"`
+			X    int    `json:"x"`
+			Y    int    `json:"y"`
+			Data []byte `json:"data"` // base64 in JSON
+		} `json:"tiles"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if len(req.Tiles) == 0 {
+		http.Error(w, "tiles required", http.StatusBadRequest)
+		return
+	}
+	for i, t := range req.Tiles {
+		if t.Z < 0 || t.Z > maxTileZoom || t.X < 0 || t.Y < 0 || t.X >= 1<<t.Z || t.Y >= 1<<t.Z {
+			http.Error(w, fmt.Sprintf("tile %d: invalid coords", i), http.StatusBadRequest)
+			return
+		}
+		if http.DetectContentType(t.Data) != "image/png" {
+			http.Error(w, fmt.Sprintf("tile %d: data is not a PNG", i), http.StatusBadRequest)
+			return
+		}
+	}
+
+	p.mu.Lock()
+	for _, t := range req.Tiles {
+		p.storeTileLocked(r.Context(), tileKey{t.Z, t.X, t.Y}, t.Data)
+	}
+	p.evictIfNeeded()
+	p.mu.Unlock()
+
+	logger.DebugCtx(r.Context(), "POST /api/tiles/inject tiles=%d", len(req.Tiles))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"injected": len(req.Tiles)})
+}
+
+// ----------------- Bookmark Handlers -----------------
+
+// errDescTooLong is returned by limitDesc when a description exceeds the cap.
+var errDescTooLong = errors.New("description too long")
+
+// maxDescLen returns the configured description cap in characters (WHEREAMI_MAX_DESC_LEN, 0 = unlimited).
+func maxDescLen() int {
+	if v := os.Getenv("WHEREAMI_MAX_DESC_LEN"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// limitDesc enforces maxDescLen on desc. When truncate is true an oversized
+// description is cut to the limit (reported via the bool); otherwise
+// errDescTooLong is returned.
+func limitDesc(desc string, truncate bool) (string, bool, error) {
+	limit := maxDescLen()
+	if limit == 0 || utf8.RuneCountInString(desc) <= limit {
+		return desc, false, nil
+	}
+	if !truncate {
+		return desc, false, fmt.Errorf("%w (max %d characters)", errDescTooLong, limit)
+	}
+	return string([]rune(desc)[:limit]), true, nil
+}
+
+func handlePostBookmark(bookmarksPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		corsHeaders(w)
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		var req struct {
 			Name string   `json:"name"`
 			Lat  float64  `json:"lat"`
 			Lon  float64  `json:"lon"`
 			Desc string   `json:"desc,omitempty"`
 			Tags []string `json:"tags,omitempty"`
 		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		if !decodeJSONBody(w, r, &req) {
 			return
 		}
-		logger.Debug("POST /api/bookmarks decode ok name=%q lat=%.6f lon=%.6f tags=%d descLen=%d",
+		logger.DebugCtx(r.Context(), "POST /api/bookmarks decode ok name=%q lat=%.6f lon=%.6f tags=%d descLen=%d",
 			req.Name, req.Lat, req.Lon, len(req.Tags), len(req.Desc))
 		if strings.TrimSpace(req.Name) == "" {
 			http.Error(w, "name required", http.StatusBadRequest)
 			return
 		}
-		wp := Waypoint{Name: req.Name, Lat: req.Lat, Lon: req.Lon, Desc: req.Desc}
+		// ?truncate=true cuts oversized descriptions instead of rejecting them
+		desc, truncated, err := limitDesc(req.Desc, strings.EqualFold(r.URL.Query().Get("truncate"), "true"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		wp := Waypoint{Name: req.Name, Lat: req.Lat, Lon: req.Lon, Desc: desc}
 		saved, err := appendBookmark(bookmarksPath, wp)
 		if err != nil {
 			if errors.Is(err, ErrDuplicate) {
@@ -602,24 +1952,29 @@ func handlePostBookmark(bookmarksPath string) http.HandlerFunc {
 			return
 		}
 		saved.Bookmark = true
-		allWaypointsMu.Lock()
-		allWaypoints = append(allWaypoints, saved)
-		allWaypointsMu.Unlock()
+		waypointStore.Add(saved)
 
 		// Persist tags (best‑effort; non-fatal on error)
 		if len(req.Tags) > 0 {
-			logger.Debug("POST /api/bookmarks persisting %d tag(s) for %q", len(req.Tags), req.Name)
+			logger.DebugCtx(r.Context(), "POST /api/bookmarks persisting %d tag(s) for %q", len(req.Tags), req.Name)
 			if err := addTagsToDB(req.Name, req.Lat, req.Lon, req.Tags); err != nil {
-				logger.Debug("tag insert error for %q: %v", req.Name, err)
+				logger.DebugCtx(r.Context(), "tag insert error for %q: %v", req.Name, err)
 			} else {
-				logger.Debug("tag insert success for %q", req.Name)
+				logger.DebugCtx(r.Context(), "tag insert success for %q", req.Name)
 			}
 		}
+		added := waypointEventData(saved.Name, saved.Lat, saved.Lon)
+		added["desc"] = saved.Desc
+		if len(req.Tags) > 0 {
+			added["tags"] = req.Tags
+		}
+		publishEvent(eventBookmarkAdded, added)
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
-		if len(req.Tags) > 0 {
+		if len(req.Tags) > 0 || truncated {
 			resp := map[string]any{
+				"id":       waypointID(saved),
 				"name":     saved.Name,
 				"lat":      saved.Lat,
 				"lon":      saved.Lon,
@@ -627,57 +1982,224 @@ func handlePostBookmark(bookmarksPath string) http.HandlerFunc {
 				"time":     saved.Time,
 				"desc":     saved.Desc,
 				"bookmark": true,
-				"tags":     req.Tags,
+			}
+			if len(req.Tags) > 0 {
+				resp["tags"] = req.Tags
+			}
+			if truncated {
+				resp["desc_truncated"] = true
 			}
 			_ = json.NewEncoder(w).Encode(resp)
 		} else {
 			_ = json.NewEncoder(w).Encode(saved)
 		}
-	}
-}
+	}
+}
+
+// POST /api/bookmarks/geocode  JSON: { query, name?, tags? }
+// Geocodes query, takes the top result and saves it as a bookmark (named after
+// the result's display name unless name is given). 404 when nothing resolves.
+func handlePostGeocodeBookmark(bookmarksPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Query string   `json:"query"`
+			Name  string   `json:"name,omitempty"`
+			Tags  []string `json:"tags,omitempty"`
+		}
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+		query := strings.TrimSpace(req.Query)
+		if query == "" {
+			http.Error(w, "query required", http.StatusBadRequest)
+			return
+		}
+		results := fetchGeocodeCached(query, 1)
+		if len(results) == 0 {
+			http.Error(w, "no geocode result", http.StatusNotFound)
+			return
+		}
+		top := results[0]
+		name := strings.TrimSpace(req.Name)
+		if name == "" {
+			name = top.Name
+		}
+		logger.DebugCtx(r.Context(), "POST /api/bookmarks/geocode query=%q -> %q lat=%.6f lon=%.6f", query, top.Name, top.Lat, top.Lon)
+
+		saved, err := appendBookmark(bookmarksPath, Waypoint{Name: name, Lat: top.Lat, Lon: top.Lon})
+		if err != nil {
+			if errors.Is(err, ErrDuplicate) {
+				http.Error(w, "duplicate", http.StatusConflict)
+				return
+			}
+			http.Error(w, "save error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		waypointStore.Add(saved)
+
+		if len(req.Tags) > 0 {
+			if err := addTagsToDB(saved.Name, saved.Lat, saved.Lon, req.Tags); err != nil {
+				logger.DebugCtx(r.Context(), "tag insert error for %q: %v", saved.Name, err)
+			}
+		}
+		tags, _ := getTagsFor(saved.Name, saved.Lat, saved.Lon)
+		if tags == nil {
+			tags = []string{}
+		}
+		added := waypointEventData(saved.Name, saved.Lat, saved.Lon)
+		added["tags"] = tags
+		publishEvent(eventBookmarkAdded, added)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"id":       waypointID(saved),
+			"name":     saved.Name,
+			"lat":      saved.Lat,
+			"lon":      saved.Lon,
+			"time":     saved.Time,
+			"bookmark": true,
+			"tags":     tags,
+			"geocode": map[string]any{
+				"query":        query,
+				"display_name": top.Name,
+				"class":        top.Class,
+				"type":         top.Type,
+			},
+		})
+	}
+}
+
+// PATCH /api/bookmarks  JSON: { oldName, lat, lon, newName?, newDesc?, newLat?, newLon? }
+// Identifies the bookmark by oldName + lat/lon. A body with only newName is a
+// plain rename; otherwise the given fields are updated and, when the
+// coordinates change, the bookmark's tags are moved along with it.
+func handlePatchBookmark(bookmarksPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID      string   `json:"id"` // alternative to oldName + lat + lon
+			OldName string   `json:"oldName"`
+			Lat     float64  `json:"lat"`
+			Lon     float64  `json:"lon"`
+			NewName string   `json:"newName"`
+			NewDesc *string  `json:"newDesc"`
+			NewLat  *float64 `json:"newLat"`
+			NewLon  *float64 `json:"newLon"`
+		}
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+		if req.ID != "" {
+			wp, ok := resolveWaypointID(w, req.ID)
+			if !ok {
+				return
+			}
+			req.OldName, req.Lat, req.Lon = wp.Name, wp.Lat, wp.Lon
+		}
+		if strings.TrimSpace(req.OldName) == "" {
+			http.Error(w, "oldName (or id) required", http.StatusBadRequest)
+			return
+		}
+		if req.NewDesc == nil && req.NewLat == nil && req.NewLon == nil {
+			// Name-only rename (original behaviour)
+			if strings.TrimSpace(req.NewName) == "" {
+				http.Error(w, "oldName and newName required", http.StatusBadRequest)
+				return
+			}
+			found, err := renameBookmark(bookmarksPath, req.OldName, req.Lat, req.Lon, req.NewName)
+			if err != nil {
+				http.Error(w, "rename error: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !found {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			waypointStore.Rename(req.OldName, req.Lat, req.Lon, req.NewName)
+			if err := moveAttachment(bookmarkID(req.OldName, req.Lat, req.Lon), bookmarkID(req.NewName, req.Lat, req.Lon)); err != nil {
+				logger.ErrorCtx(r.Context(), "attachment rename failed for %q: %v", req.OldName, err)
+			}
+			renamed := waypointEventData(req.NewName, req.Lat, req.Lon)
+			renamed["oldId"] = waypointID(Waypoint{Name: req.OldName, Lat: req.Lat, Lon: req.Lon})
+			renamed["oldName"] = req.OldName
+			publishEvent(eventBookmarkRenamed, renamed)
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"renamed": true,
+				"id":      waypointID(Waypoint{Name: req.NewName, Lat: req.Lat, Lon: req.Lon}),
+				"oldName": req.OldName,
+				"newName": req.NewName,
+				"lat":     req.Lat,
+				"lon":     req.Lon,
+			})
+			return
+		}
 
-func handlePatchBookmark(bookmarksPath string) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var req struct {
-			OldName string  `json:"oldName"`
-			Lat     float64 `json:"lat"`
-			Lon     float64 `json:"lon"`
-			NewName string  `json:"newName"`
+		var upd bookmarkUpdate
+		if strings.TrimSpace(req.NewName) != "" {
+			upd.Name = &req.NewName
 		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
-			return
+		if req.NewDesc != nil {
+			desc, _, err := limitDesc(*req.NewDesc, strings.EqualFold(r.URL.Query().Get("truncate"), "true"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			upd.Desc = &desc
 		}
-		if strings.TrimSpace(req.OldName) == "" || strings.TrimSpace(req.NewName) == "" {
-			http.Error(w, "oldName and newName required", http.StatusBadRequest)
+		if req.NewLat != nil && (*req.NewLat < -90 || *req.NewLat > 90) ||
+			req.NewLon != nil && (*req.NewLon < -180 || *req.NewLon > 180) {
+			http.Error(w, "invalid newLat/newLon", http.StatusBadRequest)
 			return
 		}
-		found, err := renameBookmark(bookmarksPath, req.OldName, req.Lat, req.Lon, req.NewName)
+		upd.Lat, upd.Lon = req.NewLat, req.NewLon
+
+		updated, found, err := updateBookmark(bookmarksPath, req.OldName, req.Lat, req.Lon, upd)
 		if err != nil {
-			http.Error(w, "rename error: "+err.Error(), http.StatusInternalServerError)
+			if errors.Is(err, ErrDuplicate) {
+				http.Error(w, "duplicate", http.StatusConflict)
+				return
+			}
+			http.Error(w, "update error: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
 		if !found {
 			http.Error(w, "not found", http.StatusNotFound)
 			return
 		}
-		allWaypointsMu.Lock()
-		for i := range allWaypoints {
-			if allWaypoints[i].Name == req.OldName &&
-				math.Abs(allWaypoints[i].Lat-req.Lat) < 1e-9 &&
-				math.Abs(allWaypoints[i].Lon-req.Lon) < 1e-9 {
-				allWaypoints[i].Name = req.NewName
-				break
+		waypointStore.Update(req.OldName, req.Lat, req.Lon, func(wp *Waypoint) {
+			wp.Name, wp.Desc, wp.Lat, wp.Lon = updated.Name, updated.Desc, updated.Lat, updated.Lon
+		})
+
+		if err := moveAttachment(bookmarkID(req.OldName, req.Lat, req.Lon), bookmarkID(updated.Name, updated.Lat, updated.Lon)); err != nil {
+			logger.ErrorCtx(r.Context(), "attachment move failed for %q: %v", req.OldName, err)
+		}
+
+		// Renamed or moved: re-key tag rows so they follow the bookmark (best-effort).
+		if updated.Name != req.OldName || updated.Lat != req.Lat || updated.Lon != req.Lon {
+			if n, err := moveWaypointTags(req.OldName, req.Lat, req.Lon, updated.Name, updated.Lat, updated.Lon); err != nil {
+				logger.ErrorCtx(r.Context(), "tag migration failed for %q: %v", req.OldName, err)
+			} else {
+				logger.DebugCtx(r.Context(), "moved %d tag(s) from %q to %q at %.6f,%.6f", n, req.OldName, updated.Name, updated.Lat, updated.Lon)
 			}
 		}
-		allWaypointsMu.Unlock()
+		changed := waypointEventData(updated.Name, updated.Lat, updated.Lon)
+		changed["desc"] = updated.Desc
+		changed["oldId"] = waypointID(Waypoint{Name: req.OldName, Lat: req.Lat, Lon: req.Lon})
+		changed["oldName"], changed["oldLat"], changed["oldLon"] = req.OldName, req.Lat, req.Lon
+		publishEvent(eventBookmarkUpdated, changed)
+
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(map[string]any{
-			"renamed": true,
+			"updated": true,
+			"id":      waypointID(updated),
 			"oldName": req.OldName,
-			"newName": req.NewName,
 			"lat":     req.Lat,
 			"lon":     req.Lon,
+			"name":    updated.Name,
+			"desc":    updated.Desc,
+			"newLat":  updated.Lat,
+			"newLon":  updated.Lon,
 		})
 	}
 }
@@ -686,15 +2208,25 @@ func handleDeleteBookmark(bookmarksPath string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		q := r.URL.Query()
 		name := q.Get("name")
-		if name == "" {
-			http.Error(w, "name required", http.StatusBadRequest)
-			return
-		}
-		lat, err1 := strconv.ParseFloat(q.Get("lat"), 64)
-		lon, err2 := strconv.ParseFloat(q.Get("lon"), 64)
-		if err1 != nil || err2 != nil {
-			http.Error(w, "invalid lat/lon", http.StatusBadRequest)
-			return
+		var lat, lon float64
+		if id := q.Get("id"); id != "" {
+			wp, ok := resolveWaypointID(w, id)
+			if !ok {
+				return
+			}
+			name, lat, lon = wp.Name, wp.Lat, wp.Lon
+		} else {
+			if name == "" {
+				http.Error(w, "name (or id) required", http.StatusBadRequest)
+				return
+			}
+			var err1, err2 error
+			lat, err1 = strconv.ParseFloat(q.Get("lat"), 64)
+			lon, err2 = strconv.ParseFloat(q.Get("lon"), 64)
+			if err1 != nil || err2 != nil {
+				http.Error(w, "invalid lat/lon", http.StatusBadRequest)
+				return
+			}
 		}
 		found, err := deleteBookmark(bookmarksPath, name, lat, lon)
 		if err != nil {
@@ -705,61 +2237,642 @@ func handleDeleteBookmark(bookmarksPath string) http.HandlerFunc {
 			http.Error(w, "not found", http.StatusNotFound)
 			return
 		}
-		allWaypointsMu.Lock()
-		for i := 0; i < len(allWaypoints); i++ {
-			if allWaypoints[i].Name == name &&
-				math.Abs(allWaypoints[i].Lat-lat) < 1e-9 &&
-				math.Abs(allWaypoints[i].Lon-lon) < 1e-9 &&
-				allWaypoints[i].Bookmark {
-				allWaypoints = append(allWaypoints[:i], allWaypoints[i+1:]...)
-				break
-			}
+		waypointStore.RemoveMatch(name, lat, lon)
+		if err := deleteAttachment(bookmarkID(name, lat, lon)); err != nil {
+			logger.ErrorCtx(r.Context(), "attachment cleanup failed for %q: %v", name, err)
+		}
+		if n, err := deleteWaypointTags(name, lat, lon); err != nil {
+			logger.ErrorCtx(r.Context(), "tag cleanup failed for %q: %v", name, err)
+		} else if n > 0 {
+			logger.InfoCtx(r.Context(), "DELETE /api/bookmarks removed %d tag row(s) of %q", n, name)
 		}
-		allWaypointsMu.Unlock()
+		publishEvent(eventBookmarkDeleted, waypointEventData(name, lat, lon))
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(map[string]any{
 			"deleted": true,
+			"id":      waypointID(Waypoint{Name: name, Lat: lat, Lon: lon}),
 			"name":    name,
 			"lat":     lat,
 			"lon":     lon,
 		})
 	}
-}
-
-func corsHeaders(w http.ResponseWriter) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, PATCH, DELETE, OPTIONS")
-}
+}
+
+// GET /api/bookmarks[?tags=true]
+// Lists only the bookmarks as JSON. With tags=true each entry carries its tag list.
+func handleGetBookmarks(w http.ResponseWriter, r *http.Request) {
+	bookmarks := waypointStore.Filter(func(wp Waypoint) bool { return wp.Bookmark })
+
+	withTags := strings.EqualFold(r.URL.Query().Get("tags"), "true")
+	logger.DebugCtx(r.Context(), "GET /api/bookmarks count=%d tags=%v", len(bookmarks), withTags)
+
+	out := make([]map[string]any, 0, len(bookmarks))
+	for _, wp := range bookmarks {
+		obj := map[string]any{
+			"id":       waypointID(wp),
+			"name":     wp.Name,
+			"lat":      wp.Lat,
+			"lon":      wp.Lon,
+			"bookmark": true,
+		}
+		if wp.Ele != 0 {
+			obj["ele"] = wp.Ele
+		}
+		if wp.Time != "" {
+			obj["time"] = wp.Time
+		}
+		if wp.Desc != "" {
+			obj["desc"] = wp.Desc
+		}
+		if withTags && wp.Name != "" {
+			tags, err := getTagsFor(wp.Name, wp.Lat, wp.Lon)
+			if err != nil {
+				logger.DebugCtx(r.Context(), "/api/bookmarks tag lookup failed for %q: %v", wp.Name, err)
+			}
+			if tags == nil {
+				tags = []string{}
+			}
+			obj["tags"] = tags
+		}
+		out = append(out, obj)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// GET /api/bookmarks/export
+// Streams the current bookmarks as a GPX 1.1 attachment.
+func handleGetBookmarksExport(w http.ResponseWriter, r *http.Request) {
+	bookmarks := waypointStore.Filter(func(wp Waypoint) bool { return wp.Bookmark })
+
+	logger.DebugCtx(r.Context(), "GET /api/bookmarks/export count=%d", len(bookmarks))
+	w.Header().Set("Content-Type", "application/gpx+xml")
+	w.Header().Set("Content-Disposition", `attachment; filename="bookmarks.gpx"`)
+	_, _ = io.WriteString(w, serializeBookmarksGPX(bookmarks))
+}
+
+// Radius bounds for GET /api/bookmarks/duplicates.
+const (
+	defaultDuplicateRadiusM = 25.0
+	maxDuplicateRadiusM     = 5000.0
+)
+
+// GET /api/bookmarks/duplicates?radius_m=
+// Read-only report of bookmarks lying within radius_m meters (default
+// WHEREAMI_DEDUPE_RADIUS when set, else 25; max 5000) of each other, whatever
+// their names: { radius_m, count, groups: [ { bookmarks: [ { id, name, lat,
+// lon, desc? } ], pairs: [ { a, b, distance_m } ], min_distance_m } ] } with
+// pairs referencing bookmark ids. Closest groups first.
+func handleGetBookmarkDuplicates(w http.ResponseWriter, r *http.Request) {
+	radius := dedupeRadiusMeters()
+	if radius <= 0 {
+		radius = defaultDuplicateRadiusM
+	}
+	if v := r.URL.Query().Get("radius_m"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 || f > maxDuplicateRadiusM {
+			http.Error(w, "radius_m must be > 0 and <= 5000", http.StatusBadRequest)
+			return
+		}
+		radius = f
+	}
+	bookmarks := waypointStore.Filter(func(wp Waypoint) bool { return wp.Bookmark })
+	groups, pairs := nearGroups(bookmarks, radius)
+
+	type group struct {
+		obj     map[string]any
+		minDist float64
+	}
+	out := make([]group, 0, len(groups))
+	for g, members := range groups {
+		list := make([]map[string]any, 0, len(members))
+		for _, i := range members {
+			wp := bookmarks[i]
+			obj := map[string]any{"id": waypointID(wp), "name": wp.Name, "lat": wp.Lat, "lon": wp.Lon}
+			if wp.Desc != "" {
+				obj["desc"] = wp.Desc
+			}
+			list = append(list, obj)
+		}
+		minDist := math.Inf(1)
+		links := make([]map[string]any, 0, len(pairs[g]))
+		for _, p := range pairs[g] {
+			minDist = min(minDist, p.DistanceM)
+			links = append(links, map[string]any{
+				"a":          waypointID(bookmarks[p.A]),
+				"b":          waypointID(bookmarks[p.B]),
+				"distance_m": roundTo(p.DistanceM, 1),
+			})
+		}
+		out = append(out, group{
+			obj:     map[string]any{"bookmarks": list, "pairs": links, "min_distance_m": roundTo(minDist, 1)},
+			minDist: minDist,
+		})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].minDist < out[j].minDist })
+	result := make([]map[string]any, len(out))
+	for i, g := range out {
+		result[i] = g.obj
+	}
+	logger.DebugCtx(r.Context(), "GET /api/bookmarks/duplicates radius=%.1fm bookmarks=%d groups=%d", radius, len(bookmarks), len(result))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"radius_m": radius,
+		"count":    len(result),
+		"groups":   result,
+	})
+}
+
+// parseTimeBound parses an RFC3339 timestamp or a YYYY-MM-DD date (UTC). For
+// dates, endOfDay selects the last instant of that day so "to" is inclusive.
+func parseTimeBound(s string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q (want RFC3339 or YYYY-MM-DD)", s)
+	}
+	if endOfDay {
+		d = d.Add(24*time.Hour - time.Nanosecond)
+	}
+	return d, nil
+}
+
+// parseTimeRange reads optional from/to query parameters. Zero values mean unbounded.
+func parseTimeRange(q url.Values) (from, to time.Time, err error) {
+	if v := strings.TrimSpace(q.Get("from")); v != "" {
+		if from, err = parseTimeBound(v, false); err != nil {
+			return
+		}
+	}
+	if v := strings.TrimSpace(q.Get("to")); v != "" {
+		if to, err = parseTimeBound(v, true); err != nil {
+			return
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		err = errors.New("to is before from")
+	}
+	return
+}
+
+// inTimeRange reports whether wp's timestamp lies within [from, to]. With any
+// bound set, waypoints without a (parseable) time are excluded.
+func inTimeRange(wp Waypoint, from, to time.Time) bool {
+	if from.IsZero() && to.IsZero() {
+		return true
+	}
+	t, err := time.Parse(time.RFC3339, wp.Time)
+	if err != nil {
+		return false
+	}
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || !t.After(to))
+}
+
+// GET /api/export?format=gpx&from=&to=
+// Downloads all waypoints whose time falls within [from, to] (RFC3339 or
+// YYYY-MM-DD, both optional) as a GPX file.
+func handleGetExport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if f := q.Get("format"); f != "" && !strings.EqualFold(f, "gpx") {
+		http.Error(w, "unsupported format (only gpx)", http.StatusBadRequest)
+		return
+	}
+	from, to, err := parseTimeRange(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	selected := waypointStore.Filter(func(wp Waypoint) bool { return inTimeRange(wp, from, to) })
+
+	logger.DebugCtx(r.Context(), "GET /api/export from=%v to=%v count=%d", from, to, len(selected))
+	w.Header().Set("Content-Type", "application/gpx+xml")
+	w.Header().Set("Content-Disposition", `attachment; filename="waypoints.gpx"`)
+	_, _ = io.WriteString(w, serializeBookmarksGPX(selected))
+}
+
+// serializeWaypointsGeoJSON renders waypoints as a GeoJSON FeatureCollection of
+// Point features (coordinates are [lon, lat] or [lon, lat, ele]).
+func serializeWaypointsGeoJSON(wps []Waypoint) ([]byte, error) {
+	features := make([]map[string]any, 0, len(wps))
+	for _, wp := range wps {
+		coords := []float64{wp.Lon, wp.Lat}
+		if wp.Ele != 0 {
+			coords = append(coords, wp.Ele)
+		}
+		props := map[string]any{"id": waypointID(wp), "name": wp.Name, "bookmark": wp.Bookmark}
+		if wp.Desc != "" {
+			props["desc"] = wp.Desc
+		}
+		if wp.Time != "" {
+			props["time"] = wp.Time
+		}
+		features = append(features, map[string]any{
+			"type":       "Feature",
+			"geometry":   map[string]any{"type": "Point", "coordinates": coords},
+			"properties": props,
+		})
+	}
+	return json.Marshal(map[string]any{"type": "FeatureCollection", "features": features})
+}
+
+// POST /api/export/visible
+// JSON: { bbox: "minLon,minLat,maxLon,maxLat", format?: "gpx"|"geojson",
+// bookmarksOnly?: bool, from?, to?, tags?: [] }
+// Downloads the waypoints in view (bbox may cross the antimeridian) that pass
+// the active filters: bookmarksOnly, the /api/export time range and tags (any
+// of, matched by normalizeTagKey). GPX is the default format.
+func handlePostExportVisible(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		BBox          string   `json:"bbox"`
+		Format        string   `json:"format"`
+		BookmarksOnly bool     `json:"bookmarksOnly"`
+		From          string   `json:"from"`
+		To            string   `json:"to"`
+		Tags          []string `json:"tags"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	format := strings.ToLower(strings.TrimSpace(req.Format))
+	if format == "" {
+		format = "gpx"
+	}
+	if format != "gpx" && format != "geojson" {
+		http.Error(w, "unsupported format (gpx or geojson)", http.StatusBadRequest)
+		return
+	}
+	if req.BBox == "" {
+		http.Error(w, "bbox required", http.StatusBadRequest)
+		return
+	}
+	minLon, minLat, maxLon, maxLat, err := parseWrappingBBox(req.BBox)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, to, err := parseTimeRange(url.Values{"from": {req.From}, "to": {req.To}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	wantTags := make(map[string]bool, len(req.Tags))
+	for _, t := range req.Tags {
+		if n := normalizeTagKey(t); n != "" {
+			wantTags[n] = true
+		}
+	}
+
+	selected := waypointStore.Filter(func(wp Waypoint) bool {
+		return (!req.BookmarksOnly || wp.Bookmark) && inTimeRange(wp, from, to) &&
+			bboxContains(minLon, minLat, maxLon, maxLat, wp.Lat, wp.Lon)
+	})
+
+	if len(wantTags) > 0 {
+		kept := selected[:0]
+		for _, wp := range selected {
+			tags, _ := getTagsFor(wp.Name, wp.Lat, wp.Lon)
+			for _, t := range tags {
+				if wantTags[normalizeTagKey(t)] {
+					kept = append(kept, wp)
+					break
+				}
+			}
+		}
+		selected = kept
+	}
+
+	logger.DebugCtx(r.Context(), "POST /api/export/visible bbox=%s format=%s count=%d", req.BBox, format, len(selected))
+	if format == "geojson" {
+		data, err := serializeWaypointsGeoJSON(selected)
+		if err != nil {
+			http.Error(w, "encode error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/geo+json")
+		w.Header().Set("Content-Disposition", `attachment; filename="visible.geojson"`)
+		_, _ = w.Write(data)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gpx+xml")
+	w.Header().Set("Content-Disposition", `attachment; filename="visible.gpx"`)
+	_, _ = io.WriteString(w, serializeBookmarksGPX(selected))
+}
+
+// GET /api/waypoints/timeline?tz=Europe/Madrid&from=&to=
+// Groups dated waypoints by calendar day in tz (IANA name, default UTC):
+// { timezone, days: [ { date, count, waypoints } ], undated: { count, waypoints } }.
+// Days are ascending and waypoints within a day are ordered by time. Undated
+// waypoints are only listed when no from/to bound is given.
+func handleGetWaypointsTimeline(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	loc := time.UTC
+	if tz := strings.TrimSpace(q.Get("tz")); tz != "" {
+		l, err := time.LoadLocation(tz)
+		if err != nil {
+			http.Error(w, "invalid tz: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		loc = l
+	}
+	from, to, err := parseTimeRange(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type datedWaypoint struct {
+		wp Waypoint
+		t  time.Time
+	}
+	byDay := make(map[string][]datedWaypoint)
+	undated := []Waypoint{}
+	waypointStore.View(func(wps []Waypoint) {
+		for _, wp := range wps {
+			t, err := time.Parse(time.RFC3339, wp.Time)
+			if err != nil {
+				if from.IsZero() && to.IsZero() {
+					undated = append(undated, wp)
+				}
+				continue
+			}
+			if !inTimeRange(wp, from, to) {
+				continue
+			}
+			day := t.In(loc).Format("2006-01-02")
+			byDay[day] = append(byDay[day], datedWaypoint{wp, t})
+		}
+	})
+
+	dates := make([]string, 0, len(byDay))
+	for d := range byDay {
+		dates = append(dates, d)
+	}
+	sort.Strings(dates)
+	days := make([]map[string]any, 0, len(dates))
+	for _, d := range dates {
+		entries := byDay[d]
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].t.Before(entries[j].t) })
+		wps := make([]Waypoint, len(entries))
+		for i, e := range entries {
+			wps[i] = e.wp
+		}
+		days = append(days, map[string]any{"date": d, "count": len(wps), "waypoints": wps})
+	}
+
+	logger.DebugCtx(r.Context(), "GET /api/waypoints/timeline tz=%s days=%d undated=%d", loc, len(days), len(undated))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"timezone": loc.String(),
+		"days":     days,
+		"undated":  map[string]any{"count": len(undated), "waypoints": undated},
+	})
+}
+
+func corsHeaders(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Request-ID")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, PATCH, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
+}
+
+// ---------------- Request IDs ----------------
+
+// requestIDHeader carries the per-request correlation ID (accepted and echoed).
+const requestIDHeader = "X-Request-ID"
+
+// statusRecorder captures the response status for request logging.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// newRequestID returns a random 16 hex character identifier.
+func newRequestID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID accepts short client-supplied IDs made of [A-Za-z0-9._-]
+// (keeps log lines sane).
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// trustProxyHeaders reports whether WHEREAMI_TRUST_PROXY allows honoring
+// X-Forwarded-For / X-Real-IP (only enable behind a trusted reverse proxy).
+func trustProxyHeaders() bool {
+	v := strings.TrimSpace(os.Getenv("WHEREAMI_TRUST_PROXY"))
+	return v == "1" || strings.EqualFold(v, "true")
+}
+
+// clientIP returns the originating client address for logging. Forwarding
+// headers are only consulted when trustProxyHeaders is enabled; otherwise (or
+// when they are absent/invalid) the connection's RemoteAddr is used.
+func clientIP(r *http.Request) string {
+	if trustProxyHeaders() {
+		// Leftmost X-Forwarded-For entry is the original client
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			first, _, _ := strings.Cut(xff, ",")
+			if ip := net.ParseIP(strings.TrimSpace(first)); ip != nil {
+				return ip.String()
+			}
+		}
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+			return ip.String()
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// withRequestID reads X-Request-ID (generating one if absent or malformed),
+// stores it in the request context for logger.*Ctx helpers and echoes it in
+// the response header.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSpace(r.Header.Get(requestIDHeader))
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := logger.WithRequestID(r.Context(), id)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		logger.DebugCtx(ctx, "HTTP %s %s status=%d elapsed=%v ip=%s", r.Method, r.URL.Path, rec.status, time.Since(start), clientIP(r))
+	})
+}
+
+// ---------------- Waypoints & Clustering ----------------
+
+var (
+	coordPrecisionOnce  sync.Once
+	coordPrecisionValue int
+)
+
+// coordPrecision returns the number of decimals lat/lon are rounded to in
+// /api/waypoints and /api/clusters responses (WHEREAMI_COORD_PRECISION, 0-15;
+// -1 = full precision, the default), resolved once. Stored coordinates are
+// never rounded.
+func coordPrecision() int {
+	coordPrecisionOnce.Do(func() {
+		coordPrecisionValue = -1
+		if v := os.Getenv("WHEREAMI_COORD_PRECISION"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n >= 0 && n <= 15 {
+				coordPrecisionValue = n
+			} else {
+				logger.Warn("ignoring invalid WHEREAMI_COORD_PRECISION=%q", v)
+			}
+		}
+	})
+	return coordPrecisionValue
+}
+
+// roundCoord rounds v to prec decimals (unchanged when prec < 0).
+func roundCoord(v float64, prec int) float64 {
+	if prec < 0 {
+		return v
+	}
+	return roundTo(v, prec)
+}
+
+// GET /api/waypoints[?bbox=minLon,minLat,maxLon,maxLat][&source=][&emoji=true][&limit=&offset=]
+// With bbox only waypoints inside the box are returned (minLon > maxLon
+// selects a box crossing the antimeridian); source=bookmark|imported|all
+// (default all) selects on the Bookmark flag. limit/offset page through the
+// filtered list in store order, which is stable between calls while the
+// waypoint set is unchanged; X-Total-Count carries the unpaginated total.
+func handleGetWaypoints(w http.ResponseWriter, r *http.Request) {
+	var bbox []float64
+	if b := r.URL.Query().Get("bbox"); b != "" {
+		minLon, minLat, maxLon, maxLat, err := parseWrappingBBox(b)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		bbox = []float64{minLon, minLat, maxLon, maxLat}
+	}
+	source := strings.ToLower(r.URL.Query().Get("source"))
+	switch source {
+	case "":
+		source = "all"
+	case "all", "bookmark", "imported":
+	default:
+		http.Error(w, "source must be bookmark, imported or all", http.StatusBadRequest)
+		return
+	}
+	limit, offset := -1, 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+
+	// Copy a snapshot first (avoid holding the store lock while querying tag DB)
+	var snap []Waypoint
+	if bbox == nil && source == "all" {
+		snap = waypointStore.Snapshot()
+	} else {
+		snap = waypointStore.Filter(func(wp Waypoint) bool {
+			if source == "bookmark" && !wp.Bookmark || source == "imported" && wp.Bookmark {
+				return false
+			}
+			return bbox == nil || bboxContains(bbox[0], bbox[1], bbox[2], bbox[3], wp.Lat, wp.Lon)
+		})
+	}
 
-// ---------------- Waypoints & Clustering ----------------
-
-func handleGetWaypoints(w http.ResponseWriter, r *http.Request) {
-	// Copy snapshot under lock first (avoid holding lock while querying tag DB)
-	allWaypointsMu.RLock()
-	snap := make([]Waypoint, len(allWaypoints))
-	copy(snap, allWaypoints)
-	allWaypointsMu.RUnlock()
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(snap)))
+	snap = snap[min(offset, len(snap)):]
+	if limit >= 0 && limit < len(snap) {
+		snap = snap[:limit]
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 
 	useEmoji := false
-	if r != nil && strings.EqualFold(r.URL.Query().Get("emoji"), "true") {
+	if strings.EqualFold(r.URL.Query().Get("emoji"), "true") {
 		useEmoji = true
 	}
+	prec := coordPrecision()
 
 	// If tag DB not initialized just return the raw snapshot (cannot enrich)
-	if tagDB == nil {
-		_ = json.NewEncoder(w).Encode(snap)
+	if tagDB.Load() == nil {
+		if prec < 0 {
+			_ = json.NewEncoder(w).Encode(snap)
+			return
+		}
+		// IDs are derived from the full-precision coordinates before rounding.
+		rounded := make([]waypointJSON, len(snap))
+		for i, wp := range snap {
+			rounded[i] = wp.toJSON()
+			rounded[i].Lat, rounded[i].Lon = roundCoord(wp.Lat, prec), roundCoord(wp.Lon, prec)
+		}
+		_ = json.NewEncoder(w).Encode(rounded)
 		return
 	}
 
+	// Global tag frequencies drive primary tag selection (emoji mode only).
+	// ?primary=common picks the most common tag instead of the most distinctive.
+	var tagFreq map[string]int
+	var tagColors map[string]string
+	preferCommon := false
+	if useEmoji {
+		preferCommon = strings.EqualFold(r.URL.Query().Get("primary"), "common")
+		tagColors = getTagColors()
+		if f, err := getTagFrequencies(); err == nil {
+			tagFreq = f
+		} else {
+			logger.DebugCtx(r.Context(), "/api/waypoints tag frequency query failed: %v", err)
+		}
+	}
+
 	out := make([]map[string]any, 0, len(snap))
 	for _, wp := range snap {
 		obj := map[string]any{
+			"id":       waypointID(wp),
 			"name":     wp.Name,
-			"lat":      wp.Lat,
-			"lon":      wp.Lon,
+			"lat":      roundCoord(wp.Lat, prec),
+			"lon":      roundCoord(wp.Lon, prec),
 			"bookmark": wp.Bookmark,
 		}
 		if wp.Ele != 0 {
@@ -771,15 +2884,21 @@ func handleGetWaypoints(w http.ResponseWriter, r *http.Request) {
 		if wp.Desc != "" {
 			obj["desc"] = wp.Desc
 		}
+		if wp.Extensions != "" {
+			obj["extensions"] = wp.Extensions
+		}
 		if wp.Name != "" {
 			if tags, err := getTagsFor(wp.Name, wp.Lat, wp.Lon); err == nil && len(tags) > 0 {
 				if useEmoji {
 					unified := unifyDistinctTags(tags)
 					enriched := make([]TagDTO, 0, len(unified))
 					for _, t := range unified {
-						enriched = append(enriched, enrichTag(t))
+						enriched = append(enriched, enrichTag(t, tagColors))
 					}
 					obj["tags"] = enriched
+					if primary := pickPrimaryTag(unified, tagFreq, preferCommon); primary != "" {
+						obj["primary_tag"] = enrichTag(primary, tagColors)
+					}
 				} else {
 					obj["tags"] = tags
 				}
@@ -791,6 +2910,271 @@ func handleGetWaypoints(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(out)
 }
 
+// maxNearestLimit caps GET /api/waypoints/nearest results.
+const maxNearestLimit = 200
+
+// GET /api/waypoints/nearest?lat=&lon=&limit=
+// Returns the limit (default 10, max 200) closest waypoints, each with an added
+// distance_m. Without lat/lon the current GeoClue fix is used (503 if unknown).
+func handleGetNearestWaypoints(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var lat, lon float64
+	if q.Get("lat") == "" && q.Get("lon") == "" {
+		ensureLocationTracking()
+		fix, ok := GetCurrentLocation()
+		if !ok {
+			http.Error(w, "location unknown (pass lat/lon)", http.StatusServiceUnavailable)
+			return
+		}
+		lat, lon = fix.Latitude, fix.Longitude
+	} else {
+		var err1, err2 error
+		lat, err1 = strconv.ParseFloat(q.Get("lat"), 64)
+		lon, err2 = strconv.ParseFloat(q.Get("lon"), 64)
+		if err1 != nil || err2 != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+			http.Error(w, "invalid lat/lon", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := 10
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxNearestLimit)
+	}
+
+	type ranked struct {
+		wp Waypoint
+		d  float64
+	}
+	var all []ranked
+	waypointStore.View(func(wps []Waypoint) {
+		all = make([]ranked, 0, len(wps))
+		for _, wp := range wps {
+			all = append(all, ranked{wp, distanceMeters(lat, lon, wp.Lat, wp.Lon)})
+		}
+	})
+	sort.SliceStable(all, func(i, j int) bool { return all[i].d < all[j].d })
+	if len(all) > limit {
+		all = all[:limit]
+	}
+
+	out := make([]map[string]any, 0, len(all))
+	for _, rk := range all {
+		obj := map[string]any{
+			"id":         waypointID(rk.wp),
+			"name":       rk.wp.Name,
+			"lat":        rk.wp.Lat,
+			"lon":        rk.wp.Lon,
+			"bookmark":   rk.wp.Bookmark,
+			"distance_m": rk.d,
+		}
+		if rk.wp.Ele != 0 {
+			obj["ele"] = rk.wp.Ele
+		}
+		if rk.wp.Time != "" {
+			obj["time"] = rk.wp.Time
+		}
+		if rk.wp.Desc != "" {
+			obj["desc"] = rk.wp.Desc
+		}
+		out = append(out, obj)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// waypointRef identifies a waypoint by name + coordinates in request payloads.
+type waypointRef struct {
+	Name string  `json:"name"`
+	Lat  float64 `json:"lat"`
+	Lon  float64 `json:"lon"`
+}
+
+// waypointByID returns the stored waypoint whose waypointID is id. Clients
+// may use it instead of the (name, lat, lon) triple to select a waypoint.
+func waypointByID(id string) (Waypoint, bool) {
+	return waypointStore.FindFunc(func(wp Waypoint) bool { return waypointID(wp) == id })
+}
+
+// resolveWaypointID looks up id, writing a 404 and returning false when no
+// waypoint has it.
+func resolveWaypointID(w http.ResponseWriter, id string) (Waypoint, bool) {
+	wp, ok := waypointByID(strings.TrimSpace(id))
+	if !ok {
+		http.Error(w, "unknown waypoint id", http.StatusNotFound)
+	}
+	return wp, ok
+}
+
+// PATCH /api/waypoints/batch
+//
+//	{
+//	  "selector": { "bbox": [minLon,minLat,maxLon,maxLat], "tag": "...", "waypoints": [{name,lat,lon}, ...] },
+//	  "ops":      { "desc": "template {name} {lat} {lon} {desc}", "addTags": [...], "removeTags": [...] }
+//	}
+//
+// Selector fields are combined (intersection); at least one is required. Tag
+// changes apply to every selected waypoint in one transaction; descriptions
+// are rewritten for selected bookmarks only, in a single writeBookmarks pass.
+func handlePatchWaypointsBatch(bookmarksPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Selector struct {
+				BBox      []float64     `json:"bbox"`
+				Tag       string        `json:"tag"`
+				Waypoints []waypointRef `json:"waypoints"`
+			} `json:"selector"`
+			Ops struct {
+				Desc       *string  `json:"desc"`
+				AddTags    []string `json:"addTags"`
+				RemoveTags []string `json:"removeTags"`
+			} `json:"ops"`
+		}
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+		sel := req.Selector
+		if len(sel.BBox) == 0 && strings.TrimSpace(sel.Tag) == "" && len(sel.Waypoints) == 0 {
+			http.Error(w, "selector requires bbox, tag or waypoints", http.StatusBadRequest)
+			return
+		}
+		if len(sel.BBox) != 0 && len(sel.BBox) != 4 {
+			http.Error(w, "bbox must be [minLon,minLat,maxLon,maxLat]", http.StatusBadRequest)
+			return
+		}
+		if req.Ops.Desc == nil && len(req.Ops.AddTags) == 0 && len(req.Ops.RemoveTags) == 0 {
+			http.Error(w, "no operations given", http.StatusBadRequest)
+			return
+		}
+
+		// Resolve tag selector up front (set of waypoint keys carrying the tag)
+		var tagged map[string]struct{}
+		if t := normalizeTagKey(sel.Tag); t != "" {
+			tagged = make(map[string]struct{})
+			if tagDB.Load() != nil {
+				rows, err := tagDB.Load().Query(`SELECT name, lat, lon, tag FROM waypoint_tags`)
+				if err != nil {
+					http.Error(w, "query error: "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+				for rows.Next() {
+					var name, tag string
+					var lat, lon float64
+					if err := rows.Scan(&name, &lat, &lon, &tag); err == nil && normalizeTagKey(tag) == t {
+						tagged[waypointKey(Waypoint{Name: name, Lat: lat, Lon: lon})] = struct{}{}
+					}
+				}
+				rows.Close()
+			}
+		}
+		var listed map[string]struct{}
+		if len(sel.Waypoints) > 0 {
+			listed = make(map[string]struct{}, len(sel.Waypoints))
+			for _, ref := range sel.Waypoints {
+				listed[waypointKey(Waypoint{Name: ref.Name, Lat: ref.Lat, Lon: ref.Lon})] = struct{}{}
+			}
+		}
+
+		selected := waypointStore.Filter(func(wp Waypoint) bool {
+			if len(sel.BBox) == 4 && (wp.Lon < sel.BBox[0] || wp.Lat < sel.BBox[1] || wp.Lon > sel.BBox[2] || wp.Lat > sel.BBox[3]) {
+				return false
+			}
+			k := waypointKey(wp)
+			if tagged != nil {
+				if _, ok := tagged[k]; !ok {
+					return false
+				}
+			}
+			if listed != nil {
+				if _, ok := listed[k]; !ok {
+					return false
+				}
+			}
+			return true
+		})
+
+		added, removed, err := batchUpdateTags(selected, req.Ops.AddTags, req.Ops.RemoveTags)
+		if err != nil {
+			http.Error(w, "tag update error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		descUpdated, descTruncated := 0, 0
+		if req.Ops.Desc != nil {
+			selBookmarks := make(map[string]struct{})
+			for _, wp := range selected {
+				if wp.Bookmark {
+					selBookmarks[waypointKey(wp)] = struct{}{}
+				}
+			}
+			newDescs := make(map[string]string)
+			descUpdated, err = updateBookmarkDescs(bookmarksPath, func(wp Waypoint) (string, bool) {
+				k := waypointKey(wp)
+				if _, ok := selBookmarks[k]; !ok {
+					return "", false
+				}
+				desc, truncated, _ := limitDesc(expandDescTemplate(*req.Ops.Desc, wp), true)
+				if truncated && desc != wp.Desc {
+					descTruncated++ // only descriptions actually rewritten count
+				}
+				newDescs[k] = desc
+				return desc, true
+			})
+			if err != nil {
+				http.Error(w, "description update error: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			waypointStore.UpdateAll(func(wp *Waypoint) bool {
+				if !wp.Bookmark {
+					return false
+				}
+				desc, ok := newDescs[waypointKey(*wp)]
+				if ok {
+					wp.Desc = desc
+				}
+				return ok
+			})
+		}
+
+		logger.DebugCtx(r.Context(), "PATCH /api/waypoints/batch selected=%d tagsAdded=%d tagsRemoved=%d descUpdated=%d",
+			len(selected), added, removed, descUpdated)
+		if added+removed > 0 || descUpdated > 0 {
+			refs := make([]map[string]any, 0, len(selected))
+			for _, wp := range selected {
+				refs = append(refs, waypointEventData(wp.Name, wp.Lat, wp.Lon))
+			}
+			if added+removed > 0 {
+				publishEvent(eventTagsChanged, map[string]any{"waypoints": refs, "added": req.Ops.AddTags, "removed": req.Ops.RemoveTags})
+			}
+			if descUpdated > 0 {
+				publishEvent(eventBookmarkUpdated, map[string]any{"waypoints": refs, "desc_updated": descUpdated})
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"selected":       len(selected),
+			"tags_added":     added,
+			"tags_removed":   removed,
+			"desc_updated":   descUpdated,
+			"desc_truncated": descTruncated,
+		})
+	}
+}
+
+// expandDescTemplate substitutes {name}, {lat}, {lon} and {desc} in a batch description template.
+func expandDescTemplate(tmpl string, wp Waypoint) string {
+	return strings.NewReplacer(
+		"{name}", wp.Name,
+		"{lat}", strconv.FormatFloat(wp.Lat, 'f', 6, 64),
+		"{lon}", strconv.FormatFloat(wp.Lon, 'f', 6, 64),
+		"{desc}", wp.Desc,
+	).Replace(tmpl)
+}
+
 func handleGetClusters(w http.ResponseWriter, r *http.Request) {
 	zoom := 0
 	if zStr := r.URL.Query().Get("zoom"); zStr != "" {
@@ -801,7 +3185,7 @@ func handleGetClusters(w http.ResponseWriter, r *http.Request) {
 	if zoom < 0 {
 		zoom = 0
 	}
-	grid := 60
+	grid := defaultClusterGrid
 	if gStr := r.URL.Query().Get("grid"); gStr != "" {
 		if g, err := strconv.Atoi(gStr); err == nil && g >= 8 && g <= 512 {
 			grid = g
@@ -816,115 +3200,396 @@ func handleGetClusters(w http.ResponseWriter, r *http.Request) {
 		// Support alternate param name ?bookmarks=1
 		bookmarksOnly = true
 	}
-	logger.Debug("/api/clusters zoom=%d grid=%d bookmarksOnly=%v", zoom, grid, bookmarksOnly)
+	// Optional: never merge bookmarks into clusters (they stay individually clickable).
+	keepBookmarks := false
+	if k := r.URL.Query().Get("keepBookmarks"); k == "1" || strings.EqualFold(k, "true") {
+		keepBookmarks = true
+	}
+	// Optional: cluster by great-circle distance instead of the pixel grid.
+	var radiusM float64
+	if v := r.URL.Query().Get("radiusMeters"); v != "" {
+		m, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(m > 0) || m > maxClusterRadiusMeters {
+			http.Error(w, "radiusMeters must be in (0, 1000000]", http.StatusBadRequest)
+			return
+		}
+		radiusM = m
+	}
+	// Optional: at or above declusterZoom every waypoint is returned individually.
+	declusterZoom := -1
+	if v := r.URL.Query().Get("declusterZoom"); v != "" {
+		if z, err := strconv.Atoi(v); err == nil && z >= 0 {
+			declusterZoom = z
+		}
+	}
+	logger.DebugCtx(r.Context(), "/api/clusters zoom=%d grid=%d radiusMeters=%g declusterZoom=%d bookmarksOnly=%v keepBookmarks=%v", zoom, grid, radiusM, declusterZoom, bookmarksOnly, keepBookmarks)
+
+	// Optional viewport: only return clusters in view (O(visible) on the tree path).
+	// minLon > maxLon selects a box crossing the antimeridian.
+	var bbox []float64
+	if b := r.URL.Query().Get("bbox"); b != "" {
+		minLon, minLat, maxLon, maxLat, err := parseWrappingBBox(b)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		bbox = []float64{minLon, minLat, maxLon, maxLat}
+	}
 
-	allWaypointsMu.RLock()
-	points := make([]Waypoint, len(allWaypoints))
-	copy(points, allWaypoints)
-	allWaypointsMu.RUnlock()
+	var items []clusterItem
+	if declusterZoom >= 0 && zoom >= declusterZoom {
+		waypointStore.View(func(wps []Waypoint) {
+			for _, wp := range wps {
+				if bookmarksOnly && !wp.Bookmark {
+					continue
+				}
+				if bbox != nil && !bboxContains(bbox[0], bbox[1], bbox[2], bbox[3], wp.Lat, wp.Lon) {
+					continue
+				}
+				items = append(items, clusterItem{lat: wp.Lat, lon: wp.Lon, count: 1, wp: wp})
+			}
+		})
+	} else if radiusM == 0 && grid == defaultClusterGrid && zoom <= clusterTreeMaxZoom {
+		// Served from the precomputed hierarchy (rebuilt only when waypoints change)
+		t := getClusterTree(clusterVariant{bookmarksOnly: bookmarksOnly, keepBookmarks: keepBookmarks})
+		items = t.query(zoom, bbox)
+	} else {
+		points := waypointStore.Snapshot()
+		if radiusM > 0 {
+			items = buildRadiusClusters(points, radiusM, bookmarksOnly, keepBookmarks)
+		} else {
+			items = buildClusters(points, zoom, grid, bookmarksOnly, keepBookmarks)
+		}
+		if bbox != nil {
+			filtered := items[:0]
+			for _, it := range items {
+				if bboxContains(bbox[0], bbox[1], bbox[2], bbox[3], it.lat, it.lon) {
+					filtered = append(filtered, it)
+				}
+			}
+			items = filtered
+		}
+	}
 
-	type bucket struct {
-		sumLat, sumLon float64
-		minX, maxX     float64
-		minY, maxY     float64
-		count          int
-		wps            []Waypoint
+	prec := coordPrecision()
+	var out []map[string]any
+	for _, it := range items {
+		m := it.toJSON()
+		if prec >= 0 {
+			m["lat"], m["lon"] = roundCoord(m["lat"].(float64), prec), roundCoord(m["lon"].(float64), prec)
+			if b, ok := m["bounds"].(map[string]float64); ok {
+				for k, v := range b {
+					b[k] = roundCoord(v, prec)
+				}
+			}
+		}
+		if it.cluster && radiusM == 0 {
+			// Grid cell, for GET /api/clusters/expand
+			m["bx"], m["by"] = it.cx, it.cy
+		}
+		out = append(out, m)
 	}
-	buckets := make(map[string]*bucket)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
 
-	for _, wp := range points {
-		if bookmarksOnly && !wp.Bookmark {
-			continue
+// --------------- Location ---------------
+
+// ensureLocationTracking lazily starts GeoClue tracking on first use.
+func ensureLocationTracking() {
+	locationOnce.Do(func() {
+		if err := InitLocationTracking("io.github.rubiojr.whereami.desktop"); err != nil {
+			logger.Error("Location init error: %v", err)
 		}
-		lat := wp.Lat
-		lon := wp.Lon
-		sinLat := math.Sin(lat * math.Pi / 180)
-		n := math.Exp2(float64(zoom))
-		x := (lon + 180.0) / 360.0 * 256.0 * n
-		y := (0.5 - math.Log((1+sinLat)/(1-sinLat))/(4*math.Pi)) * 256.0 * n
-		bx := int(x / float64(grid))
-		by := int(y / float64(grid))
-		key := fmt.Sprintf("%d:%d", bx, by)
-		b := buckets[key]
-		if b == nil {
-			b = &bucket{minX: x, maxX: x, minY: y, maxY: y}
-			buckets[key] = b
+	})
+}
+
+// GET /api/location[?raw=true]
+// Returns the current fix (smoothed when WHEREAMI_LOCATION_SMOOTH_N is set;
+// raw=true returns the latest unsmoothed fix) or 204 when there is none.
+func handleGetLocation(w http.ResponseWriter, r *http.Request) {
+	ensureLocationTracking()
+	get := GetCurrentLocation
+	if isTruthy(r.URL.Query().Get("raw")) {
+		get = GetRawLocation
+	}
+	fix, ok := get()
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(fix)
+}
+
+// POST /api/location  JSON: { lat, lon, accuracy_m?, altitude_m? }
+// Moves the mock position (WHEREAMI_MOCK_LOCATION mode only; 409 otherwise)
+// and returns the stored fix.
+func handlePostLocation(w http.ResponseWriter, r *http.Request) {
+	ensureLocationTracking()
+	if !locationMock {
+		http.Error(w, "mock location mode is not enabled (set WHEREAMI_MOCK_LOCATION)", http.StatusConflict)
+		return
+	}
+	var fix LocationFix
+	if !decodeJSONBody(w, r, &fix) {
+		return
+	}
+	if fix.Latitude < -90 || fix.Latitude > 90 || fix.Longitude < -180 || fix.Longitude > 180 || fix.Accuracy < 0 {
+		http.Error(w, "invalid lat/lon/accuracy", http.StatusBadRequest)
+		return
+	}
+	setLocationFix(fix)
+	fix, _ = GetCurrentLocation()
+	logger.DebugCtx(r.Context(), "POST /api/location mock lat=%.6f lon=%.6f", fix.Latitude, fix.Longitude)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(fix)
+}
+
+// GET /api/location/share[?zoom=16]
+// Returns shareable links for the current fix ({ lat, lon, accuracy_m?, geo_uri,
+// osm_url, google_url }) or 204 when there is no fix.
+func handleGetLocationShare(w http.ResponseWriter, r *http.Request) {
+	ensureLocationTracking()
+	fix, ok := GetCurrentLocation()
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	zoom := 16
+	if v := r.URL.Query().Get("zoom"); v != "" {
+		if z, err := strconv.Atoi(v); err == nil && z >= 0 && z <= 19 {
+			zoom = z
 		}
-		if x < b.minX {
-			b.minX = x
+	}
+	lat := strconv.FormatFloat(fix.Latitude, 'f', 6, 64)
+	lon := strconv.FormatFloat(fix.Longitude, 'f', 6, 64)
+	geo := "geo:" + lat + "," + lon
+	if fix.Accuracy > 0 {
+		geo += ";u=" + strconv.FormatFloat(math.Round(fix.Accuracy), 'f', 0, 64) // RFC 5870 uncertainty (m)
+	}
+	out := map[string]any{
+		"lat":        fix.Latitude,
+		"lon":        fix.Longitude,
+		"geo_uri":    geo,
+		"osm_url":    fmt.Sprintf("https://www.openstreetmap.org/?mlat=%s&mlon=%s#map=%d/%s/%s", lat, lon, zoom, lat, lon),
+		"google_url": "https://www.google.com/maps/search/?api=1&query=" + lat + "," + lon,
+		"timestamp":  fix.Timestamp,
+	}
+	if fix.Accuracy > 0 {
+		out["accuracy_m"] = fix.Accuracy
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// Default view fallback when neither location nor bookmarks are available.
+const (
+	defaultViewZoom        = 2.0
+	defaultViewLocateZoom  = 14.0 // zoom used when centering on a single point
+	defaultViewMaxZoom     = 16.0
+	defaultViewViewportPx  = 800.0 // assumed viewport size when fitting bounds
+	defaultViewTileSizePx  = 256.0
+	defaultViewPaddingFrac = 0.1
+)
+
+// parseDefaultView parses WHEREAMI_DEFAULT_VIEW ("lat,lon,zoom").
+func parseDefaultView(v string) (lat, lon, zoom float64, ok bool) {
+	parts := strings.Split(v, ",")
+	if len(parts) != 3 {
+		return 0, 0, 0, false
+	}
+	var vals [3]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return 0, 0, 0, false
 		}
-		if x > b.maxX {
-			b.maxX = x
+		vals[i] = f
+	}
+	lat, lon, zoom = vals[0], vals[1], vals[2]
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 || zoom < 0 || zoom > maxTileZoom {
+		return 0, 0, 0, false
+	}
+	return lat, lon, zoom, true
+}
+
+// fitZoom returns the largest zoom at which the bounds fit an assumed viewport.
+func fitZoom(minLat, minLon, maxLat, maxLon float64) float64 {
+	latSpan := maxLat - minLat
+	lonSpan := maxLon - minLon
+	if latSpan <= 0 && lonSpan <= 0 {
+		return defaultViewLocateZoom
+	}
+	pad := 1 + 2*defaultViewPaddingFrac
+	tiles := defaultViewViewportPx / defaultViewTileSizePx
+	zoom := defaultViewMaxZoom
+	if lonSpan > 0 {
+		zoom = math.Min(zoom, math.Log2(360*tiles/(lonSpan*pad)))
+	}
+	if latSpan > 0 {
+		// Web Mercator y spans 2*pi over the whole world
+		mercY := func(lat float64) float64 {
+			s := math.Sin(math.Max(math.Min(lat, 85), -85) * math.Pi / 180)
+			return math.Log((1+s)/(1-s)) / 2
 		}
-		if y < b.minY {
-			b.minY = y
+		if span := (mercY(maxLat) - mercY(minLat)) * pad; span > 0 {
+			zoom = math.Min(zoom, math.Log2(2*math.Pi*tiles/span))
 		}
-		if y > b.maxY {
-			b.maxY = y
+	}
+	return math.Max(0, math.Floor(zoom))
+}
+
+// GET /api/map/default-view returns the initial viewport for the map:
+// the current location if known, else the bounds of all bookmarks, else
+// WHEREAMI_DEFAULT_VIEW=lat,lon,zoom, else a world view.
+func handleGetDefaultView(w http.ResponseWriter, r *http.Request) {
+	ensureLocationTracking()
+	w.Header().Set("Content-Type", "application/json")
+
+	if fix, ok := GetCurrentLocation(); ok {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"lat":    fix.Latitude,
+			"lon":    fix.Longitude,
+			"zoom":   defaultViewLocateZoom,
+			"source": "location",
+		})
+		return
+	}
+
+	var n int
+	var minLat, minLon, maxLat, maxLon float64
+	waypointStore.View(func(wps []Waypoint) {
+		for _, wp := range wps {
+			if !wp.Bookmark {
+				continue
+			}
+			if n == 0 {
+				minLat, maxLat, minLon, maxLon = wp.Lat, wp.Lat, wp.Lon, wp.Lon
+			} else {
+				minLat, maxLat = math.Min(minLat, wp.Lat), math.Max(maxLat, wp.Lat)
+				minLon, maxLon = math.Min(minLon, wp.Lon), math.Max(maxLon, wp.Lon)
+			}
+			n++
 		}
-		b.sumLat += lat
-		b.sumLon += lon
-		b.count++
-		b.wps = append(b.wps, wp)
+	})
+	if n > 0 {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"lat":    (minLat + maxLat) / 2,
+			"lon":    (minLon + maxLon) / 2,
+			"zoom":   fitZoom(minLat, minLon, maxLat, maxLon),
+			"bounds": []float64{minLon, minLat, maxLon, maxLat},
+			"count":  n,
+			"source": "bookmarks",
+		})
+		return
 	}
 
-	var out []map[string]any
-	for _, b := range buckets {
-		if b.count == 1 {
-			wp := b.wps[0]
-			out = append(out, map[string]any{
-				"type":     "waypoint",
-				"lat":      wp.Lat,
-				"lon":      wp.Lon,
-				"name":     wp.Name,
-				"bookmark": wp.Bookmark,
-			})
-		} else {
-			centerX := (b.minX + b.maxX) / 2
-			centerY := (b.minY + b.maxY) / 2
-			scale := 256.0 * math.Exp2(float64(zoom))
-			lon := (centerX/scale)*360.0 - 180.0
-			normY := centerY / scale
-			lat := math.Atan(math.Sinh(math.Pi*(1-2*normY))) * 180.0 / math.Pi
-			out = append(out, map[string]any{
-				"type":  "cluster",
-				"lat":   lat,
-				"lon":   lon,
-				"count": b.count,
+	if v := os.Getenv("WHEREAMI_DEFAULT_VIEW"); v != "" {
+		if lat, lon, zoom, ok := parseDefaultView(v); ok {
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"lat":    lat,
+				"lon":    lon,
+				"zoom":   zoom,
+				"source": "config",
 			})
+			return
 		}
+		logger.DebugCtx(r.Context(), "ignoring invalid WHEREAMI_DEFAULT_VIEW=%q (want lat,lon,zoom)", v)
 	}
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"lat":    0.0,
+		"lon":    0.0,
+		"zoom":   defaultViewZoom,
+		"source": "default",
+	})
+}
+
+// --------------- Import GPX / KML ---------------
+
+// GET /api/imports/errors
+// Lists waypoint files that failed to parse during the last rebuild and any
+// imports since then.
+func handleGetImportErrors(w http.ResponseWriter, _ *http.Request) {
+	errs := getParseErrors()
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(out)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"count":  len(errs),
+		"errors": errs,
+	})
+}
+
+// importWorkers returns the import copy/parse pool size (WHEREAMI_IMPORT_WORKERS, default NumCPU).
+func importWorkers() int {
+	if v := os.Getenv("WHEREAMI_IMPORT_WORKERS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return runtime.NumCPU()
+}
+
+// copyFile copies src to a newly created dest.
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	// Copy to a temp file and rename so an overwritten import is never left half-written.
+	tmp := dest + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+// fileSHA256 returns the hex SHA-256 of a file's contents.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-// --------------- Location ---------------
-
-func handleGetLocation(w http.ResponseWriter, _ *http.Request) {
-	locationOnce.Do(func() {
-		if err := InitLocationTracking("io.github.rubiojr.whereami.desktop"); err != nil {
-			logger.Error("Location init error: %v", err)
-		}
-	})
-	locationMu.RLock()
-	defer locationMu.RUnlock()
-	if !locationValid {
-		w.WriteHeader(http.StatusNoContent)
-		return
+// sameFileContent reports whether a and b have identical contents (by SHA-256).
+func sameFileContent(a, b string) (bool, error) {
+	ha, err := fileSHA256(a)
+	if err != nil {
+		return false, err
 	}
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(currentLocation)
+	hb, err := fileSHA256(b)
+	if err != nil {
+		return false, err
+	}
+	return ha == hb, nil
 }
 
-// --------------- Import GPX ---------------
-
 func handlePostImport(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Dir       string `json:"dir"`
-		Recursive bool   `json:"recursive"`
+		Dir             string   `json:"dir"`
+		Recursive       bool     `json:"recursive"`
+		Tags            []string `json:"tags,omitempty"`            // applied to every imported waypoint
+		TagFromFilename bool     `json:"tagFromFilename,omitempty"` // tag each waypoint with its file's base name
+		Overwrite       bool     `json:"overwrite,omitempty"`       // replace existing imports whose content changed
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &req) {
 		return
 	}
 	if req.Dir == "" {
@@ -947,8 +3612,17 @@ func handlePostImport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var importedFiles []string
+	// Walk serially to fix a stable file order; copy + parse then run in parallel.
+	type importJob struct {
+		src, dest string
+		replace   bool // overwrites an earlier import of the same name
+		copied    bool
+		wps       []Waypoint
+		tracks    []Track
+	}
+	var jobs []*importJob
 	var skipped []string
+	claimed := make(map[string]bool) // dest names taken earlier in this walk
 	err = filepath.WalkDir(req.Dir, func(p string, d os.DirEntry, walkErr error) error {
 		if walkErr != nil {
 			return walkErr
@@ -959,26 +3633,29 @@ func handlePostImport(w http.ResponseWriter, r *http.Request) {
 			}
 			return nil
 		}
-		if !strings.EqualFold(filepath.Ext(d.Name()), ".gpx") {
+		if !isWaypointFile(d.Name()) {
 			return nil
 		}
 		destPath := filepath.Join(importBase, d.Name())
-		if _, err := os.Stat(destPath); err == nil {
+		if claimed[destPath] {
 			skipped = append(skipped, d.Name())
 			return nil
 		}
-		src, err := os.Open(p)
-		if err != nil {
-			return nil
-		}
-		defer src.Close()
-		dst, err := os.Create(destPath)
-		if err != nil {
-			return nil
+		replace := false
+		if _, err := os.Stat(destPath); err == nil {
+			// Existing import: skipped unless overwriting, and then only when the content changed.
+			same, err := sameFileContent(p, destPath)
+			if !req.Overwrite || err != nil || same {
+				if err != nil {
+					logger.DebugCtx(r.Context(), "/api/import compare %s failed: %v", p, err)
+				}
+				skipped = append(skipped, d.Name())
+				return nil
+			}
+			replace = true
 		}
-		defer dst.Close()
-		_, _ = io.Copy(dst, src)
-		importedFiles = append(importedFiles, destPath)
+		claimed[destPath] = true
+		jobs = append(jobs, &importJob{src: p, dest: destPath, replace: replace})
 		return nil
 	})
 	if err != nil {
@@ -986,22 +3663,112 @@ func handlePostImport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	workers := importWorkers()
+	jobCh := make(chan *importJob)
+	var wg sync.WaitGroup
+	for i := 0; i < min(workers, len(jobs)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobCh {
+				if err := copyFile(job.src, job.dest); err != nil {
+					logger.DebugCtx(r.Context(), "/api/import copy %s failed: %v", job.src, err)
+					continue
+				}
+				job.copied = true
+				if wps, err := parseWaypointFile(job.dest); err == nil {
+					job.wps = wps
+				} else {
+					addParseError(job.dest, err)
+				}
+				if strings.EqualFold(filepath.Ext(job.dest), ".gpx") {
+					job.tracks, _ = parseGPXTracks(job.dest)
+				}
+			}
+		}()
+	}
+	for _, job := range jobs {
+		jobCh <- job
+	}
+	close(jobCh)
+	wg.Wait()
+
+	// Merge in walk order so dedupe results stay deterministic.
+	var importedFiles []string
+	updated := []string{}
 	var newly []Waypoint
-	for _, f := range importedFiles {
-		if wps, err := parseGPXFile(f); err == nil {
-			newly = append(newly, wps...)
+	var tracks []Track
+	perFile := make(map[string][]Waypoint, len(jobs))
+	for _, job := range jobs {
+		if !job.copied {
+			continue
 		}
+		importedFiles = append(importedFiles, job.dest)
+		if job.replace {
+			updated = append(updated, filepath.Base(job.dest))
+			removeTracksBySource(job.dest)
+			if err := forgetTracksBySource(job.dest); err != nil {
+				logger.ErrorCtx(r.Context(), "/api/import forgetting tracks of %s failed: %v", job.dest, err)
+			}
+		}
+		tracks = append(tracks, job.tracks...)
+		if job.wps != nil {
+			newly = append(newly, job.wps...)
+			perFile[job.dest] = job.wps
+		}
+	}
+	logger.DebugCtx(r.Context(), "/api/import files=%d workers=%d waypoints=%d tracks=%d", len(importedFiles), workers, len(newly), len(tracks))
+	addTracks(tracks)
+	if err := persistTracks(tracks); err != nil {
+		logger.ErrorCtx(r.Context(), "/api/import persisting %d tracks failed: %v", len(tracks), err)
 	}
 
 	var dedupCount int
-	if len(newly) > 0 {
-		allWaypointsMu.Lock()
-		combined := append(allWaypoints, newly...)
-		allWaypoints = DedupeWaypoints(combined)
-		dedupCount = len(allWaypoints)
-		allWaypointsMu.Unlock()
+	if len(updated) > 0 {
+		// Replaced files may have dropped or moved waypoints: rebuild from disk
+		// rather than merging (the rebuild includes the new files too), then
+		// drop the tags of the waypoints that are gone.
+		before := waypointStore.Snapshot()
+		dedupCount = waypointStore.Replace(RebuildAllWaypoints(filepath.Join(dir, "bookmarks.gpx"), dir))
+		if n := pruneRemovedWaypointTags(before); n > 0 {
+			logger.InfoCtx(r.Context(), "/api/import removed %d tag row(s) of waypoints dropped by re-import", n)
+		}
+	} else if len(newly) > 0 {
+		dedupCount = waypointStore.Merge(newly)
 	} else {
-		dedupCount = len(allWaypoints)
+		dedupCount = waypointStore.Len()
+	}
+
+	// Auto-tag imported waypoints (best-effort; non-fatal on error)
+	var tagged int
+	if len(req.Tags) > 0 || req.TagFromFilename {
+		for f, wps := range perFile {
+			tags := append([]string(nil), req.Tags...)
+			if req.TagFromFilename {
+				tags = append(tags, strings.TrimSuffix(filepath.Base(f), filepath.Ext(f)))
+			}
+			for _, wp := range wps {
+				if wp.Name == "" {
+					continue
+				}
+				if err := addTagsToDB(wp.Name, wp.Lat, wp.Lon, tags); err != nil {
+					logger.DebugCtx(r.Context(), "import tag insert error for %q: %v", wp.Name, err)
+					continue
+				}
+				tagged++
+			}
+		}
+		logger.DebugCtx(r.Context(), "/api/import tagged %d waypoint(s)", tagged)
+	}
+	if len(importedFiles) > 0 {
+		publishEvent(eventImportCompleted, map[string]any{
+			"files":         len(importedFiles),
+			"count":         len(newly),
+			"tracks":        len(tracks),
+			"updated_files": updated,
+			"dedup_count":   dedupCount,
+			"tagged":        tagged,
+		})
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -1012,7 +3779,11 @@ func handlePostImport(w http.ResponseWriter, r *http.Request) {
 		"files":         len(importedFiles),
 		"skipped_files": skipped,
 		"skipped":       len(skipped),
+		"updated_files": updated,
+		"updated":       len(updated),
 		"dedup_count":   dedupCount,
+		"tagged":        tagged,
+		"tracks":        len(tracks),
 	})
 }
 
@@ -1040,7 +3811,85 @@ type suggestResult struct {
 	Type   string  `json:"type,omitempty"`  // nominatim
 }
 
-// initGeocodeDB initializes the persistent SQLite cache (indefinite retention, no pruning).
+// Geocode cache retention (WHEREAMI_GEOCODE_TTL); 0 = keep forever.
+const defaultGeocodePruneInterval = 1 * time.Hour
+
+// sqliteTimeLayout matches CURRENT_TIMESTAMP values.
+const sqliteTimeLayout = "2006-01-02 15:04:05"
+
+var geocodePrunerOnce sync.Once
+
+// geocodeCacheTTL returns WHEREAMI_GEOCODE_TTL (a Go duration, e.g. "720h"),
+// or 0 when unset/invalid: cached searches then never expire.
+func geocodeCacheTTL() time.Duration {
+	if v := os.Getenv("WHEREAMI_GEOCODE_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			return d
+		}
+		logger.Warn("ignoring invalid WHEREAMI_GEOCODE_TTL=%q", v)
+	}
+	return 0
+}
+
+// geocodeExpired reports whether a geocode_cache row fetched at fetchedAt
+// (CURRENT_TIMESTAMP text) is older than ttl. Unparseable times never expire.
+func geocodeExpired(fetchedAt string, ttl time.Duration) bool {
+	if ttl <= 0 {
+		return false
+	}
+	t, err := time.Parse(sqliteTimeLayout, fetchedAt)
+	if err != nil {
+		if t, err = time.Parse(time.RFC3339Nano, fetchedAt); err != nil {
+			return false
+		}
+	}
+	return time.Since(t) > ttl
+}
+
+// pruneGeocodeCache deletes geocode_cache rows older than ttl (range scan on
+// idx_geocode_cache_fetched_at).
+func pruneGeocodeCache(ttl time.Duration) {
+	if geoDB == nil || ttl <= 0 {
+		return
+	}
+	cutoff := time.Now().UTC().Add(-ttl).Format(sqliteTimeLayout)
+	res, err := geoDB.Exec(`DELETE FROM geocode_cache WHERE fetched_at < ?`, cutoff)
+	if err != nil {
+		logger.Error("geocode cache prune failed: %v", err)
+		return
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		logger.Debug("geocode cache prune removed %d row(s) (ttl=%v)", n, ttl)
+	}
+}
+
+// startGeocodePruner prunes once at startup and then periodically
+// (WHEREAMI_GEOCODE_PRUNE_INTERVAL, default 1h, min 1m) when a TTL is set.
+func startGeocodePruner() {
+	geocodePrunerOnce.Do(func() {
+		ttl := geocodeCacheTTL()
+		if ttl == 0 {
+			return
+		}
+		initGeocodeDB()
+		interval := defaultGeocodePruneInterval
+		if v := os.Getenv("WHEREAMI_GEOCODE_PRUNE_INTERVAL"); v != "" {
+			if d, err := time.ParseDuration(v); err == nil && d >= time.Minute {
+				interval = d
+			}
+		}
+		pruneGeocodeCache(ttl)
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for range ticker.C {
+				pruneGeocodeCache(ttl)
+			}
+		}()
+	})
+}
+
+// initGeocodeDB initializes the persistent SQLite cache (retention: see WHEREAMI_GEOCODE_TTL).
 func initGeocodeDB() {
 	geoDBOnce.Do(func() {
 		path := effectiveCacheDir()
@@ -1051,8 +3900,6 @@ func initGeocodeDB() {
 			logger.Error("geocode cache open failed: %v", err)
 			return
 		}
-		// Index to support potential pruning / ordering by fetched_at (query already PRIMARY KEY)
-		_, _ = db.Exec(`CREATE INDEX IF NOT EXISTS idx_geocode_cache_fetched_at ON geocode_cache(fetched_at)`)
 		if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS geocode_cache (
 			query TEXT PRIMARY KEY,
 			json  TEXT NOT NULL,
@@ -1062,54 +3909,234 @@ func initGeocodeDB() {
 			_ = db.Close()
 			return
 		}
+		// Migration: number of results requested when the row was fetched (0 = unknown, pre-migration).
+		_, _ = db.Exec(`ALTER TABLE geocode_cache ADD COLUMN fetch_limit INTEGER NOT NULL DEFAULT 0`)
+		if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS reverse_cache (
+			key  TEXT PRIMARY KEY,
+			json TEXT NOT NULL,
+			fetched_at TIMESTAMP NOT NULL
+		)`); err != nil {
+			logger.Error("reverse cache schema error: %v", err)
+			_ = db.Close()
+			return
+		}
+		_ = ensureIndexes(db, geocodeIndexes)
 		geoDB = db
 	})
 }
 
-// fetchGeocodeCached returns up to limit nominatim results, using indefinite sqlite caching.
+// nominatimThrottle blocks until nominatimMinInterval has passed since the
+// previous upstream request (shared by search and reverse lookups).
+func nominatimThrottle() {
+	nominatimThrottleMu.Lock()
+	delta := time.Since(nominatimLast)
+	if delta < nominatimMinInterval {
+		time.Sleep(nominatimMinInterval - delta)
+	}
+	nominatimLast = time.Now()
+	nominatimThrottleMu.Unlock()
+}
+
+// tokenBucket is a simple token-bucket rate limiter (capacity tokens, refilled
+// continuously at perSec).
+type tokenBucket struct {
+	mu       sync.Mutex
+	tokens   float64
+	capacity float64
+	perSec   float64
+	last     time.Time
+}
+
+func newTokenBucket(perMinute int) *tokenBucket {
+	return &tokenBucket{
+		tokens:   float64(perMinute),
+		capacity: float64(perMinute),
+		perSec:   float64(perMinute) / 60,
+		last:     time.Now(),
+	}
+}
+
+// take consumes a token, reporting false when the bucket is empty.
+func (b *tokenBucket) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = min(b.capacity, b.tokens+now.Sub(b.last).Seconds()*b.perSec)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+var (
+	suggestGeocodeBucket     *tokenBucket
+	suggestGeocodeBucketOnce sync.Once
+)
+
+// suggestGeocodeLimiter returns the /api/suggest upstream limiter configured by
+// WHEREAMI_NOMINATIM_RATE (requests per minute), or nil when unset: then only
+// the nominatimMinInterval throttle applies.
+func suggestGeocodeLimiter() *tokenBucket {
+	suggestGeocodeBucketOnce.Do(func() {
+		v := strings.TrimSpace(os.Getenv("WHEREAMI_NOMINATIM_RATE"))
+		if v == "" {
+			return
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			logger.Warn("ignoring invalid WHEREAMI_NOMINATIM_RATE=%q", v)
+			return
+		}
+		suggestGeocodeBucket = newTokenBucket(n)
+	})
+	return suggestGeocodeBucket
+}
+
+// initNominatimServer points gominatim at WHEREAMI_NOMINATIM_SERVER (once).
+func initNominatimServer() {
+	nominatimInitOnce.Do(func() {
+		srv := os.Getenv("WHEREAMI_NOMINATIM_SERVER")
+		if strings.TrimSpace(srv) == "" {
+			srv = defaultNominatimServer
+		}
+		gominatim.SetServer(srv)
+	})
+}
+
+// nominatimRetries returns the transient retry count (default 1 -> total attempts = 2).
+func nominatimRetries() int {
+	maxTransientRetries := 1
+	if v := os.Getenv("WHEREAMI_NOMINATIM_RETRIES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 && n <= 5 {
+			maxTransientRetries = n
+		}
+	}
+	return maxTransientRetries
+}
+
+// defaultGeocodeFetchLimit is how many results geocode searches request and
+// cache, independent of how many the caller needs right now.
+const defaultGeocodeFetchLimit = 8
+
+// geocodeFetchLimit returns WHEREAMI_GEOCODE_FETCH_LIMIT (1-50, default 8).
+func geocodeFetchLimit() int {
+	if v := os.Getenv("WHEREAMI_GEOCODE_FETCH_LIMIT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 1 && n <= 50 {
+			return n
+		}
+	}
+	return defaultGeocodeFetchLimit
+}
+
+// isTransientNominatimErr reports truncated / interrupted responses worth retrying.
+func isTransientNominatimErr(err error) bool {
+	errStr := err.Error()
+	return strings.Contains(errStr, "unexpected end of JSON") || strings.Contains(errStr, "EOF")
+}
+
+// geocodeBias restricts or biases a geocode search to a viewport (Nominatim
+// viewbox/bounded). The zero value is an unbiased search.
+type geocodeBias struct {
+	viewbox string // "minLon,minLat,maxLon,maxLat", rounded (see parseGeocodeBias)
+	bounded bool
+}
+
+// viewboxPrecision is the number of decimals viewbox corners are rounded to
+// (~1km), so small pans reuse cached biased results.
+const viewboxPrecision = 2
+
+// parseGeocodeBias reads ?viewbox=minLon,minLat,maxLon,maxLat and ?bounded=1.
+func parseGeocodeBias(q url.Values) (geocodeBias, error) {
+	var b geocodeBias
+	if v := strings.TrimSpace(q.Get("viewbox")); v != "" {
+		minLon, minLat, maxLon, maxLat, err := parseBBox(v)
+		if err != nil {
+			return b, fmt.Errorf("viewbox: %w", err)
+		}
+		parts := make([]string, 4)
+		for i, f := range []float64{minLon, minLat, maxLon, maxLat} {
+			parts[i] = strconv.FormatFloat(roundTo(f, viewboxPrecision), 'f', -1, 64)
+		}
+		b.viewbox = strings.Join(parts, ",")
+	}
+	b.bounded = isTruthy(q.Get("bounded"))
+	if b.bounded && b.viewbox == "" {
+		return b, errors.New("bounded requires viewbox")
+	}
+	return b, nil
+}
+
+// cacheKey returns the geocode_cache / suggest cache key for query q, which is
+// q itself for unbiased searches.
+func (b geocodeBias) cacheKey(q string) string {
+	if b.viewbox == "" {
+		return q
+	}
+	k := q + "\x00viewbox=" + b.viewbox
+	if b.bounded {
+		k += "&bounded=1"
+	}
+	return k
+}
+
+// fetchGeocodeCached returns up to limit nominatim results, using sqlite caching (rows older than WHEREAMI_GEOCODE_TTL are refetched).
+// Searches always request (and cache) geocodeFetchLimit results, or limit if larger, and are
+// sliced to limit here, so the cached payload does not depend on the first caller's limit.
 // Adds lightweight retry for transient / truncated JSON errors (e.g. "unexpected end of JSON input", "EOF").
 // We only cache successful (even if empty) responses; transient failures are not cached.
 func fetchGeocodeCached(q string, limit int) []suggestResult {
+	res, _ := fetchGeocodeLimited(q, geocodeBias{}, limit, nil)
+	return res
+}
+
+// fetchGeocodeLimited is fetchGeocodeCached with a viewport bias (cached under
+// its own key) and an optional upstream limiter: when a network fetch is
+// needed and limiter has no token left, it returns whatever the cache holds
+// and throttled=true. Cache hits never consume tokens.
+func fetchGeocodeLimited(q string, bias geocodeBias, limit int, limiter *tokenBucket) (_ []suggestResult, throttled bool) {
 	if limit <= 0 {
-		return nil
+		return nil, false
 	}
+	fetchLimit := max(geocodeFetchLimit(), limit)
 	initGeocodeDB()
-	var rawJSON string
+	var rawJSON, fetchedAt string
+	var storedLimit int
 	if geoDB != nil {
-		_ = geoDB.QueryRow(`SELECT json FROM geocode_cache WHERE query = ?`, q).Scan(&rawJSON)
+		_ = geoDB.QueryRow(`SELECT json, fetch_limit, CAST(fetched_at AS TEXT) FROM geocode_cache WHERE query = ?`, bias.cacheKey(q)).Scan(&rawJSON, &storedLimit, &fetchedAt)
 	}
 
 	var payload []map[string]any
-	if rawJSON == "" {
-		// ---- Cache miss: perform network fetch (with throttle + retry) ----
-		nominatimThrottleMu.Lock()
-		delta := time.Since(nominatimLast)
-		if delta < nominatimMinInterval {
-			time.Sleep(nominatimMinInterval - delta)
+	if rawJSON != "" {
+		if err := json.Unmarshal([]byte(rawJSON), &payload); err != nil {
+			logger.Error("geocode cache unmarshal failed for %q: %v (refetching)", q, err)
+			payload = nil
+			rawJSON = ""
+		} else if len(payload) < limit && geocodeMaybeTruncated(len(payload), storedLimit) {
+			logger.Debug("geocode cache entry for %q may be truncated (%d results, fetch limit %d); refetching", q, len(payload), storedLimit)
+			rawJSON = ""
+		} else if ttl := geocodeCacheTTL(); geocodeExpired(fetchedAt, ttl) {
+			// Kept in payload as a fallback should the refetch fail.
+			logger.Debug("geocode cache entry for %q expired (fetched %s, ttl %v); refetching", q, fetchedAt, ttl)
+			rawJSON = ""
 		}
-		nominatimLast = time.Now()
-		nominatimThrottleMu.Unlock()
-
-		// One-time server init
-		nominatimInitOnce.Do(func() {
-			srv := os.Getenv("WHEREAMI_NOMINATIM_SERVER")
-			if strings.TrimSpace(srv) == "" {
-				srv = defaultNominatimServer
-			}
-			gominatim.SetServer(srv)
-		})
-
-		// Determine retry count (default 1 transient retry -> total attempts = 2)
-		maxTransientRetries := 1
-		if v := os.Getenv("WHEREAMI_NOMINATIM_RETRIES"); v != "" {
-			if n, err := strconv.Atoi(v); err == nil && n >= 0 && n <= 5 {
-				maxTransientRetries = n
-			}
+	}
+	if rawJSON == "" {
+		if limiter != nil && !limiter.take() {
+			logger.Debug("geocode fetch for %q skipped: WHEREAMI_NOMINATIM_RATE exhausted", q)
+			return geocodeSuggestions(payload, limit), true
 		}
+		// ---- Cache miss: perform network fetch (with throttle + retry) ----
+		nominatimThrottle()
+		initNominatimServer()
+		maxTransientRetries := nominatimRetries()
 
 		qObj := gominatim.SearchQuery{
-			Q:     q,
-			Limit: limit,
+			Q:       q,
+			Limit:   fetchLimit,
+			Viewbox: bias.viewbox,
+			Bounded: bias.bounded,
 		}
 
 		var res []gominatim.SearchResult
@@ -1123,16 +4150,15 @@ func fetchGeocodeCached(q string, limit int) []suggestResult {
 				}
 				break
 			}
-			errStr := err.Error()
-			transient := strings.Contains(errStr, "unexpected end of JSON") || strings.Contains(errStr, "EOF")
-			if !transient || attempt == attempts {
+			if !isTransientNominatimErr(err) || attempt == attempts {
 				logger.Error("nominatim search error (attempt %d/%d, query=%q): %v", attempt, attempts, q, err)
-				return nil
+				return geocodeSuggestions(payload, limit), false // possibly truncated cached results, if any
 			}
 			logger.Error("transient nominatim error (attempt %d/%d, will retry) query=%q err=%v", attempt, attempts, q, err)
 			time.Sleep(150 * time.Millisecond)
 		}
 
+		payload = nil
 		for _, r := range res {
 			var lat, lon float64
 			if r.Lat != "" {
@@ -1148,7 +4174,7 @@ func fetchGeocodeCached(q string, limit int) []suggestResult {
 				"class":        r.Class,
 				"type":         r.Type,
 			})
-			if len(payload) >= limit {
+			if len(payload) >= fetchLimit {
 				break
 			}
 		}
@@ -1156,16 +4182,25 @@ func fetchGeocodeCached(q string, limit int) []suggestResult {
 		// Only cache successful fetches (even if empty slice).
 		if geoDB != nil {
 			b, _ := json.Marshal(payload)
-			_, _ = geoDB.Exec(`INSERT OR REPLACE INTO geocode_cache(query, json, fetched_at) VALUES(?,?,CURRENT_TIMESTAMP)`, q, string(b))
-		}
-	} else {
-		// ---- Cache hit ----
-		if err := json.Unmarshal([]byte(rawJSON), &payload); err != nil {
-			logger.Error("geocode cache unmarshal failed for %q: %v (ignoring)", q, err)
-			payload = nil
+			_, _ = geoDB.Exec(`INSERT OR REPLACE INTO geocode_cache(query, json, fetched_at, fetch_limit) VALUES(?,?,CURRENT_TIMESTAMP,?)`, bias.cacheKey(q), string(b), fetchLimit)
 		}
 	}
 
+	return geocodeSuggestions(payload, limit), false
+}
+
+// geocodeMaybeTruncated reports whether a cached row with n results may have
+// been cut short by the fetch limit it was stored with. Pre-migration rows
+// (limit 0, unknown) count as complete only when empty.
+func geocodeMaybeTruncated(n, storedLimit int) bool {
+	if storedLimit == 0 {
+		return n > 0
+	}
+	return n >= storedLimit
+}
+
+// geocodeSuggestions converts up to limit cached geocode payload entries.
+func geocodeSuggestions(payload []map[string]any, limit int) []suggestResult {
 	out := make([]suggestResult, 0, limit)
 	for _, p := range payload {
 		name, _ := p["display_name"].(string)
@@ -1191,6 +4226,153 @@ func fetchGeocodeCached(q string, limit int) []suggestResult {
 	return out
 }
 
+// reverseResult is the /api/reverse payload (also the cached JSON).
+type reverseResult struct {
+	DisplayName string  `json:"display_name"`
+	Lat         float64 `json:"lat"`
+	Lon         float64 `json:"lon"`
+	Class       string  `json:"class,omitempty"`
+	Type        string  `json:"type,omitempty"`
+}
+
+// reverseCachePrecision is the number of decimals coordinates are rounded to
+// for the reverse cache key (~11m), so repeated clicks on a pin hit the cache.
+const reverseCachePrecision = 4
+
+// fetchReverseCached resolves lat/lon to an address via Nominatim /reverse,
+// caching results (including "nothing found", returned as nil) in geocode.sqlite.
+// Uses the same throttle and transient retry policy as fetchGeocodeCached.
+func fetchReverseCached(lat, lon float64) (*reverseResult, error) {
+	initGeocodeDB()
+	key := fmt.Sprintf("%.*f,%.*f", reverseCachePrecision, lat, reverseCachePrecision, lon)
+	if geoDB != nil {
+		var rawJSON string
+		if err := geoDB.QueryRow(`SELECT json FROM reverse_cache WHERE key = ?`, key).Scan(&rawJSON); err == nil {
+			var res *reverseResult
+			if err := json.Unmarshal([]byte(rawJSON), &res); err == nil {
+				return res, nil
+			}
+			logger.Error("reverse cache unmarshal failed for %q: %v (ignoring)", key, err)
+		}
+	}
+
+	nominatimThrottle()
+	initNominatimServer()
+	qObj := gominatim.ReverseQuery{
+		Lat:  strconv.FormatFloat(lat, 'f', -1, 64),
+		Lon:  strconv.FormatFloat(lon, 'f', -1, 64),
+		Zoom: 18,
+	}
+	var res *gominatim.ReverseResult
+	var err error
+	attempts := nominatimRetries() + 1
+	for attempt := 1; attempt <= attempts; attempt++ {
+		res, err = qObj.Get()
+		if err == nil || !isTransientNominatimErr(err) || attempt == attempts {
+			break
+		}
+		logger.Error("transient nominatim reverse error (attempt %d/%d, will retry) key=%q err=%v", attempt, attempts, key, err)
+		time.Sleep(150 * time.Millisecond)
+	}
+
+	var out *reverseResult
+	if err != nil {
+		// Nominatim answers {"error":"Unable to geocode"} for open sea etc.
+		if !strings.Contains(err.Error(), "Unable to geocode") {
+			logger.Error("nominatim reverse error (key=%q): %v", key, err)
+			return nil, err
+		}
+	} else if res.DisplayName != "" {
+		out = &reverseResult{DisplayName: res.DisplayName, Lat: lat, Lon: lon, Class: res.Class, Type: res.Type}
+		// Prefer the matched place's position; fall back to the queried one.
+		if v, err := strconv.ParseFloat(res.Lat, 64); err == nil {
+			out.Lat = v
+		}
+		if v, err := strconv.ParseFloat(res.Lon, 64); err == nil {
+			out.Lon = v
+		}
+	}
+
+	if geoDB != nil {
+		b, _ := json.Marshal(out)
+		_, _ = geoDB.Exec(`INSERT OR REPLACE INTO reverse_cache(key, json, fetched_at) VALUES(?,?,CURRENT_TIMESTAMP)`, key, string(b))
+	}
+	return out, nil
+}
+
+// GET /api/reverse?lat=&lon=
+// Returns { display_name, lat, lon, class, type } for the nearest address, or
+// 204 when Nominatim has nothing for that position. Only found addresses are
+// marked cacheable by clients.
+func handleGetReverse(w http.ResponseWriter, r *http.Request) {
+	lat, err1 := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
+	lon, err2 := strconv.ParseFloat(r.URL.Query().Get("lon"), 64)
+	if err1 != nil || err2 != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		http.Error(w, "invalid lat/lon", http.StatusBadRequest)
+		return
+	}
+	res, err := fetchReverseCached(lat, lon)
+	if err != nil {
+		http.Error(w, "reverse geocode failed", http.StatusBadGateway)
+		return
+	}
+	if res == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Cache-Control", "max-age=86400")
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// maxReverseBatch caps POST /api/reverse/batch; uncached points are throttled
+// to one upstream request per nominatimMinInterval, so keep this modest.
+const maxReverseBatch = 50
+
+// POST /api/reverse/batch
+// Body: [ {lat, lon}, ... ] (at most maxReverseBatch entries)
+// Returns one entry per input, in order:
+//
+//	{ "lat": ..., "lon": ..., "address": { display_name, lat, lon, class, type } | null, "error"?: "..." }
+//
+// A null address means nothing was found; per-point failures are reported in
+// "error" without failing the whole batch.
+func handlePostReverseBatch(w http.ResponseWriter, r *http.Request) {
+	var req []struct {
+		Lat *float64 `json:"lat"`
+		Lon *float64 `json:"lon"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if len(req) > maxReverseBatch {
+		http.Error(w, fmt.Sprintf("too many points (max %d)", maxReverseBatch), http.StatusBadRequest)
+		return
+	}
+
+	out := make([]map[string]any, 0, len(req))
+	found := 0
+	for _, p := range req {
+		entry := map[string]any{"lat": p.Lat, "lon": p.Lon, "address": nil}
+		switch {
+		case p.Lat == nil || p.Lon == nil || *p.Lat < -90 || *p.Lat > 90 || *p.Lon < -180 || *p.Lon > 180:
+			entry["error"] = "invalid lat/lon"
+		default:
+			res, err := fetchReverseCached(*p.Lat, *p.Lon)
+			if err != nil {
+				entry["error"] = "reverse geocode failed"
+			} else if res != nil {
+				entry["address"] = res
+				found++
+			}
+		}
+		out = append(out, entry)
+	}
+	logger.DebugCtx(r.Context(), "POST /api/reverse/batch points=%d found=%d", len(req), found)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
 // handleGetSuggest now returns structured suggestions:
 // [
 //
@@ -1202,66 +4384,55 @@ func fetchGeocodeCached(q string, limit int) []suggestResult {
 //
 // ]
 // Combined limit: 8 (first waypoints/bookmarks, then geocode).
+// format=geojson returns the same suggestions as a GeoJSON FeatureCollection.
+// viewbox=minLon,minLat,maxLon,maxLat biases geocode results towards the map
+// view (bounded=1 restricts them to it); local matches are unaffected.
 func handleGetSuggest(w http.ResponseWriter, r *http.Request) {
+	var asGeoJSON bool
+	switch strings.ToLower(r.URL.Query().Get("format")) {
+	case "", "json":
+	case "geojson":
+		asGeoJSON = true
+	default:
+		http.Error(w, "unsupported format (json or geojson)", http.StatusBadRequest)
+		return
+	}
+	bias, err := parseGeocodeBias(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	q := strings.TrimSpace(r.URL.Query().Get("q"))
 	if q == "" {
 		q = strings.TrimSpace(r.URL.Query().Get("query"))
 	}
-	logger.Debug("/api/suggest received q=%q", q)
+	logger.DebugCtx(r.Context(), "/api/suggest received q=%q viewbox=%q bounded=%v", q, bias.viewbox, bias.bounded)
 	if q == "" {
-		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(map[string]any{
-			"query":       "",
-			"suggestions": []suggestResult{},
-		})
+		writeSuggestions(w, "", []suggestResult{}, asGeoJSON, false)
+		return
+	}
+	cacheKey := bias.cacheKey(suggestCacheKey(q))
+	if cached, ok := suggestCacheGet(cacheKey); ok {
+		logger.DebugCtx(r.Context(), "/api/suggest cache hit q=%q results=%d", q, len(cached))
+		writeSuggestions(w, q, cached, asGeoJSON, false)
 		return
 	}
+	// Versions are read before computing so a concurrent change is never masked.
+	wpVersion, tagVersion := waypointsVersion.Load(), tagsVersion.Load()
 	qLower := strings.ToLower(q)
 
 	// Boolean / single tag query branch
 	if strings.HasPrefix(qLower, "tag:") {
-		rawExpr := strings.TrimSpace(q[4:])
-		// Strip optional surrounding quotes
-		if len(rawExpr) >= 2 && rawExpr[0] == '"' && rawExpr[len(rawExpr)-1] == '"' {
-			rawExpr = strings.TrimSpace(rawExpr[1 : len(rawExpr)-1])
-		}
-		mode := "single"
-		var terms []string
-		var singleTerm string
-
-		upperExpr := strings.ToUpper(rawExpr)
-		if strings.Contains(upperExpr, " AND ") {
-			mode = "AND"
-			parts := strings.Split(upperExpr, " AND ")
-			for _, p := range parts {
-				p = strings.TrimSpace(p)
-				if p != "" {
-					terms = append(terms, normalizeTagKey(p))
-				}
-			}
-		} else if strings.Contains(upperExpr, " OR ") {
-			mode = "OR"
-			parts := strings.Split(upperExpr, " OR ")
-			for _, p := range parts {
-				p = strings.TrimSpace(p)
-				if p != "" {
-					terms = append(terms, normalizeTagKey(p))
-				}
-			}
-		} else {
-			mode = "single"
-			singleTerm = normalizeTagKey(rawExpr)
-		}
-
 		var results []suggestResult
-		if tagDB != nil {
+		expr, err := parseTagExpr(q[4:])
+		if err == nil && tagDB.Load() != nil {
 			// Build waypoint -> normalized tag set
 			type wkey struct {
 				name     string
 				lat, lon float64
 			}
 			wmap := make(map[wkey]map[string]struct{})
-			rows, err := tagDB.Query(`SELECT name, lat, lon, tag FROM waypoint_tags`)
+			rows, err := tagDB.Load().Query(`SELECT name, lat, lon, tag FROM waypoint_tags`)
 			if err == nil {
 				defer rows.Close()
 				for rows.Next() {
@@ -1278,59 +4449,14 @@ func handleGetSuggest(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 
-			evalWaypoint := func(tags map[string]struct{}) bool {
-				switch mode {
-				case "single":
-					if singleTerm == "" {
-						return false
-					}
-					_, ok := tags[singleTerm]
-					return ok
-				case "AND":
-					if len(terms) == 0 {
-						return false
-					}
-					for _, t := range terms {
-						if t == "" {
-							continue
-						}
-						if _, ok := tags[t]; !ok {
-							return false
-						}
-					}
-					return true
-				case "OR":
-					if len(terms) == 0 {
-						return false
-					}
-					for _, t := range terms {
-						if t == "" {
-							continue
-						}
-						if _, ok := tags[t]; ok {
-							return true
-						}
-					}
-					return false
-				default:
-					return false
-				}
-			}
-
 			// Build suggestions
+			mode := expr.mode()
 			for k, tagset := range wmap {
-				if evalWaypoint(tagset) {
+				if expr.match(tagset) {
 					src := "bookmark"
-					allWaypointsMu.RLock()
-					for _, wpt := range allWaypoints {
-						if wpt.Name == k.name && math.Abs(wpt.Lat-k.lat) < 1e-9 && math.Abs(wpt.Lon-k.lon) < 1e-9 {
-							if !wpt.Bookmark {
-								src = "waypoint"
-							}
-							break
-						}
+					if wpt, ok := waypointStore.Find(k.name, k.lat, k.lon); ok && !wpt.Bookmark {
+						src = "waypoint"
 					}
-					allWaypointsMu.RUnlock()
 					results = append(results, suggestResult{
 						Name:   k.name,
 						Lat:    k.lat,
@@ -1357,37 +4483,29 @@ func handleGetSuggest(w http.ResponseWriter, r *http.Request) {
 			results = results[:maxTagSuggest]
 		}
 
-		logger.Debug("/api/suggest tag query mode=%s terms=%v single=%q matches=%d", mode, terms, singleTerm, len(results))
-		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(map[string]any{
-			"query":       q,
-			"suggestions": results,
-		})
+		logger.DebugCtx(r.Context(), "/api/suggest tag query expr=%q err=%v matches=%d", q[4:], err, len(results))
+		suggestCachePut(cacheKey, results, wpVersion, tagVersion)
+		writeSuggestions(w, q, results, asGeoJSON, false)
 		return
 	}
 
 	// Non-tag suggestion logic (original behavior)
 	// Collect local waypoint matches (name contains query)
-	var local []suggestResult
-	allWaypointsMu.RLock()
-	for _, wpt := range allWaypoints {
-		if wpt.Name == "" {
-			continue
-		}
-		if strings.Contains(strings.ToLower(wpt.Name), qLower) {
-			src := "waypoint"
-			if wpt.Bookmark {
-				src = "bookmark"
-			}
-			local = append(local, suggestResult{
-				Name:   wpt.Name,
-				Lat:    wpt.Lat,
-				Lon:    wpt.Lon,
-				Source: src,
-			})
+	var local []suggestResult
+	for _, wpt := range waypointStore.Filter(func(wp Waypoint) bool {
+		return wp.Name != "" && strings.Contains(strings.ToLower(wp.Name), qLower)
+	}) {
+		src := "waypoint"
+		if wpt.Bookmark {
+			src = "bookmark"
 		}
+		local = append(local, suggestResult{
+			Name:   wpt.Name,
+			Lat:    wpt.Lat,
+			Lon:    wpt.Lon,
+			Source: src,
+		})
 	}
-	allWaypointsMu.RUnlock()
 
 	sort.Slice(local, func(i, j int) bool {
 		return strings.ToLower(local[i].Name) < strings.ToLower(local[j].Name)
@@ -1407,25 +4525,128 @@ func handleGetSuggest(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	throttled := false
 	if remaining > 0 {
-		geo := fetchGeocodeCached(q, remaining)
+		var geo []suggestResult
+		geo, throttled = fetchGeocodeLimited(q, bias, remaining, suggestGeocodeLimiter())
 		combined = append(combined, geo...)
 	}
 
+	// Collapse places that appear from several sources (e.g. bookmark + geocode hit)
+	combined = dedupeSuggestions(combined)
+
 	// Final cap (safety)
 	if len(combined) > maxSuggestions {
 		combined = combined[:maxSuggestions]
 	}
+	if throttled {
+		// Not cached: the geocode part is missing and should be retried later.
+		logger.DebugCtx(r.Context(), "/api/suggest geocode throttled q=%q local=%d", q, len(local))
+	} else {
+		suggestCachePut(cacheKey, combined, wpVersion, tagVersion)
+	}
+	writeSuggestions(w, q, combined, asGeoJSON, throttled)
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(map[string]any{
-		"query":       q,
-		"suggestions": combined,
-	})
+// writeSuggestions writes the /api/suggest response: the { query, suggestions }
+// envelope, or with asGeoJSON a FeatureCollection of Point features carrying
+// name, source, class and type (plus the query as a foreign member). throttled
+// adds geocode_throttled: true.
+func writeSuggestions(w http.ResponseWriter, q string, results []suggestResult, asGeoJSON, throttled bool) {
+	if !asGeoJSON {
+		out := map[string]any{
+			"query":       q,
+			"suggestions": results,
+		}
+		if throttled {
+			out["geocode_throttled"] = true
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+		return
+	}
+	features := make([]map[string]any, 0, len(results))
+	for _, s := range results {
+		props := map[string]any{"name": s.Name, "source": s.Source}
+		if s.Class != "" {
+			props["class"] = s.Class
+		}
+		if s.Type != "" {
+			props["type"] = s.Type
+		}
+		features = append(features, map[string]any{
+			"type":       "Feature",
+			"geometry":   map[string]any{"type": "Point", "coordinates": []float64{s.Lon, s.Lat}},
+			"properties": props,
+		})
+	}
+	out := map[string]any{
+		"type":     "FeatureCollection",
+		"query":    q,
+		"features": features,
+	}
+	if throttled {
+		out["geocode_throttled"] = true
+	}
+	w.Header().Set("Content-Type", "application/geo+json")
+	_ = json.NewEncoder(w).Encode(out)
 }
 
 // (Removed stray duplicate code after handleGetSuggest)
 
+// suggestDedupeTolerance is the max coordinate delta (degrees, ~100m) for two
+// suggestions with the same normalized name to be considered the same place.
+const suggestDedupeTolerance = 1e-3
+
+// suggestSourceRank orders sources by preference when collapsing duplicates.
+func suggestSourceRank(src string) int {
+	switch src {
+	case "bookmark":
+		return 0
+	case "waypoint":
+		return 1
+	default:
+		return 2
+	}
+}
+
+// normalizeSuggestName lowercases, collapses whitespace and keeps only the leading
+// component of comma-separated geocoder display names ("Berlin, Germany" -> "berlin").
+func normalizeSuggestName(name string) string {
+	if i := strings.Index(name, ","); i >= 0 {
+		name = name[:i]
+	}
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
+
+// dedupeSuggestions removes suggestions referring to the same place (normalized
+// name + coordinates within suggestDedupeTolerance). The first occurrence keeps
+// its position; a duplicate from a preferred source (bookmark > waypoint >
+// geocode) replaces it in place.
+func dedupeSuggestions(in []suggestResult) []suggestResult {
+	out := make([]suggestResult, 0, len(in))
+	for _, s := range in {
+		n := normalizeSuggestName(s.Name)
+		dup := -1
+		for i, o := range out {
+			if normalizeSuggestName(o.Name) == n &&
+				math.Abs(o.Lat-s.Lat) <= suggestDedupeTolerance &&
+				math.Abs(o.Lon-s.Lon) <= suggestDedupeTolerance {
+				dup = i
+				break
+			}
+		}
+		if dup < 0 {
+			out = append(out, s)
+			continue
+		}
+		if suggestSourceRank(s.Source) < suggestSourceRank(out[dup].Source) {
+			out[dup] = s
+		}
+	}
+	return out
+}
+
 // Recent search queries (distinct, most recent first). Returns legacy string list plus
 // enriched entries with optional lat/lon:
 //
@@ -1442,9 +4663,9 @@ func handleGetRecentSuggest(w http.ResponseWriter, r *http.Request) {
 			limit = v
 		}
 	}
-	logger.Debug("/api/recent_suggest requested limit=%d", limit)
+	logger.DebugCtx(r.Context(), "/api/recent_suggest requested limit=%d", limit)
 	if historyDB == nil {
-		logger.Debug("/api/recent_suggest history DB unavailable -> returning empty list")
+		logger.DebugCtx(r.Context(), "/api/recent_suggest history DB unavailable -> returning empty list")
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(map[string]any{
 			"queries": []string{},
@@ -1488,7 +4709,7 @@ func handleGetRecentSuggest(w http.ResponseWriter, r *http.Request) {
 			entries = append(entries, entry)
 		}
 	}
-	logger.Debug("/api/recent_suggest returning %d distinct queries (limit=%d)", len(recent), limit)
+	logger.DebugCtx(r.Context(), "/api/recent_suggest returning %d distinct queries (limit=%d)", len(recent), limit)
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(map[string]any{
 		"queries": recent,
@@ -1510,8 +4731,7 @@ func handlePostHistory(w http.ResponseWriter, r *http.Request) {
 		Lat     *float64 `json:"lat"`
 		Lon     *float64 `json:"lon"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &payload) {
 		return
 	}
 	var inserted int
@@ -1549,51 +4769,123 @@ func handlePostHistory(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// DELETE /api/history?all=true      (clear everything)
+// DELETE /api/history?query=<text>  (remove every row for one query)
+// Returns { "deleted": <count> }.
+func handleDeleteHistory(w http.ResponseWriter, r *http.Request) {
+	initHistoryDB()
+	if historyDB == nil {
+		http.Error(w, "history db unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	q := r.URL.Query()
+	var res sql.Result
+	var err error
+	switch query := strings.TrimSpace(q.Get("query")); {
+	case isTruthy(q.Get("all")):
+		res, err = historyDB.Exec(`DELETE FROM search_history`)
+	case query != "":
+		res, err = historyDB.Exec(`DELETE FROM search_history WHERE query = ?`, query)
+	default:
+		http.Error(w, "all=true or query required", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "delete error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	n, _ := res.RowsAffected()
+	logger.DebugCtx(r.Context(), "DELETE /api/history deleted=%d", n)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"deleted": n,
+	})
+}
+
 // ---------------- RegisterAPI (public) ----------------
 
 // RegisterAPI wires all HTTP endpoints using Go 1.22 method-aware patterns.
 // initTagDB opens (and creates if needed) the tag database in dataDir.
 func initTagDB() {
-	tagDBOnce.Do(func() {
-		dir := effectiveDataDir()
-		if dir == "" {
-			logger.Error("initTagDB: no data directory resolved")
-			return
-		}
-		path := filepath.Join(dir, "tags.sqlite")
-		db, err := sql.Open("sqlite", path)
-		logger.Debug("initTagDB opening %s", path)
-		if err != nil {
-			logger.Error("initTagDB: open failed: %v", err)
-			return
-		}
-		if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS waypoint_tags (
-			name TEXT NOT NULL,
-			lat REAL NOT NULL,
-			lon REAL NOT NULL,
-			tag TEXT NOT NULL,
-			PRIMARY KEY(name, lat, lon, tag)
-		)`); err != nil {
-			logger.Error("initTagDB: schema error: %v", err)
-			_ = db.Close()
-			return
-		}
-		tagDB = db
-		logger.Debug("initTagDB ready (path=%s)", path)
-	})
+	tagDBMu.Lock()
+	defer tagDBMu.Unlock()
+	if tagDB.Load() != nil || (!tagDBLastTry.IsZero() && time.Since(tagDBLastTry) < tagDBRetryInterval) {
+		return
+	}
+	tagDBLastTry = time.Now()
+	dir := effectiveDataDir()
+	if dir == "" {
+		logger.Error("initTagDB: no data directory resolved")
+		return
+	}
+	path := filepath.Join(dir, "tags.sqlite")
+	db, err := sql.Open("sqlite", path)
+	logger.Debug("initTagDB opening %s", path)
+	if err != nil {
+		logger.Error("initTagDB: open failed: %v", err)
+		return
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS waypoint_tags ` + waypointTagsColumns); err != nil {
+		logger.Error("initTagDB: schema error: %v", err)
+		_ = db.Close()
+		return
+	}
+	// Migration: optional per-tag display color (ignored if it already exists).
+	_, _ = db.Exec(`ALTER TABLE waypoint_tags ADD COLUMN color TEXT NOT NULL DEFAULT ''`)
+	if err := ensureIndexes(db, tagIndexes); err != nil {
+		logger.Error("initTagDB: index error: %v", err)
+	}
+	tagDB.Store(db)
+	logger.Debug("initTagDB ready (path=%s)", path)
+}
+
+// waypointTagsColumns is the expected waypoint_tags definition (see also
+// POST /api/tags/repair).
+const waypointTagsColumns = `(
+	name TEXT NOT NULL,
+	lat REAL NOT NULL,
+	lon REAL NOT NULL,
+	tag TEXT NOT NULL,
+	color TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY(name, lat, lon, tag)
+)`
+
+// tagDBRetryInterval throttles reopen attempts after the tag DB failed to open.
+const tagDBRetryInterval = 10 * time.Second
+
+// requireTagDB (re)opens the tag DB if needed; when it is still unavailable it
+// writes a 503 and returns false.
+func requireTagDB(w http.ResponseWriter) bool {
+	if tagDB.Load() == nil {
+		initTagDB()
+	}
+	if tagDB.Load() == nil {
+		http.Error(w, "tag database unavailable", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
 }
 
+// errTagDBUnavailable is returned by tag helpers that cannot silently no-op.
+var errTagDBUnavailable = errors.New("tag database unavailable")
+
+// insertTagSQL adds one waypoint tag (ignoring duplicates). New rows inherit
+// the color already assigned to the tag, if any. Args: name, lat, lon, tag, tag.
+const insertTagSQL = `INSERT OR IGNORE INTO waypoint_tags(name, lat, lon, tag, color) VALUES(?,?,?,?,
+	COALESCE((SELECT color FROM waypoint_tags WHERE tag = ? AND color <> '' LIMIT 1), ''))`
+
 // addTagsToDB inserts tags (ignoring duplicates).
 func addTagsToDB(name string, lat, lon float64, tags []string) error {
 	logger.Debug("addTagsToDB name=%q lat=%.6f lon=%.6f tags=%v", name, lat, lon, tags)
-	if tagDB == nil || len(tags) == 0 {
+	if tagDB.Load() == nil || len(tags) == 0 {
 		return nil
 	}
-	tx, err := tagDB.Begin()
+	defer markTagsChanged()
+	tx, err := tagDB.Load().Begin()
 	if err != nil {
 		return err
 	}
-	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO waypoint_tags(name, lat, lon, tag) VALUES(?,?,?,?)`)
+	stmt, err := tx.Prepare(insertTagSQL)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -1604,7 +4896,7 @@ func addTagsToDB(name string, lat, lon float64, tags []string) error {
 		if t == "" {
 			continue
 		}
-		if _, err := stmt.Exec(name, lat, lon, t); err != nil {
+		if _, err := stmt.Exec(name, lat, lon, t, t); err != nil {
 			tx.Rollback()
 			return err
 		}
@@ -1618,13 +4910,310 @@ func addTagsToDB(name string, lat, lon float64, tags []string) error {
 	return err
 }
 
+// batchUpdateTags adds and removes tags for many waypoints in a single
+// transaction. Tags to remove match by normalizeTagKey, so "Hike" also drops
+// "hike". Returns the number of tag rows inserted and deleted.
+func batchUpdateTags(wps []Waypoint, add, remove []string) (added, removed int, err error) {
+	if tagDB.Load() == nil || len(wps) == 0 || (len(add) == 0 && len(remove) == 0) {
+		return 0, 0, nil
+	}
+	removeKeys := make(map[string]struct{}, len(remove))
+	for _, t := range remove {
+		if k := normalizeTagKey(t); k != "" {
+			removeKeys[k] = struct{}{}
+		}
+	}
+	defer markTagsChanged()
+	tx, err := tagDB.Load().Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	ins, err := tx.Prepare(insertTagSQL)
+	if err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+	defer ins.Close()
+	sel, err := tx.Prepare(`SELECT tag FROM waypoint_tags WHERE name = ? AND lat = ? AND lon = ?`)
+	if err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+	defer sel.Close()
+	del, err := tx.Prepare(`DELETE FROM waypoint_tags WHERE name = ? AND lat = ? AND lon = ? AND tag = ?`)
+	if err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+	defer del.Close()
+	for _, wp := range wps {
+		if wp.Name == "" {
+			continue
+		}
+		if len(removeKeys) > 0 {
+			var matched []string
+			rows, err := sel.Query(wp.Name, wp.Lat, wp.Lon)
+			if err != nil {
+				tx.Rollback()
+				return 0, 0, err
+			}
+			for rows.Next() {
+				var t string
+				if err := rows.Scan(&t); err == nil {
+					if _, ok := removeKeys[normalizeTagKey(t)]; ok {
+						matched = append(matched, t)
+					}
+				}
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				tx.Rollback()
+				return 0, 0, err
+			}
+			for _, t := range matched {
+				res, err := del.Exec(wp.Name, wp.Lat, wp.Lon, t)
+				if err != nil {
+					tx.Rollback()
+					return 0, 0, err
+				}
+				n, _ := res.RowsAffected()
+				removed += int(n)
+			}
+		}
+		for _, t := range add {
+			if t = strings.TrimSpace(t); t == "" {
+				continue
+			}
+			res, err := ins.Exec(wp.Name, wp.Lat, wp.Lon, t, t)
+			if err != nil {
+				tx.Rollback()
+				return 0, 0, err
+			}
+			n, _ := res.RowsAffected()
+			added += int(n)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return added, removed, nil
+}
+
+// renameTagGlobally rewrites every tag whose normalized key matches from's to
+// the literal to, in one transaction. Waypoints that already carry to would
+// hit the primary key, so their old row is dropped instead (merged).
+func renameTagGlobally(from, to string) (renamed, merged int, err error) {
+	if tagDB.Load() == nil {
+		return 0, 0, errTagDBUnavailable
+	}
+	defer markTagsChanged()
+	fromKey := normalizeTagKey(from)
+	tx, err := tagDB.Load().Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	type row struct {
+		name     string
+		lat, lon float64
+		tag      string
+	}
+	rows, err := tx.Query(`SELECT name, lat, lon, tag FROM waypoint_tags`)
+	if err != nil {
+		return 0, 0, err
+	}
+	var matches []row
+	hasTo := make(map[waypointRef]bool)
+	for rows.Next() {
+		var rw row
+		if err := rows.Scan(&rw.name, &rw.lat, &rw.lon, &rw.tag); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		if rw.tag == to {
+			hasTo[waypointRef{rw.name, rw.lat, rw.lon}] = true
+			continue
+		}
+		if normalizeTagKey(rw.tag) == fromKey {
+			matches = append(matches, rw)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	for _, rw := range matches {
+		ref := waypointRef{rw.name, rw.lat, rw.lon}
+		if hasTo[ref] {
+			if _, err := tx.Exec(`DELETE FROM waypoint_tags WHERE name = ? AND lat = ? AND lon = ? AND tag = ?`, rw.name, rw.lat, rw.lon, rw.tag); err != nil {
+				return 0, 0, err
+			}
+			merged++
+			continue
+		}
+		if _, err := tx.Exec(`UPDATE waypoint_tags SET tag = ? WHERE name = ? AND lat = ? AND lon = ? AND tag = ?`, to, rw.name, rw.lat, rw.lon, rw.tag); err != nil {
+			return 0, 0, err
+		}
+		hasTo[ref] = true // a second variant on the same waypoint merges
+		renamed++
+	}
+	return renamed, merged, tx.Commit()
+}
+
+// moveWaypointTags re-keys every tag of a waypoint to a new name / position
+// (used when a bookmark is renamed or moved). Tags already present at the destination are
+// kept once. Returns the number of tags moved.
+func moveWaypointTags(name string, lat, lon float64, newName string, newLat, newLon float64) (int64, error) {
+	if tagDB.Load() == nil {
+		return 0, nil
+	}
+	defer markTagsChanged()
+	tx, err := tagDB.Load().Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`INSERT OR IGNORE INTO waypoint_tags(name, lat, lon, tag, color)
+		SELECT ?, ?, ?, tag, color FROM waypoint_tags WHERE name = ? AND lat = ? AND lon = ?`,
+		newName, newLat, newLon, name, lat, lon)
+	if err != nil {
+		return 0, err
+	}
+	moved, _ := res.RowsAffected()
+	if _, err := tx.Exec(`DELETE FROM waypoint_tags WHERE name = ? AND lat = ? AND lon = ?`, name, lat, lon); err != nil {
+		return 0, err
+	}
+	return moved, tx.Commit()
+}
+
+// replaceTags transactionally replaces the full tag set of a waypoint. Tags
+// kept across the replacement keep their color.
+func replaceTags(name string, lat, lon float64, tags []string) error {
+	logger.Debug("replaceTags name=%q lat=%.6f lon=%.6f tags=%v", name, lat, lon, tags)
+	if tagDB.Load() == nil {
+		return nil
+	}
+	defer markTagsChanged()
+	tx, err := tagDB.Load().Begin()
+	if err != nil {
+		return err
+	}
+	colors := map[string]string{}
+	rows, err := tx.Query(`SELECT tag, color FROM waypoint_tags WHERE name = ? AND lat = ? AND lon = ? AND color <> ''`, name, lat, lon)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for rows.Next() {
+		var t, c string
+		if err := rows.Scan(&t, &c); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return err
+		}
+		colors[t] = c
+	}
+	rows.Close()
+	if _, err := tx.Exec(`DELETE FROM waypoint_tags WHERE name = ? AND lat = ? AND lon = ?`, name, lat, lon); err != nil {
+		tx.Rollback()
+		return err
+	}
+	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO waypoint_tags(name, lat, lon, tag, color) VALUES(?,?,?,?,?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		c, ok := colors[t]
+		if !ok {
+			_ = tx.QueryRow(`SELECT color FROM waypoint_tags WHERE tag = ? AND color <> '' LIMIT 1`, t).Scan(&c)
+		}
+		if _, err := stmt.Exec(name, lat, lon, t, c); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// validTagColor accepts "#rgb", "#rgba", "#rrggbb", "#rrggbbaa" or a plain
+// color name (letters only, as understood by QML).
+func validTagColor(c string) bool {
+	if strings.HasPrefix(c, "#") {
+		switch len(c) {
+		case 4, 5, 7, 9:
+			_, err := strconv.ParseUint(c[1:], 16, 32)
+			return err == nil
+		}
+		return false
+	}
+	if c == "" || len(c) > 32 {
+		return false
+	}
+	for _, r := range c {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// setTagColors assigns colors[i] to tags[i] on every waypoint carrying it.
+// Empty colors are skipped, so unset entries leave the current color alone.
+func setTagColors(tags, colors []string) error {
+	if tagDB.Load() == nil {
+		return nil
+	}
+	for i, t := range tags {
+		t = strings.TrimSpace(t)
+		if i >= len(colors) || t == "" || colors[i] == "" {
+			continue
+		}
+		if _, err := tagDB.Load().Exec(`UPDATE waypoint_tags SET color = ? WHERE tag = ?`, colors[i], t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getTagColors returns the colors assigned to tags, keyed by raw tag. It is
+// loaded once per request and passed to enrichTag. Returns nil when the tag DB
+// is unavailable or the query fails (tags are then simply uncolored).
+func getTagColors() map[string]string {
+	db := tagDB.Load()
+	if db == nil {
+		return nil
+	}
+	rows, err := db.Query(`SELECT tag, color FROM waypoint_tags WHERE color <> '' GROUP BY tag`)
+	if err != nil {
+		logger.Debug("getTagColors query error: %v", err)
+		return nil
+	}
+	defer rows.Close()
+	colors := make(map[string]string)
+	for rows.Next() {
+		var tag, color string
+		if err := rows.Scan(&tag, &color); err == nil {
+			colors[tag] = color
+		}
+	}
+	return colors
+}
+
 // getTagsFor returns all tags for a waypoint.
 func getTagsFor(name string, lat, lon float64) ([]string, error) {
 	logger.Debug("getTagsFor name=%q lat=%.6f lon=%.6f", name, lat, lon)
-	if tagDB == nil {
+	if tagDB.Load() == nil {
 		return nil, nil
 	}
-	rows, err := tagDB.Query(`SELECT tag FROM waypoint_tags WHERE name = ? AND lat = ? AND lon = ? ORDER BY tag COLLATE NOCASE`, name, lat, lon)
+	rows, err := tagDB.Load().Query(`SELECT tag FROM waypoint_tags WHERE name = ? AND lat = ? AND lon = ? ORDER BY tag COLLATE NOCASE`, name, lat, lon)
 	if err != nil {
 		return nil, err
 	}
@@ -1644,13 +5233,94 @@ func getTagsFor(name string, lat, lon float64) ([]string, error) {
 // deleteTag removes one tag for a waypoint.
 func deleteTag(name string, lat, lon float64, tag string) error {
 	logger.Debug("deleteTag name=%q lat=%.6f lon=%.6f tag=%q", name, lat, lon, tag)
-	if tagDB == nil {
+	if tagDB.Load() == nil {
 		return nil
 	}
-	_, err := tagDB.Exec(`DELETE FROM waypoint_tags WHERE name = ? AND lat = ? AND lon = ? AND tag = ?`, name, lat, lon, tag)
+	defer markTagsChanged()
+	_, err := tagDB.Load().Exec(`DELETE FROM waypoint_tags WHERE name = ? AND lat = ? AND lon = ? AND tag = ?`, name, lat, lon, tag)
 	return err
 }
 
+// deleteWaypointTagsSQL deletes the tag rows of a name within a lat/lon box;
+// the ranges (rather than ABS) let SQLite use the primary key index.
+const deleteWaypointTagsSQL = `DELETE FROM waypoint_tags WHERE name = ? AND lat BETWEEN ? AND ? AND lon BETWEEN ? AND ?`
+
+// deleteWaypointTags removes every tag row of a waypoint (name + coordinates
+// within waypointEpsilon) and returns how many rows were deleted.
+func deleteWaypointTags(name string, lat, lon float64) (int64, error) {
+	if tagDB.Load() == nil {
+		return 0, nil
+	}
+	res, err := tagDB.Load().Exec(deleteWaypointTagsSQL,
+		name, lat-waypointEpsilon, lat+waypointEpsilon, lon-waypointEpsilon, lon+waypointEpsilon)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	if n > 0 {
+		markTagsChanged()
+	}
+	return n, nil
+}
+
+// pruneRemovedWaypointTags deletes the tags of the waypoints in before that
+// are no longer in the store (e.g. dropped by a re-import) in one transaction
+// and returns the number of tag rows removed.
+func pruneRemovedWaypointTags(before []Waypoint) int64 {
+	db := tagDB.Load()
+	if db == nil {
+		return 0
+	}
+	present := map[string]bool{}
+	waypointStore.View(func(wps []Waypoint) {
+		for _, wp := range wps {
+			present[waypointKey(wp)] = true
+		}
+	})
+	var removed []Waypoint
+	for _, wp := range before {
+		if wp.Name == "" || present[waypointKey(wp)] {
+			continue
+		}
+		present[waypointKey(wp)] = true // once per key
+		removed = append(removed, wp)
+	}
+	if len(removed) == 0 {
+		return 0
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Error("tag cleanup failed: %v", err)
+		return 0
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(deleteWaypointTagsSQL)
+	if err != nil {
+		logger.Error("tag cleanup failed: %v", err)
+		return 0
+	}
+	defer stmt.Close()
+	var total int64
+	for _, wp := range removed {
+		res, err := stmt.Exec(wp.Name, wp.Lat-waypointEpsilon, wp.Lat+waypointEpsilon, wp.Lon-waypointEpsilon, wp.Lon+waypointEpsilon)
+		if err != nil {
+			logger.Error("tag cleanup failed for %q: %v", wp.Name, err)
+			return 0
+		}
+		n, _ := res.RowsAffected()
+		total += n
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Error("tag cleanup failed: %v", err)
+		return 0
+	}
+	if total > 0 {
+		markTagsChanged()
+	}
+	return total
+}
+
 // Handlers for tag API (rewritten with backend enrichment & distinct mode)
 //
 // New modes:
@@ -1671,6 +5341,7 @@ type TagDTO struct {
 	Name    string `json:"name,omitempty"`
 	Display string `json:"display"`
 	Normal  string `json:"normal,omitempty"` // canonical lowercase / symbol-collapsed form (backend normalized)
+	Color   string `json:"color,omitempty"`  // optional display color set via POST /api/tags
 }
 
 // tagEmojiMap centralizes the mapping (word keys stored lowercase).
@@ -1709,8 +5380,16 @@ var tagEmojiMap = map[string]struct{ Emoji, Name string }{
 	"diving":     {"🤿", "diving"},
 }
 
-// enrichTag converts a raw tag to a TagDTO (adding emoji/name if known).
-func enrichTag(raw string) TagDTO {
+// enrichTag converts a raw tag to a TagDTO (adding emoji/name if known, and
+// the tag's color from colors, see getTagColors).
+func enrichTag(raw string, colors map[string]string) TagDTO {
+	dto := emojiTag(raw)
+	dto.Color = colors[dto.Raw]
+	return dto
+}
+
+// emojiTag is the emoji/name part of enrichTag.
+func emojiTag(raw string) TagDTO {
 	r := strings.TrimSpace(raw)
 	if r == "" {
 		return TagDTO{Raw: raw, Display: raw, Normal: ""}
@@ -1802,12 +5481,117 @@ func enrichTag(raw string) TagDTO {
 		}
 	}
 
-	// 3. Mixed content or no mapping: leave raw
-	return TagDTO{
-		Raw:     r,
-		Display: r,
-		Normal:  norm,
+	// 3. Mixed content or no mapping: leave raw
+	return TagDTO{
+		Raw:     r,
+		Display: r,
+		Normal:  norm,
+	}
+}
+
+// GET /api/tags/emoji?tag=coffee
+// Returns the enriched TagDTO for a single tag (same logic as emoji=true listings).
+func handleGetTagEmoji(w http.ResponseWriter, r *http.Request) {
+	tag := strings.TrimSpace(r.URL.Query().Get("tag"))
+	if tag == "" {
+		http.Error(w, "tag required", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(enrichTag(tag, getTagColors()))
+}
+
+// GET /api/tags/emoji/all
+// Returns the full tag -> { emoji, name } mapping.
+func handleGetTagEmojiAll(w http.ResponseWriter, _ *http.Request) {
+	out := make(map[string]map[string]string, len(tagEmojiMap))
+	for k, v := range tagEmojiMap {
+		out[k] = map[string]string{"emoji": v.Emoji, "name": v.Name}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// GET /api/tags/centroids
+// Returns [ { tag, count, lat, lon } ] with the centroid of all waypoints
+// carrying each tag, sorted by count (desc) then tag. Tag rows whose waypoint
+// no longer exists in the waypoint store are ignored.
+func handleGetTagCentroids(w http.ResponseWriter, r *http.Request) {
+	if !requireTagDB(w) {
+		return
+	}
+	rows, err := tagDB.Load().QueryContext(r.Context(), `SELECT name, lat, lon, tag FROM waypoint_tags`)
+	if err != nil {
+		http.Error(w, "query error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var known map[waypointRef]bool
+	waypointStore.View(func(wps []Waypoint) {
+		known = make(map[waypointRef]bool, len(wps))
+		for _, wp := range wps {
+			known[waypointRef{wp.Name, wp.Lat, wp.Lon}] = true
+		}
+	})
+
+	// Average unit vectors so tags spanning the antimeridian get a sensible centre.
+	type acc struct {
+		x, y, z float64
+		count   int
+	}
+	byTag := make(map[string]*acc)
+	for rows.Next() {
+		var ref waypointRef
+		var tag string
+		if err := rows.Scan(&ref.Name, &ref.Lat, &ref.Lon, &tag); err != nil {
+			http.Error(w, "scan error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !known[ref] {
+			continue
+		}
+		a := byTag[tag]
+		if a == nil {
+			a = &acc{}
+			byTag[tag] = a
+		}
+		latR, lonR := ref.Lat*math.Pi/180, ref.Lon*math.Pi/180
+		a.x += math.Cos(latR) * math.Cos(lonR)
+		a.y += math.Cos(latR) * math.Sin(lonR)
+		a.z += math.Sin(latR)
+		a.count++
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "query error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	type centroid struct {
+		Tag   string  `json:"tag"`
+		Count int     `json:"count"`
+		Lat   float64 `json:"lat"`
+		Lon   float64 `json:"lon"`
+	}
+	out := make([]centroid, 0, len(byTag))
+	for tag, a := range byTag {
+		n := float64(a.count)
+		x, y, z := a.x/n, a.y/n, a.z/n
+		out = append(out, centroid{
+			Tag:   tag,
+			Count: a.count,
+			Lat:   math.Atan2(z, math.Hypot(x, y)) * 180 / math.Pi,
+			Lon:   math.Atan2(y, x) * 180 / math.Pi,
+		})
 	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Tag < out[j].Tag
+	})
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
 }
 
 // normalizeTagKey produces a canonical comparison key:
@@ -1893,10 +5677,10 @@ func unifyDistinctTags(raw []string) []string {
 
 // getDistinctTags returns unique raw tags sorted case-insensitively.
 func getDistinctTags() ([]string, error) {
-	if tagDB == nil {
+	if tagDB.Load() == nil {
 		return nil, nil
 	}
-	rows, err := tagDB.Query(`SELECT DISTINCT tag FROM waypoint_tags ORDER BY tag COLLATE NOCASE`)
+	rows, err := tagDB.Load().Query(`SELECT DISTINCT tag FROM waypoint_tags ORDER BY tag COLLATE NOCASE`)
 	if err != nil {
 		return nil, err
 	}
@@ -1912,8 +5696,77 @@ func getDistinctTags() ([]string, error) {
 	return out, nil
 }
 
+// getTagFrequencies returns the number of waypoints carrying each normalized tag key.
+func getTagFrequencies() (map[string]int, error) {
+	if tagDB.Load() == nil {
+		return nil, nil
+	}
+	rows, err := tagDB.Load().Query(`SELECT name, lat, lon, tag FROM waypoint_tags`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	// Count each waypoint once per normalized key (variants like "*" and "⭐" collapse).
+	type wkey struct {
+		name     string
+		lat, lon float64
+		norm     string
+	}
+	seen := make(map[wkey]struct{})
+	freq := make(map[string]int)
+	for rows.Next() {
+		var name, tag string
+		var lat, lon float64
+		if err := rows.Scan(&name, &lat, &lon, &tag); err != nil {
+			return nil, err
+		}
+		k := wkey{name, lat, lon, normalizeTagKey(tag)}
+		if k.norm == "" {
+			continue
+		}
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		freq[k.norm]++
+	}
+	return freq, rows.Err()
+}
+
+// pickPrimaryTag selects the tag used for a waypoint's icon/emoji. By default the
+// globally least common normalized key wins (most distinctive); preferCommon
+// flips that to the most common. Ties fall back to alphabetical order.
+func pickPrimaryTag(tags []string, freq map[string]int, preferCommon bool) string {
+	best := ""
+	bestKey := ""
+	bestCount := 0
+	for _, t := range tags {
+		n := normalizeTagKey(t)
+		if n == "" {
+			continue
+		}
+		c := freq[n]
+		better := best == ""
+		if !better {
+			switch {
+			case preferCommon && c > bestCount, !preferCommon && c < bestCount:
+				better = true
+			case c == bestCount && n < bestKey:
+				better = true
+			}
+		}
+		if better {
+			best, bestKey, bestCount = t, n, c
+		}
+	}
+	return best
+}
+
 // GET /api/tags (per-waypoint or distinct)
 func handleGetTags(w http.ResponseWriter, r *http.Request) {
+	if !requireTagDB(w) {
+		return
+	}
 	q := r.URL.Query()
 	useEmoji := strings.EqualFold(q.Get("emoji"), "true")
 	distinct := strings.EqualFold(q.Get("distinct"), "true")
@@ -1931,9 +5784,10 @@ func handleGetTags(w http.ResponseWriter, r *http.Request) {
 		// Normalize & unify variants (e.g. ⭐, ** -> *) before responding
 		raw = unifyDistinctTags(raw)
 		if useEmoji {
+			colors := getTagColors()
 			enriched := make([]TagDTO, 0, len(raw))
 			for _, t := range raw {
-				e := enrichTag(t)
+				e := enrichTag(t, colors)
 				enriched = append(enriched, e)
 			}
 			_ = json.NewEncoder(w).Encode(map[string]any{
@@ -1950,15 +5804,25 @@ func handleGetTags(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Per-waypoint mode
-	if name == "" || latStr == "" || lonStr == "" {
-		http.Error(w, "missing name/lat/lon or distinct=true", http.StatusBadRequest)
-		return
-	}
-	lat, err1 := strconv.ParseFloat(latStr, 64)
-	lon, err2 := strconv.ParseFloat(lonStr, 64)
-	if err1 != nil || err2 != nil {
-		http.Error(w, "invalid lat/lon", http.StatusBadRequest)
-		return
+	var lat, lon float64
+	if id := q.Get("id"); id != "" {
+		wp, ok := resolveWaypointID(w, id)
+		if !ok {
+			return
+		}
+		name, lat, lon = wp.Name, wp.Lat, wp.Lon
+	} else {
+		if name == "" || latStr == "" || lonStr == "" {
+			http.Error(w, "missing name/lat/lon (or id) or distinct=true", http.StatusBadRequest)
+			return
+		}
+		var err1, err2 error
+		lat, err1 = strconv.ParseFloat(latStr, 64)
+		lon, err2 = strconv.ParseFloat(lonStr, 64)
+		if err1 != nil || err2 != nil {
+			http.Error(w, "invalid lat/lon", http.StatusBadRequest)
+			return
+		}
 	}
 	rawTags, err := getTagsFor(name, lat, lon)
 	if err != nil {
@@ -1968,150 +5832,609 @@ func handleGetTags(w http.ResponseWriter, r *http.Request) {
 	if useEmoji {
 		// Normalize & unify variants for this waypoint before enrichment
 		rawUnified := unifyDistinctTags(rawTags)
+		colors := getTagColors()
 		enriched := make([]TagDTO, 0, len(rawUnified))
 		for _, t := range rawUnified {
-			enriched = append(enriched, enrichTag(t))
+			enriched = append(enriched, enrichTag(t, colors))
 		}
 		_ = json.NewEncoder(w).Encode(map[string]any{
+			"id":   waypointID(Waypoint{Name: name, Lat: lat, Lon: lon}),
 			"name": name, "lat": lat, "lon": lon,
 			"tags": enriched,
 		})
 		return
 	}
 	_ = json.NewEncoder(w).Encode(map[string]any{
+		"id":   waypointID(Waypoint{Name: name, Lat: lat, Lon: lon}),
 		"name": name, "lat": lat, "lon": lon,
 		"tags": rawTags,
 	})
 }
 
-// POST /api/tags?emoji=true  JSON: { name, lat, lon, tags: [] }
+// POST /api/tags?emoji=true&mode=append|replace  JSON: { name, lat, lon, tags: [], colors?: [] }
+// colors, when present, runs parallel to tags ("" leaves a tag's color unchanged);
+// a color applies to the tag everywhere it is used. mode=replace leaves the
+// waypoint with exactly the submitted tags (an empty list clears them), in one
+// transaction; the default mode=append only adds.
 func handlePostTags(w http.ResponseWriter, r *http.Request) {
+	if !requireTagDB(w) {
+		return
+	}
 	useEmoji := strings.EqualFold(r.URL.Query().Get("emoji"), "true")
+	replace := false
+	switch strings.ToLower(r.URL.Query().Get("mode")) {
+	case "", "append":
+	case "replace":
+		replace = true
+	default:
+		http.Error(w, "mode must be append or replace", http.StatusBadRequest)
+		return
+	}
 	var req struct {
-		Name string   `json:"name"`
-		Lat  float64  `json:"lat"`
-		Lon  float64  `json:"lon"`
-		Tags []string `json:"tags"`
+		ID     string   `json:"id"` // alternative to name + lat + lon
+		Name   string   `json:"name"`
+		Lat    float64  `json:"lat"`
+		Lon    float64  `json:"lon"`
+		Tags   []string `json:"tags"`
+		Colors []string `json:"colors"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &req) {
 		return
 	}
-	if strings.TrimSpace(req.Name) == "" || len(req.Tags) == 0 {
+	if req.ID != "" {
+		wp, ok := resolveWaypointID(w, req.ID)
+		if !ok {
+			return
+		}
+		req.Name, req.Lat, req.Lon = wp.Name, wp.Lat, wp.Lon
+	}
+	if strings.TrimSpace(req.Name) == "" || len(req.Tags) == 0 && !replace {
 		http.Error(w, "name and tags required", http.StatusBadRequest)
 		return
 	}
+	if len(req.Colors) > 0 && len(req.Colors) != len(req.Tags) {
+		http.Error(w, "colors must have one entry per tag", http.StatusBadRequest)
+		return
+	}
+	for i, c := range req.Colors {
+		c = strings.TrimSpace(c)
+		if c != "" && !validTagColor(c) {
+			http.Error(w, "invalid color: "+c, http.StatusBadRequest)
+			return
+		}
+		req.Colors[i] = c
+	}
 	// Store tags verbatim (no frontend preprocessing anymore).
-	if err := addTagsToDB(req.Name, req.Lat, req.Lon, req.Tags); err != nil {
+	if replace {
+		if err := replaceTags(req.Name, req.Lat, req.Lon, req.Tags); err != nil {
+			http.Error(w, "replace error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else if err := addTagsToDB(req.Name, req.Lat, req.Lon, req.Tags); err != nil {
 		http.Error(w, "insert error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if err := setTagColors(req.Tags, req.Colors); err != nil {
+		http.Error(w, "color update error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 	raw, _ := getTagsFor(req.Name, req.Lat, req.Lon)
+	if raw == nil {
+		raw = []string{}
+	}
+	publishTagsChanged(req.Name, req.Lat, req.Lon, raw)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	if useEmoji {
+		colors := getTagColors()
+		enriched := make([]TagDTO, 0, len(raw))
+		for _, t := range raw {
+			enriched = append(enriched, enrichTag(t, colors))
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"id":   waypointID(Waypoint{Name: req.Name, Lat: req.Lat, Lon: req.Lon}),
+			"name": req.Name, "lat": req.Lat, "lon": req.Lon,
+			"tags": enriched,
+		})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"id":   waypointID(Waypoint{Name: req.Name, Lat: req.Lat, Lon: req.Lon}),
+		"name": req.Name, "lat": req.Lat, "lon": req.Lon,
+		"tags": raw,
+	})
+}
+
+// PATCH /api/tags?emoji=true  JSON: { name, lat, lon, tags: [] }
+// Replaces the waypoint's entire tag set (an empty list clears it).
+func handlePatchTags(w http.ResponseWriter, r *http.Request) {
+	if !requireTagDB(w) {
+		return
+	}
+	useEmoji := strings.EqualFold(r.URL.Query().Get("emoji"), "true")
+	var req struct {
+		ID   string   `json:"id"` // alternative to name + lat + lon
+		Name string   `json:"name"`
+		Lat  float64  `json:"lat"`
+		Lon  float64  `json:"lon"`
+		Tags []string `json:"tags"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if req.ID != "" {
+		wp, ok := resolveWaypointID(w, req.ID)
+		if !ok {
+			return
+		}
+		req.Name, req.Lat, req.Lon = wp.Name, wp.Lat, wp.Lon
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		http.Error(w, "name required", http.StatusBadRequest)
+		return
+	}
+	if err := replaceTags(req.Name, req.Lat, req.Lon, req.Tags); err != nil {
+		http.Error(w, "replace error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	raw, _ := getTagsFor(req.Name, req.Lat, req.Lon)
+	if raw == nil {
+		raw = []string{}
+	}
+	publishTagsChanged(req.Name, req.Lat, req.Lon, raw)
+	w.Header().Set("Content-Type", "application/json")
+	if useEmoji {
+		colors := getTagColors()
 		enriched := make([]TagDTO, 0, len(raw))
 		for _, t := range raw {
-			enriched = append(enriched, enrichTag(t))
+			enriched = append(enriched, enrichTag(t, colors))
 		}
 		_ = json.NewEncoder(w).Encode(map[string]any{
+			"id":   waypointID(Waypoint{Name: req.Name, Lat: req.Lat, Lon: req.Lon}),
 			"name": req.Name, "lat": req.Lat, "lon": req.Lon,
 			"tags": enriched,
 		})
 		return
 	}
 	_ = json.NewEncoder(w).Encode(map[string]any{
+		"id":   waypointID(Waypoint{Name: req.Name, Lat: req.Lat, Lon: req.Lon}),
 		"name": req.Name, "lat": req.Lat, "lon": req.Lon,
 		"tags": raw,
 	})
 }
 
+// PATCH /api/tags/rename  JSON: { "from": "...", "to": "..." }
+// Renames a tag on every waypoint; from matches emoji/text variants via normalizeTagKey.
+func handlePatchTagRename(w http.ResponseWriter, r *http.Request) {
+	if !requireTagDB(w) {
+		return
+	}
+	var req struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	from, to := strings.TrimSpace(req.From), strings.TrimSpace(req.To)
+	if from == "" || to == "" {
+		http.Error(w, "from and to required", http.StatusBadRequest)
+		return
+	}
+	renamed, merged, err := renameTagGlobally(from, to)
+	if err != nil {
+		http.Error(w, "rename error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.DebugCtx(r.Context(), "PATCH /api/tags/rename from=%q to=%q renamed=%d merged=%d", from, to, renamed, merged)
+	if renamed+merged > 0 {
+		publishEvent(eventTagsChanged, map[string]any{"from": from, "to": to, "changed": renamed + merged})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"from":    from,
+		"to":      to,
+		"changed": renamed + merged,
+		"renamed": renamed,
+		"merged":  merged,
+	})
+}
+
 // DELETE /api/tags?name=&lat=&lon=&tag=&emoji=true
 func handleDeleteTag(w http.ResponseWriter, r *http.Request) {
+	if !requireTagDB(w) {
+		return
+	}
 	q := r.URL.Query()
 	useEmoji := strings.EqualFold(q.Get("emoji"), "true")
 	name := strings.TrimSpace(q.Get("name"))
 	tag := strings.TrimSpace(q.Get("tag"))
 	latStr := q.Get("lat")
 	lonStr := q.Get("lon")
-	if name == "" || tag == "" || latStr == "" || lonStr == "" {
-		http.Error(w, "name, lat, lon, tag required", http.StatusBadRequest)
-		return
-	}
-	lat, err1 := strconv.ParseFloat(latStr, 64)
-	lon, err2 := strconv.ParseFloat(lonStr, 64)
-	if err1 != nil || err2 != nil {
-		http.Error(w, "invalid lat/lon", http.StatusBadRequest)
-		return
+	var lat, lon float64
+	if id := q.Get("id"); id != "" && tag != "" {
+		wp, ok := resolveWaypointID(w, id)
+		if !ok {
+			return
+		}
+		name, lat, lon = wp.Name, wp.Lat, wp.Lon
+	} else {
+		if name == "" || tag == "" || latStr == "" || lonStr == "" {
+			http.Error(w, "name, lat, lon (or id) and tag required", http.StatusBadRequest)
+			return
+		}
+		var err1, err2 error
+		lat, err1 = strconv.ParseFloat(latStr, 64)
+		lon, err2 = strconv.ParseFloat(lonStr, 64)
+		if err1 != nil || err2 != nil {
+			http.Error(w, "invalid lat/lon", http.StatusBadRequest)
+			return
+		}
 	}
 	if err := deleteTag(name, lat, lon, tag); err != nil {
 		http.Error(w, "delete error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 	raw, _ := getTagsFor(name, lat, lon)
+	publishTagsChanged(name, lat, lon, raw)
 	w.Header().Set("Content-Type", "application/json")
 	if useEmoji {
+		colors := getTagColors()
 		enriched := make([]TagDTO, 0, len(raw))
 		for _, t := range raw {
-			enriched = append(enriched, enrichTag(t))
+			enriched = append(enriched, enrichTag(t, colors))
 		}
 		_ = json.NewEncoder(w).Encode(map[string]any{
+			"id":   waypointID(Waypoint{Name: name, Lat: lat, Lon: lon}),
 			"name": name, "lat": lat, "lon": lon,
 			"tags": enriched,
 		})
 		return
 	}
 	_ = json.NewEncoder(w).Encode(map[string]any{
+		"id":   waypointID(Waypoint{Name: name, Lat: lat, Lon: lon}),
 		"name": name, "lat": lat, "lon": lon,
 		"tags": raw,
 	})
 }
 
+// routeCacheControl sets the client caching policy per route pattern.
+// Handlers may still override it (tiles set their own max-age).
+var routeCacheControl = map[string]string{
+	"GET /api/version":          "public, max-age=3600",
+	"GET /api/map/default-view": "max-age=300",
+	"GET /api/location":         "no-store",
+	"GET /api/location/share":   "no-store",
+	"GET /api/track":            "no-store",
+	"GET /api/tiles/stats":      "no-store",
+	"GET /api/tiles/config":     "no-store",
+	"GET /api/waypoints":        "no-cache",
+	"GET /api/bookmarks":        "no-cache",
+	"GET /api/clusters":         "no-cache",
+	"GET /api/tags":             "no-cache",
+	"GET /api/tags/emoji/all":   "public, max-age=3600",
+	"GET /api/tags/emoji-map":   "public, max-age=3600",
+	"GET /api/recent_suggest":   "no-cache",
+}
+
+// withCacheControl sets the Cache-Control header before invoking h.
+func withCacheControl(value string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", value)
+		h(w, r)
+	}
+}
+
+// defaultMaxBodyBytes caps request bodies of mutating routes (WHEREAMI_MAX_BODY_BYTES).
+const defaultMaxBodyBytes = 1 << 20
+
+// ownBodyLimitRoutes enforce a (larger) body limit themselves.
+var ownBodyLimitRoutes = map[string]bool{
+	"POST /api/bookmarks/attachment": true,
+}
+
+// maxBodyBytes returns the configured request body limit in bytes.
+func maxBodyBytes() int64 {
+	if v := os.Getenv("WHEREAMI_MAX_BODY_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultMaxBodyBytes
+}
+
+// withBodyLimit bounds the request body so oversized payloads fail while
+// decoding instead of being buffered in full.
+func withBodyLimit(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes())
+		h(w, r)
+	}
+}
+
+// decodeJSONBody decodes the request body into v. On failure it writes 413
+// when the body limit was exceeded (400 otherwise) and returns false.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err == nil {
+		return true
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("request body too large (max %d bytes)", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return false
+	}
+	http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+	return false
+}
+
+// gzipMinBytes is the smallest JSON response body withGzip compresses.
+const gzipMinBytes = 1024
+
+// noGzipRoutes already serve compressed (binary) content.
+var noGzipRoutes = map[string]bool{
+	"GET /api/tiles/":               true,
+	"GET /api/bookmarks/attachment": true,
+	"GET /api/events":               true,
+}
+
+var gzipWriterPool = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+
+// acceptsGzip reports whether the request's Accept-Encoding allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false // explicitly refused
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter holds back the start of a response until it knows
+// whether to compress it: only JSON bodies of at least gzipMinBytes are
+// gzip-encoded; everything else is passed through unchanged.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	buf     []byte
+	gz      *gzip.Writer
+	decided bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(code int) {
+	if g.status == 0 {
+		g.status = code
+	}
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if g.decided {
+		if g.gz != nil {
+			return g.gz.Write(p)
+		}
+		return g.ResponseWriter.Write(p)
+	}
+	g.buf = append(g.buf, p...)
+	if len(g.buf) >= gzipMinBytes {
+		if err := g.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// decide picks plain or gzip output, sends the header and writes the buffered bytes.
+func (g *gzipResponseWriter) decide() error {
+	g.decided = true
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+	h := g.ResponseWriter.Header()
+	if len(g.buf) >= gzipMinBytes && h.Get("Content-Encoding") == "" &&
+		strings.HasPrefix(h.Get("Content-Type"), "application/json") {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		g.gz = gzipWriterPool.Get().(*gzip.Writer)
+		g.gz.Reset(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(g.status)
+	var err error
+	if len(g.buf) > 0 {
+		if g.gz != nil {
+			_, err = g.gz.Write(g.buf)
+		} else {
+			_, err = g.ResponseWriter.Write(g.buf)
+		}
+	}
+	g.buf = nil
+	return err
+}
+
+// finish writes anything still held back and closes the gzip stream.
+func (g *gzipResponseWriter) finish() {
+	if !g.decided && (g.status != 0 || len(g.buf) > 0) {
+		_ = g.decide()
+	}
+	if g.gz != nil {
+		_ = g.gz.Close()
+		gzipWriterPool.Put(g.gz)
+		g.gz = nil
+	}
+}
+
+// withGzip compresses large JSON responses for clients sending
+// Accept-Encoding: gzip. Small responses keep their Content-Length.
+func withGzip(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			h(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.finish()
+		h(gw, r)
+	}
+}
+
 func RegisterAPI(mux *http.ServeMux, bookmarksPath string, debug bool) {
 	if mux == nil {
 		mux = http.DefaultServeMux
 	}
 	// Initialize tag DB (idempotent)
 	initTagDB()
+	tagEmojiOnce.Do(loadCustomTagEmoji)
+
+	// Bound search history size (no-op unless retention is configured)
+	startHistoryPruner()
+	// Expire geocode cache rows (no-op unless WHEREAMI_GEOCODE_TTL is set)
+	startGeocodePruner()
+
+	// The track log needs fixes flowing even before the UI asks for one
+	if trackLogEnabled() {
+		ensureLocationTracking()
+	}
 
 	// Initialize tile proxy once
 	tileProxyOnce.Do(func() {
 		globalProxy = initTileProxy(debug)
 		globalProxy.startPrunerOnce()
+		globalProxy.startRoutePrewarm()
 	})
 
+	// handle registers a route, applying its Cache-Control policy (if any),
+	// the request body limit (mutating methods) and gzip negotiation (except
+	// for routes serving binary content).
+	handle := func(pattern string, h http.HandlerFunc) {
+		if cc, ok := routeCacheControl[pattern]; ok {
+			h = withCacheControl(cc, h)
+		}
+		method, _, _ := strings.Cut(pattern, " ")
+		if (method == "POST" || method == "PATCH" || method == "PUT" || method == "DELETE") && !ownBodyLimitRoutes[pattern] {
+			h = withBodyLimit(h)
+		}
+		if !noGzipRoutes[pattern] {
+			h = withGzip(h)
+		}
+		mux.HandleFunc(pattern, h)
+	}
+
 	// Bookmarks (CORS)
-	mux.HandleFunc("OPTIONS /api/bookmarks", handlePostBookmark(bookmarksPath))
-	mux.HandleFunc("POST /api/bookmarks", handlePostBookmark(bookmarksPath))
-	mux.HandleFunc("PATCH /api/bookmarks", handlePatchBookmark(bookmarksPath))
-	mux.HandleFunc("DELETE /api/bookmarks", handleDeleteBookmark(bookmarksPath))
+	handle("OPTIONS /api/bookmarks", handlePostBookmark(bookmarksPath))
+	handle("POST /api/bookmarks", handlePostBookmark(bookmarksPath))
+	handle("PATCH /api/bookmarks", handlePatchBookmark(bookmarksPath))
+	handle("DELETE /api/bookmarks", handleDeleteBookmark(bookmarksPath))
+	handle("GET /api/bookmarks", handleGetBookmarks)
+	handle("POST /api/bookmarks/geocode", handlePostGeocodeBookmark(bookmarksPath))
+	handle("GET /api/bookmarks/export", handleGetBookmarksExport)
+	handle("GET /api/bookmarks/duplicates", handleGetBookmarkDuplicates)
+	handle("POST /api/bookmarks/in-polygon", handlePostBookmarksInPolygon)
+	handle("GET /api/export", handleGetExport)
+	handle("POST /api/export/visible", handlePostExportVisible)
 
 	// Waypoints & clusters
-	mux.HandleFunc("GET /api/waypoints", handleGetWaypoints)
-	mux.HandleFunc("GET /api/clusters", handleGetClusters)
+	handle("GET /api/waypoints", handleGetWaypoints)
+	handle("GET /api/clusters", handleGetClusters)
+	handle("GET /api/waypoints/cluster-tree", handleGetClusterTree)
+	handle("GET /api/clusters/expand", handleGetClusterExpand)
+	handle("GET /api/waypoints/nearest", handleGetNearestWaypoints)
+	handle("GET /api/waypoints/timeline", handleGetWaypointsTimeline)
+	handle("GET /api/waypoints/search", handleGetWaypointSearch)
+	handle("GET /api/tracks", handleGetTracks)
+	handle("POST /api/bookmarks/attachment", handlePostBookmarkAttachment)
+	handle("GET /api/bookmarks/attachment", handleGetBookmarkAttachment)
+	handle("POST /api/maintenance/reindex-db", handlePostReindexDB)
+	handle("POST /api/maintenance/migrate-data", handlePostMigrateData(bookmarksPath))
+	handle("GET /api/tracks/{id}", handleGetTrackByID)
+	handle("DELETE /api/tracks/{id}", handleDeleteTrack)
+	handle("GET /api/tracks/{id}/simplify", handleGetTrackSimplify)
+	handle("PATCH /api/waypoints/batch", handlePatchWaypointsBatch(bookmarksPath))
 
 	// Tiles
-	mux.HandleFunc("GET /api/tiles/stats", globalProxy.serveStats)
-	mux.HandleFunc("GET /api/tiles/", globalProxy.serveTile)
+	handle("GET /api/tiles/stats", globalProxy.serveStats)
+	handle("GET /api/tiles/config", globalProxy.serveConfig)
+	handle("GET /api/tiles/coverage", globalProxy.serveCoverage)
+	handle("GET /api/tiles/estimate", globalProxy.serveEstimate)
+	handle("GET /api/tiles/cache", globalProxy.serveRegionCache)
+	handle("DELETE /api/tiles/cache", globalProxy.serveRegionCache)
+	handle("POST /api/tiles/prefetch", globalProxy.servePrefetch)
+	handle("POST /api/tiles/purge", globalProxy.servePurge)
+	if debug {
+		// Test-only cache priming; never exposed without --debug.
+		handle("POST /api/tiles/inject", globalProxy.serveInject)
+	}
+	handle("GET /api/tiles/", globalProxy.serveTile)
 
 	// Location
-	mux.HandleFunc("GET /api/location", handleGetLocation)
+	handle("GET /api/location", handleGetLocation)
+	handle("POST /api/location", handlePostLocation)
+	handle("GET /api/location/share", handleGetLocationShare)
+	handle("GET /api/track", handleGetTrack)
+	handle("GET /api/track/export", handleGetTrackExport)
+	handle("POST /api/geofence", handlePostGeofence)
+	handle("GET /api/map/default-view", handleGetDefaultView)
+	handle("POST /api/elevation/profile", handlePostElevationProfile)
 
 	// Import
-	mux.HandleFunc("POST /api/import", handlePostImport)
+	handle("POST /api/import", handlePostImport)
+	handle("GET /api/imports/errors", handleGetImportErrors)
 
 	// Tag management
-	mux.HandleFunc("GET /api/tags", handleGetTags)
-	mux.HandleFunc("POST /api/tags", handlePostTags)
-	mux.HandleFunc("DELETE /api/tags", handleDeleteTag)
+	handle("GET /api/tags", handleGetTags)
+	handle("POST /api/tags", handlePostTags)
+	handle("PATCH /api/tags", handlePatchTags)
+	handle("PATCH /api/tags/rename", handlePatchTagRename)
+	handle("DELETE /api/tags", handleDeleteTag)
+	handle("GET /api/tags/emoji", handleGetTagEmoji)
+	handle("GET /api/tags/emoji/all", handleGetTagEmojiAll)
+	handle("GET /api/tags/emoji-map", handleGetTagEmojiMap)
+	handle("GET /api/tags/schema", handleGetTagSchema)
+	handle("POST /api/tags/repair", handlePostTagRepair)
+	handle("GET /api/tags/centroids", handleGetTagCentroids)
+	handle("GET /api/tags/colors", handleGetTagColors)
+	handle("GET /api/tags/waypoints", handleGetTagWaypoints)
 
 	// Suggest & history
-	mux.HandleFunc("GET /api/suggest", handleGetSuggest)
-	mux.HandleFunc("GET /api/recent_suggest", handleGetRecentSuggest)
-	mux.HandleFunc("POST /api/history", handlePostHistory)
+	handle("GET /api/suggest", handleGetSuggest)
+	handle("GET /api/reverse", handleGetReverse)
+	handle("POST /api/reverse/batch", handlePostReverseBatch)
+	handle("GET /api/recent_suggest", handleGetRecentSuggest)
+	handle("POST /api/history", handlePostHistory)
+	handle("DELETE /api/history", handleDeleteHistory)
 
 	// Version info
-	mux.HandleFunc("GET /api/version", handleGetVersion)
+	handle("GET /api/version", handleGetVersion)
+	handle("GET /api/events", handleGetEvents)
+
+	// Runtime debug toggle
+	handle("POST /api/debug", handlePostDebug)
+}
+
+// POST /api/debug?enabled=true|false toggles verbose debug logging at runtime.
+func handlePostDebug(w http.ResponseWriter, r *http.Request) {
+	enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+	if err != nil {
+		http.Error(w, "enabled must be true or false", http.StatusBadRequest)
+		return
+	}
+	logger.SetDebug(enabled)
+	logger.InfoCtx(r.Context(), "debug logging set to %v via /api/debug", enabled)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"debug": logger.DebugEnabled(),
+	})
 }
 
 // handleGetVersion returns runtime version information
@@ -2153,7 +6476,7 @@ func handleGetVersion(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(versionInfo); err != nil {
-		logger.Error("Failed to encode version info: %v", err)
+		logger.ErrorCtx(r.Context(), "Failed to encode version info: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
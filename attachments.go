@@ -0,0 +1,256 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rubiojr/whereami/pkg/logger"
+)
+
+// Bookmark attachments (one image per bookmark).
+//
+// Files live under <dataDir>/attachments named by bookmark id; attachments.sqlite
+// records the content type, size and upload time. A bookmark id is derived from
+// its dedupe key (name + normalized coordinates), so attachments follow the
+// bookmark when it is renamed or moved (see moveAttachment) and are removed
+// when it is deleted.
+
+// defaultMaxAttachmentBytes caps uploads (WHEREAMI_ATTACHMENT_MAX_BYTES overrides).
+const defaultMaxAttachmentBytes = 10 << 20
+
+var (
+	attachmentsDB     *sql.DB
+	attachmentsDBOnce sync.Once
+	attachmentsMu     sync.Mutex // serializes file + metadata updates
+)
+
+// bookmarkID returns the stable attachment key for a bookmark.
+func bookmarkID(name string, lat, lon float64) string {
+	return waypointID(Waypoint{Name: name, Lat: lat, Lon: lon})
+}
+
+// attachmentsDir returns the directory holding attachment files.
+func attachmentsDir() string {
+	return filepath.Join(effectiveDataDir(), "attachments")
+}
+
+// initAttachmentsDB opens (idempotently) attachments.sqlite in the data directory.
+func initAttachmentsDB() {
+	attachmentsDBOnce.Do(func() {
+		dir := effectiveDataDir()
+		if dir == "" {
+			logger.Error("initAttachmentsDB: no data directory resolved")
+			return
+		}
+		db, err := sql.Open("sqlite", filepath.Join(dir, "attachments.sqlite"))
+		if err != nil {
+			logger.Error("initAttachmentsDB: open failed: %v", err)
+			return
+		}
+		if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS attachments (
+			bookmark_id TEXT PRIMARY KEY,
+			content_type TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		)`); err != nil {
+			logger.Error("initAttachmentsDB: schema error: %v", err)
+			_ = db.Close()
+			return
+		}
+		attachmentsDB = db
+	})
+}
+
+// maxAttachmentBytes returns the upload size limit.
+func maxAttachmentBytes() int64 {
+	if v := os.Getenv("WHEREAMI_ATTACHMENT_MAX_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultMaxAttachmentBytes
+}
+
+// findBookmark reports whether a bookmark with name at lat/lon is loaded.
+func findBookmark(name string, lat, lon float64) bool {
+	wp, ok := waypointStore.Find(name, lat, lon)
+	return ok && wp.Bookmark
+}
+
+// deleteAttachment removes the attachment of a bookmark id (no-op when absent).
+func deleteAttachment(id string) error {
+	initAttachmentsDB()
+	if attachmentsDB == nil {
+		return nil
+	}
+	attachmentsMu.Lock()
+	defer attachmentsMu.Unlock()
+	if err := os.Remove(filepath.Join(attachmentsDir(), id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	_, err := attachmentsDB.Exec(`DELETE FROM attachments WHERE bookmark_id = ?`, id)
+	return err
+}
+
+// moveAttachment re-keys an attachment after a bookmark rename / move (no-op when absent).
+func moveAttachment(fromID, toID string) error {
+	if fromID == toID {
+		return nil
+	}
+	initAttachmentsDB()
+	if attachmentsDB == nil {
+		return nil
+	}
+	attachmentsMu.Lock()
+	defer attachmentsMu.Unlock()
+	res, err := attachmentsDB.Exec(`UPDATE OR REPLACE attachments SET bookmark_id = ? WHERE bookmark_id = ?`, toID, fromID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	return os.Rename(filepath.Join(attachmentsDir(), fromID), filepath.Join(attachmentsDir(), toID))
+}
+
+// POST /api/bookmarks/attachment  multipart: name, lat, lon, file
+// Stores (or replaces) the image attached to a bookmark and returns
+// { id, content_type, size }.
+func handlePostBookmarkAttachment(w http.ResponseWriter, r *http.Request) {
+	corsHeaders(w)
+	initAttachmentsDB()
+	if attachmentsDB == nil {
+		http.Error(w, "attachment database unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	limit := maxAttachmentBytes()
+	r.Body = http.MaxBytesReader(w, r.Body, limit+1<<20) // room for the other form fields
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "attachment too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "invalid multipart form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	name := r.FormValue("name")
+	lat, err1 := strconv.ParseFloat(r.FormValue("lat"), 64)
+	lon, err2 := strconv.ParseFloat(r.FormValue("lon"), 64)
+	if strings.TrimSpace(name) == "" || err1 != nil || err2 != nil {
+		http.Error(w, "name, lat and lon required", http.StatusBadRequest)
+		return
+	}
+	if !findBookmark(name, lat, lon) {
+		http.Error(w, "bookmark not found", http.StatusNotFound)
+		return
+	}
+	file, hdr, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "file required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	if hdr.Size > limit {
+		http.Error(w, fmt.Sprintf("attachment too large (max %d bytes)", limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, "read error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctype := http.DetectContentType(data)
+	if !strings.HasPrefix(ctype, "image/") {
+		http.Error(w, "only images are accepted (got "+ctype+")", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	id := bookmarkID(name, lat, lon)
+	dir := attachmentsDir()
+	if err := ensureDir(dir); err != nil {
+		http.Error(w, "cannot create attachments dir: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	attachmentsMu.Lock()
+	path := filepath.Join(dir, id)
+	err = os.WriteFile(path+".tmp", data, 0o644)
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err == nil {
+		_, err = attachmentsDB.Exec(`INSERT OR REPLACE INTO attachments(bookmark_id, content_type, size, created_at) VALUES(?,?,?,?)`,
+			id, ctype, len(data), time.Now().UTC())
+	}
+	attachmentsMu.Unlock()
+	if err != nil {
+		http.Error(w, "save error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.DebugCtx(r.Context(), "POST /api/bookmarks/attachment name=%q id=%s type=%s size=%d", name, id, ctype, len(data))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"id":           id,
+		"content_type": ctype,
+		"size":         len(data),
+	})
+}
+
+// GET /api/bookmarks/attachment?id=  (or ?name=&lat=&lon=)
+// Serves the attachment with its stored content type.
+func handleGetBookmarkAttachment(w http.ResponseWriter, r *http.Request) {
+	corsHeaders(w)
+	q := r.URL.Query()
+	id := q.Get("id")
+	if id == "" {
+		lat, err1 := strconv.ParseFloat(q.Get("lat"), 64)
+		lon, err2 := strconv.ParseFloat(q.Get("lon"), 64)
+		if q.Get("name") == "" || err1 != nil || err2 != nil {
+			http.Error(w, "id (or name, lat, lon) required", http.StatusBadRequest)
+			return
+		}
+		id = bookmarkID(q.Get("name"), lat, lon)
+	}
+	if _, err := hex.DecodeString(id); err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	initAttachmentsDB()
+	if attachmentsDB == nil {
+		http.Error(w, "attachment database unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var ctype string
+	var created time.Time
+	err := attachmentsDB.QueryRow(`SELECT content_type, created_at FROM attachments WHERE bookmark_id = ?`, id).Scan(&ctype, &created)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "query error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f, err := os.Open(filepath.Join(attachmentsDir(), id))
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", ctype)
+	http.ServeContent(w, r, "", created, f)
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rubiojr/whereami/pkg/logger"
+)
+
+// Precomputed cluster hierarchy.
+//
+// Re-bucketing every waypoint on each /api/clusters request gets slow for
+// dense datasets. Instead, the grid buckets for every zoom level are built once
+// per waypoint-store version and kept in a per-level cell index, so a request
+// only touches the cells in view (when a bbox is given) or the prebuilt list.
+//
+// WaypointStore mutations call markWaypointsChanged() so the index is rebuilt
+// lazily on the next request.
+
+const (
+	defaultClusterGrid = 60
+	clusterTreeMaxZoom = 20
+)
+
+// waypointsVersion increments on every mutation of the waypoint store.
+var waypointsVersion atomic.Uint64
+
+// markWaypointsChanged invalidates derived waypoint indices (cluster tree).
+func markWaypointsChanged() {
+	waypointsVersion.Add(1)
+}
+
+// clusterItem is one output entry at a zoom level: a single waypoint or a cluster.
+type clusterItem struct {
+	cluster  bool
+	lat, lon float64
+	count    int
+	wp       Waypoint   // set for single waypoints
+	cx, cy   int        // grid cell at this zoom
+	bounds   [4]float64 // clusters: member extent (minLat, minLon, maxLat, maxLon)
+}
+
+// toJSON renders the item in the /api/clusters shape.
+func (c clusterItem) toJSON() map[string]any {
+	if !c.cluster {
+		return map[string]any{
+			"type":     "waypoint",
+			"id":       waypointID(c.wp),
+			"lat":      c.wp.Lat,
+			"lon":      c.wp.Lon,
+			"name":     c.wp.Name,
+			"bookmark": c.wp.Bookmark,
+		}
+	}
+	return map[string]any{
+		"type":  "cluster",
+		"lat":   c.lat,
+		"lon":   c.lon,
+		"count": c.count,
+		"bounds": map[string]float64{
+			"minLat": c.bounds[0],
+			"minLon": c.bounds[1],
+			"maxLat": c.bounds[2],
+			"maxLon": c.bounds[3],
+		},
+	}
+}
+
+// extend grows the cluster bounds to include lat/lon.
+func (c *clusterItem) extend(lat, lon float64) {
+	c.bounds[0], c.bounds[1] = math.Min(c.bounds[0], lat), math.Min(c.bounds[1], lon)
+	c.bounds[2], c.bounds[3] = math.Max(c.bounds[2], lat), math.Max(c.bounds[3], lon)
+}
+
+// projectPixels converts lat/lon to world pixel coordinates at zoom (256px tiles).
+func projectPixels(lat, lon float64, zoom int) (x, y float64) {
+	// Clamp to the Web Mercator latitude limits (the poles project to ±Inf)
+	lat = math.Max(math.Min(lat, 85.05112878), -85.05112878)
+	sinLat := math.Sin(lat * math.Pi / 180)
+	n := math.Exp2(float64(zoom))
+	x = (lon + 180.0) / 360.0 * 256.0 * n
+	y = (0.5 - math.Log((1+sinLat)/(1-sinLat))/(4*math.Pi)) * 256.0 * n
+	return x, y
+}
+
+// buildClusters buckets points into grid cells at zoom. Buckets with a single
+// point become waypoint items; larger ones become clusters centred on the
+// pixel bounds of their members. With keepBookmarks, bookmarks are never merged.
+func buildClusters(points []Waypoint, zoom, grid int, bookmarksOnly, keepBookmarks bool) []clusterItem {
+	type bucket struct {
+		minX, maxX float64
+		minY, maxY float64
+		cx, cy     int
+		wps        []Waypoint
+	}
+	buckets := make(map[[2]int]*bucket)
+	var order [][2]int
+	var items []clusterItem
+
+	for _, wp := range points {
+		if bookmarksOnly && !wp.Bookmark {
+			continue
+		}
+		x, y := projectPixels(wp.Lat, wp.Lon, zoom)
+		cx, cy := int(x/float64(grid)), int(y/float64(grid))
+		if keepBookmarks && wp.Bookmark {
+			items = append(items, clusterItem{lat: wp.Lat, lon: wp.Lon, count: 1, wp: wp, cx: cx, cy: cy})
+			continue
+		}
+		key := [2]int{cx, cy}
+		b := buckets[key]
+		if b == nil {
+			b = &bucket{minX: x, maxX: x, minY: y, maxY: y, cx: cx, cy: cy}
+			buckets[key] = b
+			order = append(order, key)
+		}
+		b.minX, b.maxX = math.Min(b.minX, x), math.Max(b.maxX, x)
+		b.minY, b.maxY = math.Min(b.minY, y), math.Max(b.maxY, y)
+		b.wps = append(b.wps, wp)
+	}
+
+	scale := 256.0 * math.Exp2(float64(zoom))
+	for _, key := range order {
+		b := buckets[key]
+		if len(b.wps) == 1 {
+			wp := b.wps[0]
+			items = append(items, clusterItem{lat: wp.Lat, lon: wp.Lon, count: 1, wp: wp, cx: b.cx, cy: b.cy})
+			continue
+		}
+		centerX := (b.minX + b.maxX) / 2
+		centerY := (b.minY + b.maxY) / 2
+		lon := (centerX/scale)*360.0 - 180.0
+		lat := math.Atan(math.Sinh(math.Pi*(1-2*centerY/scale))) * 180.0 / math.Pi
+		c := clusterItem{cluster: true, lat: lat, lon: lon, count: len(b.wps), cx: b.cx, cy: b.cy}
+		c.bounds = [4]float64{b.wps[0].Lat, b.wps[0].Lon, b.wps[0].Lat, b.wps[0].Lon}
+		for _, wp := range b.wps[1:] {
+			c.extend(wp.Lat, wp.Lon)
+		}
+		items = append(items, c)
+	}
+	return items
+}
+
+// clusterMembers returns the points that buildClusters (with the same filters)
+// places in grid cell (cx, cy) at zoom, i.e. the members of that cluster.
+func clusterMembers(points []Waypoint, zoom, grid, cx, cy int, bookmarksOnly, keepBookmarks bool) []Waypoint {
+	out := []Waypoint{}
+	for _, wp := range points {
+		if bookmarksOnly && !wp.Bookmark || keepBookmarks && wp.Bookmark {
+			continue // filtered out, or never merged into a bucket
+		}
+		x, y := projectPixels(wp.Lat, wp.Lon, zoom)
+		if int(x/float64(grid)) == cx && int(y/float64(grid)) == cy {
+			out = append(out, wp)
+		}
+	}
+	return out
+}
+
+// GET /api/clusters/expand?zoom=&grid=&bx=&by=  (or ?cell=z:cx:cy from the cluster tree)
+// Returns the waypoints in the cluster bucket at those grid coordinates, using
+// the same projection and filters (bookmarksOnly, keepBookmarks) as /api/clusters.
+// With ?tags=true each waypoint carries its tags.
+func handleGetClusterExpand(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var zoom, bx, by int
+	var err error
+	cell := q.Get("cell")
+	if cell == "" {
+		cell = q.Get("id") // older clients
+	}
+	if cell != "" {
+		if _, err = fmt.Sscanf(cell, "%d:%d:%d", &zoom, &bx, &by); err != nil {
+			http.Error(w, "invalid cell (want z:cx:cy)", http.StatusBadRequest)
+			return
+		}
+	} else {
+		zoom, err = strconv.Atoi(q.Get("zoom"))
+		if err == nil {
+			bx, err = strconv.Atoi(q.Get("bx"))
+		}
+		if err == nil {
+			by, err = strconv.Atoi(q.Get("by"))
+		}
+		if err != nil {
+			http.Error(w, "zoom, bx and by required (or cell)", http.StatusBadRequest)
+			return
+		}
+	}
+	if zoom < 0 {
+		zoom = 0
+	}
+	grid := defaultClusterGrid
+	if gStr := q.Get("grid"); gStr != "" {
+		if g, err := strconv.Atoi(gStr); err == nil && g >= 8 && g <= 512 {
+			grid = g
+		}
+	}
+	bookmarksOnly := isTruthy(q.Get("bookmarksOnly")) || isTruthy(q.Get("bookmarks"))
+	keepBookmarks := isTruthy(q.Get("keepBookmarks"))
+
+	var members []Waypoint
+	waypointStore.View(func(wps []Waypoint) {
+		members = clusterMembers(wps, zoom, grid, bx, by, bookmarksOnly, keepBookmarks)
+	})
+	logger.DebugCtx(r.Context(), "/api/clusters/expand zoom=%d grid=%d cell=%d,%d members=%d", zoom, grid, bx, by, len(members))
+
+	w.Header().Set("Content-Type", "application/json")
+	if !isTruthy(q.Get("tags")) || tagDB.Load() == nil {
+		_ = json.NewEncoder(w).Encode(members)
+		return
+	}
+	type taggedWaypoint struct {
+		waypointJSON
+		Tags []string `json:"tags,omitempty"`
+	}
+	out := make([]taggedWaypoint, len(members))
+	for i, wp := range members {
+		out[i].waypointJSON = wp.toJSON()
+		if wp.Name != "" {
+			if tags, err := getTagsFor(wp.Name, wp.Lat, wp.Lon); err == nil {
+				out[i].Tags = tags
+			}
+		}
+	}
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// maxClusterRadiusMeters bounds ?radiusMeters= on /api/clusters.
+const maxClusterRadiusMeters = 1000000.0
+
+// buildRadiusClusters groups points by great-circle distance instead of the
+// pixel grid: each point joins the first cluster whose seed (first member) is
+// within radiusM meters, otherwise it seeds a new one. Candidate seeds are
+// found through a lat/lon hash with cells radiusM tall. Clusters are centred
+// on the mean of their members.
+func buildRadiusClusters(points []Waypoint, radiusM float64, bookmarksOnly, keepBookmarks bool) []clusterItem {
+	type group struct {
+		seed     Waypoint
+		sumLat   float64
+		sumLon   float64
+		members  []Waypoint
+		outIndex int
+	}
+	cellDeg := radiusM / 111320.0
+	cellOf := func(v float64) int { return int(math.Floor(v / cellDeg)) }
+	cells := make(map[[2]int][]*group)
+	var groups []*group
+	var items []clusterItem
+
+	for _, wp := range points {
+		if bookmarksOnly && !wp.Bookmark {
+			continue
+		}
+		if keepBookmarks && wp.Bookmark {
+			items = append(items, clusterItem{lat: wp.Lat, lon: wp.Lon, count: 1, wp: wp})
+			continue
+		}
+		cy := cellOf(wp.Lat)
+		// A lon cell spans fewer meters away from the equator; widen the search accordingly.
+		span := int(math.Ceil(1 / math.Max(math.Cos(wp.Lat*math.Pi/180), 0.01)))
+		cx := cellOf(wp.Lon)
+		var g *group
+	search:
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -span; dx <= span; dx++ {
+				for _, cand := range cells[[2]int{cx + dx, cy + dy}] {
+					if distanceMeters(cand.seed.Lat, cand.seed.Lon, wp.Lat, wp.Lon) < radiusM {
+						g = cand
+						break search
+					}
+				}
+			}
+		}
+		if g == nil {
+			g = &group{seed: wp}
+			k := [2]int{cx, cy}
+			cells[k] = append(cells[k], g)
+			groups = append(groups, g)
+		}
+		g.sumLat += wp.Lat
+		g.sumLon += wp.Lon
+		g.members = append(g.members, wp)
+	}
+
+	for _, g := range groups {
+		if len(g.members) == 1 {
+			wp := g.members[0]
+			items = append(items, clusterItem{lat: wp.Lat, lon: wp.Lon, count: 1, wp: wp})
+			continue
+		}
+		n := float64(len(g.members))
+		c := clusterItem{cluster: true, lat: g.sumLat / n, lon: g.sumLon / n, count: len(g.members)}
+		c.bounds = [4]float64{g.seed.Lat, g.seed.Lon, g.seed.Lat, g.seed.Lon}
+		for _, wp := range g.members[1:] {
+			c.extend(wp.Lat, wp.Lon)
+		}
+		items = append(items, c)
+	}
+	return items
+}
+
+// clusterLevel holds the items of one zoom level indexed by grid cell.
+type clusterLevel struct {
+	items []clusterItem
+	cells map[[2]int][]int // cell -> indices into items
+}
+
+// clusterTree is the full hierarchy for one filter variant and store version.
+type clusterTree struct {
+	version uint64
+	grid    int
+	levels  [clusterTreeMaxZoom + 1]clusterLevel
+}
+
+// clusterVariant identifies a filter combination with its own tree.
+type clusterVariant struct {
+	bookmarksOnly, keepBookmarks bool
+}
+
+var (
+	clusterTreesMu sync.Mutex
+	clusterTrees   = make(map[clusterVariant]*clusterTree)
+)
+
+// getClusterTree returns the hierarchy for variant, rebuilding it when the
+// waypoint store changed since it was built.
+func getClusterTree(v clusterVariant) *clusterTree {
+	version := waypointsVersion.Load()
+	clusterTreesMu.Lock()
+	defer clusterTreesMu.Unlock()
+	if t := clusterTrees[v]; t != nil && t.version == version {
+		return t
+	}
+
+	points := waypointStore.Snapshot()
+
+	t := &clusterTree{version: version, grid: defaultClusterGrid}
+	for z := 0; z <= clusterTreeMaxZoom; z++ {
+		items := buildClusters(points, z, t.grid, v.bookmarksOnly, v.keepBookmarks)
+		cells := make(map[[2]int][]int, len(items))
+		for i, it := range items {
+			k := [2]int{it.cx, it.cy}
+			cells[k] = append(cells[k], i)
+		}
+		t.levels[z] = clusterLevel{items: items, cells: cells}
+	}
+	clusterTrees[v] = t
+	logger.Debug("cluster tree rebuilt variant=%+v version=%d points=%d", v, version, len(points))
+	return t
+}
+
+// maxClusterQueryCells caps the grid cells a bbox query enumerates; larger
+// viewports scan the level's items instead.
+const maxClusterQueryCells = 4096
+
+// query returns the items at zoom, limited to the cells overlapping bbox when
+// given (minLon, minLat, maxLon, maxLat). minLon > maxLon selects a box that
+// crosses the antimeridian.
+func (t *clusterTree) query(zoom int, bbox []float64) []clusterItem {
+	lvl := t.levels[zoom]
+	if bbox == nil {
+		return lvl.items
+	}
+	cellOf := func(lat, lon float64) (int, int) {
+		x, y := projectPixels(lat, lon, zoom)
+		return int(x / float64(t.grid)), int(y / float64(t.grid))
+	}
+	cx0, cy0 := cellOf(bbox[3], bbox[0]) // top-left
+	cx1, cy1 := cellOf(bbox[1], bbox[2]) // bottom-right
+	// Column ranges: one, or two when the box wraps around the antimeridian.
+	cols := [][2]int{{cx0, cx1}}
+	if bbox[0] > bbox[2] {
+		east, _ := cellOf(0, 180)
+		cols = [][2]int{{cx0, east}, {0, cx1}}
+	}
+
+	cells := 0
+	for _, c := range cols {
+		cells += (c[1] - c[0] + 1) * (cy1 - cy0 + 1)
+	}
+	var out []clusterItem
+	if cells > min(len(lvl.items), maxClusterQueryCells) {
+		// Viewport spans more cells than worth enumerating: scan items instead.
+		for _, it := range lvl.items {
+			if it.cy < cy0 || it.cy > cy1 {
+				continue
+			}
+			for _, c := range cols {
+				if it.cx >= c[0] && it.cx <= c[1] {
+					out = append(out, it)
+					break
+				}
+			}
+		}
+		return out
+	}
+	for _, c := range cols {
+		for cx := c[0]; cx <= c[1]; cx++ {
+			for cy := cy0; cy <= cy1; cy++ {
+				for _, i := range lvl.cells[[2]int{cx, cy}] {
+					out = append(out, lvl.items[i])
+				}
+			}
+		}
+	}
+	return out
+}
+
+// GET /api/waypoints/cluster-tree?minZoom=&maxZoom=&bookmarksOnly=&keepBookmarks=
+// Returns the precomputed hierarchy. Every item keeps its usual fields (waypoints
+// their "id"), plus its cell id ("cell", "z:cx:cy") and, above zoom 0, the cell
+// id containing it one level up ("parent").
+func handleGetClusterTree(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	minZoom, maxZoom := 0, clusterTreeMaxZoom
+	if v := q.Get("minZoom"); v != "" {
+		if z, err := strconv.Atoi(v); err == nil {
+			minZoom = z
+		}
+	}
+	if v := q.Get("maxZoom"); v != "" {
+		if z, err := strconv.Atoi(v); err == nil {
+			maxZoom = z
+		}
+	}
+	minZoom = max(0, min(minZoom, clusterTreeMaxZoom))
+	maxZoom = max(minZoom, min(maxZoom, clusterTreeMaxZoom))
+
+	variant := clusterVariant{
+		bookmarksOnly: isTruthy(q.Get("bookmarksOnly")) || isTruthy(q.Get("bookmarks")),
+		keepBookmarks: isTruthy(q.Get("keepBookmarks")),
+	}
+	t := getClusterTree(variant)
+
+	levels := make([]map[string]any, 0, maxZoom-minZoom+1)
+	for z := minZoom; z <= maxZoom; z++ {
+		items := t.levels[z].items
+		out := make([]map[string]any, 0, len(items))
+		for _, it := range items {
+			m := it.toJSON()
+			m["cell"] = fmt.Sprintf("%d:%d:%d", z, it.cx, it.cy)
+			if z > 0 {
+				// Parent: the cell containing this item's position one zoom level up.
+				px, py := projectPixels(it.lat, it.lon, z-1)
+				m["parent"] = fmt.Sprintf("%d:%d:%d", z-1, int(px/float64(t.grid)), int(py/float64(t.grid)))
+			}
+			out = append(out, m)
+		}
+		levels = append(levels, map[string]any{"zoom": z, "items": out})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"version": t.version,
+		"grid":    t.grid,
+		"levels":  levels,
+	})
+}
+
+// isTruthy accepts "1" or "true" (case-insensitive) query flags.
+func isTruthy(v string) bool {
+	return v == "1" || strings.EqualFold(v, "true")
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
 	"math"
+	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/rubiojr/whereami/pkg/logger"
 )
@@ -25,33 +31,180 @@ func waypointKey(w Waypoint) string {
 	return fmt.Sprintf("%s|%s|%s", w.Name, lat, lon)
 }
 
+// waypointID returns a short stable identifier for a waypoint: a hash of its
+// waypointKey, so it survives reloads and reimports but not renames or moves.
+func waypointID(w Waypoint) string {
+	sum := sha1.Sum([]byte(waypointKey(w)))
+	return hex.EncodeToString(sum[:8])
+}
+
 // roundTo rounds v to 'places' decimal digits using standard rounding.
 func roundTo(v float64, places int) float64 {
 	p := math.Pow10(places)
 	return math.Round(v*p) / p
 }
 
+// dedupeKeepUnnamed reports whether WHEREAMI_DEDUPE_KEEP_UNNAMED asks for
+// unnamed waypoints to be treated as always distinct by DedupeWaypoints (so
+// unnamed points from different files at the same coordinates all survive).
+// Off by default: unnamed points at the same coordinates collapse to one.
+func dedupeKeepUnnamed() bool {
+	v := strings.TrimSpace(os.Getenv("WHEREAMI_DEDUPE_KEEP_UNNAMED"))
+	if v == "" {
+		return false
+	}
+	keep, err := strconv.ParseBool(v)
+	if err != nil {
+		logger.Warn("ignoring invalid WHEREAMI_DEDUPE_KEEP_UNNAMED=%q", v)
+	}
+	return keep
+}
+
 // DedupeWaypoints returns a new slice with duplicate waypoints (same name +
 // coordinates within waypointEpsilon) removed, preserving the first occurrence
-// order. The input slice is not modified.
+// order. On a collision a Bookmark entry always wins: it replaces an earlier
+// plain waypoint in place. With dedupeKeepUnnamed, waypoints with an empty
+// (or blank) name are never considered duplicates. The input slice is not
+// modified.
 func DedupeWaypoints(in []Waypoint) []Waypoint {
 	if len(in) <= 1 {
 		// Nothing to dedupe.
 		return append([]Waypoint(nil), in...)
 	}
-	seen := make(map[string]struct{}, len(in))
+	keepUnnamed := dedupeKeepUnnamed()
+	seen := make(map[string]int, len(in)) // key -> index in out
 	out := make([]Waypoint, 0, len(in))
 	for _, w := range in {
+		if keepUnnamed && strings.TrimSpace(w.Name) == "" {
+			out = append(out, w)
+			continue
+		}
 		k := waypointKey(w)
-		if _, ok := seen[k]; ok {
+		if i, ok := seen[k]; ok {
+			if w.Bookmark && !out[i].Bookmark {
+				out[i] = w
+			}
 			continue
 		}
-		seen[k] = struct{}{}
+		seen[k] = len(out)
 		out = append(out, w)
 	}
 	return out
 }
 
+// dedupeRadiusMeters returns the proximity radius used by DedupeNearBookmarks
+// (WHEREAMI_DEDUPE_RADIUS, meters; 0 = disabled, the default).
+func dedupeRadiusMeters() float64 {
+	if v := os.Getenv("WHEREAMI_DEDUPE_RADIUS"); v != "" {
+		if m, err := strconv.ParseFloat(v, 64); err == nil && m >= 0 {
+			return m
+		}
+		logger.Warn("ignoring invalid WHEREAMI_DEDUPE_RADIUS=%q", v)
+	}
+	return 0
+}
+
+// DedupeNearBookmarks drops plain (non-bookmark) waypoints that share a
+// bookmark's name and lie within radiusM meters of it, so an imported
+// near-duplicate never shadows a saved bookmark. Order is preserved; a
+// radius <= 0 returns the input unchanged.
+func DedupeNearBookmarks(in []Waypoint, radiusM float64) []Waypoint {
+	if radiusM <= 0 {
+		return in
+	}
+	byName := make(map[string][]Waypoint)
+	for _, w := range in {
+		if w.Bookmark {
+			byName[w.Name] = append(byName[w.Name], w)
+		}
+	}
+	if len(byName) == 0 {
+		return in
+	}
+	out := make([]Waypoint, 0, len(in))
+	for _, w := range in {
+		if !w.Bookmark && nearAny(w, byName[w.Name], radiusM) {
+			continue
+		}
+		out = append(out, w)
+	}
+	return out
+}
+
+// nearAny reports whether w lies within radiusM meters of any of candidates.
+func nearAny(w Waypoint, candidates []Waypoint, radiusM float64) bool {
+	for _, c := range candidates {
+		if distanceMeters(w.Lat, w.Lon, c.Lat, c.Lon) <= radiusM {
+			return true
+		}
+	}
+	return false
+}
+
+// nearPair is two waypoints (indices into the input) within a radius.
+type nearPair struct {
+	A, B      int
+	DistanceM float64
+}
+
+// nearGroups groups wps into clusters of waypoints linked by chains of pairs
+// at most radiusM meters apart (any name). It returns each group's member
+// indices (ascending) with the linking pairs; waypoints near nothing are left
+// out. Candidates are found with a latitude-sorted sweep.
+func nearGroups(wps []Waypoint, radiusM float64) (groups [][]int, pairs [][]nearPair) {
+	order := make([]int, len(wps))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return wps[order[a]].Lat < wps[order[b]].Lat })
+
+	parent := make([]int, len(wps))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	maxDLat := radiusM / earthRadiusMeters * 180 / math.Pi
+	var all []nearPair
+	for oi, i := range order {
+		for _, j := range order[oi+1:] {
+			if wps[j].Lat-wps[i].Lat > maxDLat {
+				break
+			}
+			if d := distanceMeters(wps[i].Lat, wps[i].Lon, wps[j].Lat, wps[j].Lon); d <= radiusM {
+				a, b := min(i, j), max(i, j)
+				all = append(all, nearPair{A: a, B: b, DistanceM: d})
+				parent[find(a)] = find(b)
+			}
+		}
+	}
+
+	byRoot := map[int]int{} // root -> group index
+	for _, p := range all {
+		r := find(p.A)
+		g, ok := byRoot[r]
+		if !ok {
+			g = len(groups)
+			byRoot[r] = g
+			groups = append(groups, nil)
+			pairs = append(pairs, nil)
+		}
+		pairs[g] = append(pairs[g], p)
+	}
+	for i := range wps {
+		if g, ok := byRoot[find(i)]; ok {
+			groups[g] = append(groups[g], i)
+		}
+	}
+	return groups, pairs
+}
+
 // MergeAndDedupe merges multiple waypoint slices and returns a deduplicated
 // result. Later duplicates are discarded (first occurrence wins).
 func MergeAndDedupe(slices ...[]Waypoint) []Waypoint {
@@ -71,41 +224,41 @@ func MergeAndDedupe(slices ...[]Waypoint) []Waypoint {
 // deduplication logic. This centralizes the logic currently mirrored in main.go
 // and intended to be invoked from the /api/import handler.
 //
-// NOTE: This function does not mutate global state directly; callers should
-// acquire allWaypointsMu and assign the returned slice.
+// NOTE: This function does not mutate global state directly; callers install
+// the result with waypointStore.Replace.
 //
 // Usage pattern:
 //
-//	rebuilt := RebuildAllWaypoints(bookmarksPath, dataDir)
-//	allWaypointsMu.Lock()
-//	allWaypoints = rebuilt
-//	allWaypointsMu.Unlock()
+//	waypointStore.Replace(RebuildAllWaypoints(bookmarksPath, dataDir))
 func RebuildAllWaypoints(bookmarksPath, dataDir string) []Waypoint {
 	var bookmarks []Waypoint
+	var parseErrs []fileParseError
 	if fileExists(bookmarksPath) {
 		if bms, err := parseGPXFile(bookmarksPath); err == nil {
 			for i := range bms {
 				bms[i].Bookmark = true
 			}
 			bookmarks = bms
+		} else {
+			logger.Error("Skipping %s: %v", bookmarksPath, err)
+			parseErrs = append(parseErrs, fileParseError{Path: bookmarksPath, Error: err.Error(), At: time.Now().UTC()})
 		}
 	}
 
-	others, err := collectGPXWaypoints(dataDir, true, bookmarksPath)
+	others, collectErrs, err := collectGPXWaypoints(dataDir, true, bookmarksPath)
 	if err != nil {
 		// Non-fatal: log to stderr; keep what we have.
 		logger.Error("collectGPXWaypoints error: %v", err)
 	}
+	// Surfaced via GET /api/imports/errors; each rebuild starts a fresh list.
+	setParseErrors(append(parseErrs, collectErrs...))
 
-	return MergeAndDedupe(bookmarks, others)
+	return DedupeNearBookmarks(MergeAndDedupe(bookmarks, others), dedupeRadiusMeters())
 }
 
 // And in main.go (startup) similarly switch to:
 //
-//   initial := RebuildAllWaypoints(bookmarksPath, dataDir)
-//   allWaypointsMu.Lock()
-//   allWaypoints = initial
-//   allWaypointsMu.Unlock()
+//   waypointStore.Replace(RebuildAllWaypoints(bookmarksPath, dataDir))
 //
 // This consolidates deduplication logic in one place and ensures consistent behavior
 // between startup and on-demand imports.
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Elevation profile along an ordered path.
+//
+// NOTE: There is no elevation lookup service or cache yet, so the profile is
+// built only from the elevations supplied with the points (or the track's
+// <ele> values when a trackId is given). Samples without one are returned with
+// a null elevation and skipped when summing ascent/descent. Once an elevation
+// fetch exists, missing values should be filled before computing the totals.
+
+// maxProfilePoints caps POST /api/elevation/profile requests.
+const maxProfilePoints = 10000
+
+// profilePoint is one input point; Ele is nil when unknown.
+type profilePoint struct {
+	Lat float64  `json:"lat"`
+	Lon float64  `json:"lon"`
+	Ele *float64 `json:"ele"`
+}
+
+// profileSample is one output sample of the profile.
+type profileSample struct {
+	DistanceM float64  `json:"distance_m"` // cumulative from the first point
+	Ele       *float64 `json:"ele"`
+	Lat       float64  `json:"lat"`
+	Lon       float64  `json:"lon"`
+}
+
+// elevationProfile computes cumulative distance samples and the total ascent
+// and descent between consecutive known elevations.
+func elevationProfile(pts []profilePoint) (samples []profileSample, totalM, ascentM, descentM float64, missing int) {
+	samples = make([]profileSample, 0, len(pts))
+	var lastEle *float64
+	for i, p := range pts {
+		if i > 0 {
+			totalM += distanceMeters(pts[i-1].Lat, pts[i-1].Lon, p.Lat, p.Lon)
+		}
+		samples = append(samples, profileSample{DistanceM: totalM, Ele: p.Ele, Lat: p.Lat, Lon: p.Lon})
+		if p.Ele == nil {
+			missing++
+			continue
+		}
+		if lastEle != nil {
+			if d := *p.Ele - *lastEle; d > 0 {
+				ascentM += d
+			} else {
+				descentM -= d
+			}
+		}
+		lastEle = p.Ele
+	}
+	return samples, totalM, ascentM, descentM, missing
+}
+
+// trackProfilePoints flattens a track's segments into profile points. GPX
+// points without <ele> decode as 0 and are treated as unknown.
+func trackProfilePoints(t Track) []profilePoint {
+	var pts []profilePoint
+	for _, seg := range t.Segments {
+		for _, p := range seg.Points {
+			pp := profilePoint{Lat: p.Lat, Lon: p.Lon}
+			if p.Ele != 0 {
+				ele := p.Ele
+				pp.Ele = &ele
+			}
+			pts = append(pts, pp)
+		}
+	}
+	return pts
+}
+
+// POST /api/elevation/profile  JSON: { "points": [ { lat, lon, ele? }, ... ] } or { "trackId": id }
+// Returns { samples: [ { distance_m, ele, lat, lon } ], distance_m, ascent_m, descent_m, missing_elevations }.
+func handlePostElevationProfile(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Points  []profilePoint `json:"points"`
+		TrackID string         `json:"trackId"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if req.TrackID != "" {
+		t, ok := findTrack(req.TrackID)
+		if !ok {
+			http.Error(w, "track not found", http.StatusNotFound)
+			return
+		}
+		req.Points = trackProfilePoints(t)
+	}
+	if len(req.Points) < 2 {
+		http.Error(w, "at least 2 points required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Points) > maxProfilePoints {
+		http.Error(w, fmt.Sprintf("too many points (max %d)", maxProfilePoints), http.StatusBadRequest)
+		return
+	}
+	for _, p := range req.Points {
+		if p.Lat < -90 || p.Lat > 90 || p.Lon < -180 || p.Lon > 180 {
+			http.Error(w, "invalid lat/lon", http.StatusBadRequest)
+			return
+		}
+	}
+
+	samples, total, ascent, descent, missing := elevationProfile(req.Points)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"samples":            samples,
+		"distance_m":         total,
+		"ascent_m":           ascent,
+		"descent_m":          descent,
+		"missing_elevations": missing,
+	})
+}
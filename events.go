@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rubiojr/whereami/pkg/logger"
+)
+
+// Change notifications (GET /api/events, Server-Sent Events).
+//
+// Mutating handlers call publishEvent after updating the waypoint store or the
+// tag DB; every connected client receives the event with enough payload
+// (name, lat, lon, ...) to apply it without refetching. Delivery is
+// best-effort: a client whose buffer is full misses the event and should
+// refetch when it sees a gap in the event ids.
+
+// Change event types.
+const (
+	eventBookmarkAdded   = "bookmark_added"
+	eventBookmarkDeleted = "bookmark_deleted"
+	eventBookmarkRenamed = "bookmark_renamed"
+	eventBookmarkUpdated = "bookmark_updated"
+	eventTagsChanged     = "tags_changed"
+	eventImportCompleted = "import_completed"
+)
+
+const (
+	eventBufferSize    = 64
+	eventKeepaliveTick = 25 * time.Second
+)
+
+type changeEvent struct {
+	ID   uint64
+	Type string
+	Data map[string]any
+}
+
+// eventHub fans events out to the subscribed SSE clients.
+type eventHub struct {
+	mu     sync.Mutex
+	nextID uint64
+	subs   map[chan changeEvent]struct{}
+}
+
+var events = &eventHub{subs: map[chan changeEvent]struct{}{}}
+
+// subscribe registers a client channel; cancel unregisters it.
+func (h *eventHub) subscribe() (ch chan changeEvent, cancel func()) {
+	ch = make(chan changeEvent, eventBufferSize)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs, ch)
+		h.mu.Unlock()
+	}
+}
+
+// publish sends an event to every subscriber, dropping it for clients that
+// are not keeping up.
+func (h *eventHub) publish(typ string, data map[string]any) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nextID++
+	ev := changeEvent{ID: h.nextID, Type: typ, Data: data}
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+			logger.Debug("events: dropping %s #%d for a slow client", typ, ev.ID)
+		}
+	}
+}
+
+// publishEvent broadcasts a change event to the GET /api/events clients.
+func publishEvent(typ string, data map[string]any) {
+	events.publish(typ, data)
+}
+
+// waypointEventData is the common { id, name, lat, lon } event payload.
+func waypointEventData(name string, lat, lon float64) map[string]any {
+	return map[string]any{
+		"id":   waypointID(Waypoint{Name: name, Lat: lat, Lon: lon}),
+		"name": name,
+		"lat":  lat,
+		"lon":  lon,
+	}
+}
+
+// publishTagsChanged broadcasts a waypoint's new tag set.
+func publishTagsChanged(name string, lat, lon float64, tags []string) {
+	data := waypointEventData(name, lat, lon)
+	if tags == nil {
+		tags = []string{}
+	}
+	data["tags"] = tags
+	publishEvent(eventTagsChanged, data)
+}
+
+// GET /api/events
+// Server-Sent Events stream of change notifications. Each message has an
+// incrementing id, the event type (bookmark_added, bookmark_deleted,
+// bookmark_renamed, bookmark_updated, tags_changed, import_completed) and a
+// JSON data payload. Comment lines keep idle connections open.
+func handleGetEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	ch, cancel := events.subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprint(w, ": connected\n\n"); err != nil {
+		return
+	}
+	if err := rc.Flush(); err != nil {
+		logger.ErrorCtx(r.Context(), "GET /api/events: streaming unsupported: %v", err)
+		return
+	}
+	logger.DebugCtx(r.Context(), "GET /api/events client connected")
+
+	tick := time.NewTicker(eventKeepaliveTick)
+	defer tick.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			logger.DebugCtx(r.Context(), "GET /api/events client disconnected")
+			return
+		case <-tick.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case ev := <-ch:
+			data, err := json.Marshal(ev.Data)
+			if err != nil {
+				logger.ErrorCtx(r.Context(), "GET /api/events: encoding %s failed: %v", ev.Type, err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rubiojr/whereami/pkg/logger"
+)
+
+// Local fuzzy waypoint search (GET /api/waypoints/search).
+//
+// Scores are in [0, 1]: exact name 1, prefix 0.9, substring 0.8, in-order
+// subsequence 0.5-0.7 (denser matches score higher), otherwise the
+// Levenshtein similarity of the query against the closest same-length window
+// of the name. Description matches (desc=true) count at descDiscount of their
+// score; only the first maxFuzzyDescRunes runes of a description go through
+// the subsequence and typo passes. No network requests are made.
+
+const (
+	defaultSearchLimit    = 20
+	maxSearchLimit        = 200
+	defaultSearchMinScore = 0.4
+	descDiscount          = 0.8
+	maxFuzzyDescRunes     = 200
+)
+
+// levenshtein returns the edit distance between a and b (runes).
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// subsequenceSpan returns the length of the shortest-from-first-match span of
+// text containing q's runes in order, or -1 when q is not a subsequence.
+func subsequenceSpan(q, text []rune) int {
+	start, qi := -1, 0
+	for i, r := range text {
+		if qi < len(q) && r == q[qi] {
+			if qi == 0 {
+				start = i
+			}
+			qi++
+			if qi == len(q) {
+				return i - start + 1
+			}
+		}
+	}
+	return -1
+}
+
+// fuzzyScore scores how well q (lower-cased) matches text.
+func fuzzyScore(q, text string) float64 {
+	t := strings.ToLower(strings.TrimSpace(text))
+	if q == "" || t == "" {
+		return 0
+	}
+	switch {
+	case t == q:
+		return 1
+	case strings.HasPrefix(t, q):
+		return 0.9
+	case strings.Contains(t, q):
+		return 0.8
+	}
+	qr, tr := []rune(q), []rune(t)
+	best := 0.0
+	if span := subsequenceSpan(qr, tr); span > 0 {
+		best = 0.5 + 0.2*float64(len(qr))/float64(span)
+	}
+	// Typos: compare against every window of the query's length (or the whole
+	// text when it is shorter). Window hits score at most 0.75, so they are
+	// skipped when the subsequence pass already did as well.
+	n := len(qr)
+	if len(tr) <= n {
+		best = max(best, 1-float64(levenshtein(qr, tr))/float64(n))
+	} else if best < 0.75 {
+		for i := 0; i+n <= len(tr); i++ {
+			sim := 1 - float64(levenshtein(qr, tr[i:i+n]))/float64(n)
+			best = max(best, sim*0.75) // below substring hits
+		}
+	}
+	return max(best, 0)
+}
+
+// descScore is fuzzyScore for descriptions. Substring hits are found in the
+// whole text; the costlier passes only see its first maxFuzzyDescRunes runes.
+func descScore(q, desc string) float64 {
+	t := strings.ToLower(strings.TrimSpace(desc))
+	if strings.Contains(t, q) {
+		return fuzzyScore(q, t)
+	}
+	if utf8.RuneCountInString(t) > maxFuzzyDescRunes {
+		t = string([]rune(t)[:maxFuzzyDescRunes])
+	}
+	return fuzzyScore(q, t)
+}
+
+// GET /api/waypoints/search?q=&limit=&minScore=&desc=true&tags=true
+// Local-only fuzzy search over waypoint names (and descriptions with
+// desc=true). Returns [ { id, name, lat, lon, bookmark, desc?, score, tags? } ]
+// sorted by score (then name); limit defaults to 20 (max 200) and minScore to 0.4.
+func handleGetWaypointSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := strings.ToLower(strings.TrimSpace(query.Get("q")))
+	if q == "" {
+		http.Error(w, "q required", http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(q) > 100 {
+		http.Error(w, "q too long (max 100 characters)", http.StatusBadRequest)
+		return
+	}
+	limit := defaultSearchLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxSearchLimit)
+	}
+	minScore := defaultSearchMinScore
+	if v := query.Get("minScore"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 1 {
+			http.Error(w, "minScore must be between 0 and 1", http.StatusBadRequest)
+			return
+		}
+		minScore = f
+	}
+	withDesc := isTruthy(query.Get("desc"))
+
+	type scored struct {
+		wp    Waypoint
+		score float64
+	}
+	// Score a snapshot so the store lock is not held while scoring.
+	var hits []scored
+	for _, wp := range waypointStore.Snapshot() {
+		s := fuzzyScore(q, wp.Name)
+		// A description hit scores at most descDiscount; skip it when it cannot win.
+		if withDesc && wp.Desc != "" && s < descDiscount {
+			s = max(s, descScore(q, wp.Desc)*descDiscount)
+		}
+		if s > 0 && s >= minScore {
+			hits = append(hits, scored{wp, s})
+		}
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+		return strings.ToLower(hits[i].wp.Name) < strings.ToLower(hits[j].wp.Name)
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	withTags := isTruthy(query.Get("tags"))
+	out := make([]map[string]any, 0, len(hits))
+	for _, h := range hits {
+		obj := map[string]any{
+			"id":       waypointID(h.wp),
+			"name":     h.wp.Name,
+			"lat":      h.wp.Lat,
+			"lon":      h.wp.Lon,
+			"bookmark": h.wp.Bookmark,
+			"score":    roundTo(h.score, 3),
+		}
+		if h.wp.Desc != "" {
+			obj["desc"] = h.wp.Desc
+		}
+		if withTags && h.wp.Name != "" {
+			if tags, err := getTagsFor(h.wp.Name, h.wp.Lat, h.wp.Lon); err == nil && len(tags) > 0 {
+				obj["tags"] = tags
+			}
+		}
+		out = append(out, obj)
+	}
+	logger.DebugCtx(r.Context(), "GET /api/waypoints/search q=%q minScore=%.2f matches=%d", q, minScore, len(out))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
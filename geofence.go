@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/rubiojr/whereami/pkg/logger"
+)
+
+// Geofence evaluation against the current GeoClue fix.
+//
+// Clients POST a list of circular fences; the response reports which fences
+// contain the current location. Enter/exit state is remembered per fence name
+// across calls so repeated polling yields "enter" / "exit" transitions.
+
+// geofence is a named circular region.
+type geofence struct {
+	Name    string  `json:"name"`
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+	RadiusM float64 `json:"radius_m"`
+}
+
+// Last known inside/outside state per fence name (guarded by geofenceMu).
+var (
+	geofenceMu    sync.Mutex
+	geofenceState = make(map[string]bool)
+)
+
+// POST /api/geofence  JSON: { "fences": [ { name, lat, lon, radius_m }, ... ] }
+// (a bare array of fences is accepted too)
+func handlePostGeofence(w http.ResponseWriter, r *http.Request) {
+	ensureLocationTracking()
+
+	var raw json.RawMessage
+	if !decodeJSONBody(w, r, &raw) {
+		return
+	}
+	var fences []geofence
+	if trimmed := strings.TrimSpace(string(raw)); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(raw, &fences); err != nil {
+			http.Error(w, "invalid fences: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		var req struct {
+			Fences []geofence `json:"fences"`
+		}
+		if err := json.Unmarshal(raw, &req); err != nil {
+			http.Error(w, "invalid fences: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		fences = req.Fences
+	}
+	if len(fences) == 0 {
+		http.Error(w, "fences required", http.StatusBadRequest)
+		return
+	}
+	for _, f := range fences {
+		if strings.TrimSpace(f.Name) == "" || f.RadiusM <= 0 {
+			http.Error(w, "each fence needs a name and a positive radius_m", http.StatusBadRequest)
+			return
+		}
+	}
+
+	fix, ok := GetCurrentLocation()
+	if !ok {
+		http.Error(w, "location unknown", http.StatusServiceUnavailable)
+		return
+	}
+
+	inside := []string{}
+	results := make([]map[string]any, 0, len(fences))
+	geofenceMu.Lock()
+	for _, f := range fences {
+		d := distanceMeters(fix.Latitude, fix.Longitude, f.Lat, f.Lon)
+		in := d <= f.RadiusM
+		entry := map[string]any{
+			"name":       f.Name,
+			"inside":     in,
+			"distance_m": d,
+		}
+		if prev, known := geofenceState[f.Name]; known && prev != in {
+			if in {
+				entry["transition"] = "enter"
+			} else {
+				entry["transition"] = "exit"
+			}
+			logger.DebugCtx(r.Context(), "geofence %q transition=%v distance=%.1fm", f.Name, entry["transition"], d)
+		}
+		geofenceState[f.Name] = in
+		if in {
+			inside = append(inside, f.Name)
+		}
+		results = append(results, entry)
+	}
+	geofenceMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"location": fix,
+		"inside":   inside,
+		"fences":   results,
+	})
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rubiojr/whereami/pkg/logger"
+)
+
+// Coarse IP-geolocation fallback for machines where GeoClue never produces a
+// fix (no GPS / Wi-Fi positioning). Opt-in via WHEREAMI_IP_LOCATION=1: when
+// no GeoClue fix has arrived WHEREAMI_IP_LOCATION_AFTER (default 30s) after
+// tracking starts, WHEREAMI_IP_LOCATION_URL is queried and its position is
+// stored with source "ip" and a city-level accuracy radius. Failed lookups
+// are retried every ipLocationRetry until GeoClue delivers. The first GeoClue
+// fix replaces the IP one (it skips the speed filter and smoothing window);
+// IP fixes are never written to the track log.
+
+const (
+	defaultIPLocationURL   = "https://ipapi.co/json/"
+	defaultIPLocationAfter = 30 * time.Second
+	ipLocationRetry        = 5 * time.Minute
+
+	// ipLocationAccuracyM is the accuracy radius reported for IP fixes; IP
+	// geolocation is at best city-level.
+	ipLocationAccuracyM = 25000.0
+
+	// locationSourceIP marks fixes from the IP fallback (LocationFix.Source).
+	locationSourceIP = "ip"
+)
+
+var ipLocationHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// ipLocationEnabled reports whether WHEREAMI_IP_LOCATION is set to a true value.
+func ipLocationEnabled() bool {
+	v := os.Getenv("WHEREAMI_IP_LOCATION")
+	if v == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		logger.Warn("ignoring invalid WHEREAMI_IP_LOCATION=%q", v)
+		return false
+	}
+	return b
+}
+
+// ipLocationAfter returns how long to wait for GeoClue before falling back
+// (WHEREAMI_IP_LOCATION_AFTER, a Go duration; default 30s).
+func ipLocationAfter() time.Duration {
+	v := os.Getenv("WHEREAMI_IP_LOCATION_AFTER")
+	if v == "" {
+		return defaultIPLocationAfter
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		logger.Warn("ignoring invalid WHEREAMI_IP_LOCATION_AFTER=%q", v)
+		return defaultIPLocationAfter
+	}
+	return d
+}
+
+// ipLocationURL returns the IP-geolocation service URL (WHEREAMI_IP_LOCATION_URL).
+func ipLocationURL() string {
+	if v := strings.TrimSpace(os.Getenv("WHEREAMI_IP_LOCATION_URL")); v != "" {
+		return v
+	}
+	return defaultIPLocationURL
+}
+
+// runIPLocationFallback waits for the configured delay and, while GeoClue
+// has not produced a fix, looks up the position from the IP service.
+func runIPLocationFallback(ctx context.Context) {
+	delay := ipLocationAfter()
+	url := ipLocationURL()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if _, ok := GetRawLocation(); ok {
+			return
+		}
+		fix, err := fetchIPLocation(ctx, url)
+		if err != nil {
+			logger.Warn("location: IP fallback lookup failed (retrying in %s): %v", ipLocationRetry, err)
+			delay = ipLocationRetry
+			continue
+		}
+		if setIPLocationFix(fix) {
+			logger.Info("location: no GeoClue fix after %s, using IP location lat=%.4f lon=%.4f", ipLocationAfter(), fix.Latitude, fix.Longitude)
+		}
+		return
+	}
+}
+
+// fetchIPLocation queries url and parses the position. It understands the
+// common response shapes: { latitude, longitude } (ipapi.co, freeipapi),
+// { lat, lon } (ip-api.com) and { loc: "lat,lon" } (ipinfo.io).
+func fetchIPLocation(ctx context.Context, url string) (LocationFix, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return LocationFix{}, err
+	}
+	req.Header.Set("User-Agent", "WhereAmI/1.0")
+	req.Header.Set("Accept", "application/json")
+	resp, err := ipLocationHTTPClient.Do(req)
+	if err != nil {
+		return LocationFix{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return LocationFix{}, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	var body struct {
+		Latitude  *float64 `json:"latitude"`
+		Longitude *float64 `json:"longitude"`
+		Lat       *float64 `json:"lat"`
+		Lon       *float64 `json:"lon"`
+		Loc       string   `json:"loc"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return LocationFix{}, fmt.Errorf("decode: %w", err)
+	}
+	var lat, lon float64
+	switch {
+	case body.Latitude != nil && body.Longitude != nil:
+		lat, lon = *body.Latitude, *body.Longitude
+	case body.Lat != nil && body.Lon != nil:
+		lat, lon = *body.Lat, *body.Lon
+	case body.Loc != "":
+		fix, err := parseMockLocation(body.Loc)
+		if err != nil {
+			return LocationFix{}, fmt.Errorf("invalid loc %q: %w", body.Loc, err)
+		}
+		lat, lon = fix.Latitude, fix.Longitude
+	default:
+		return LocationFix{}, errors.New("no coordinates in response")
+	}
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 || (lat == 0 && lon == 0) {
+		return LocationFix{}, fmt.Errorf("invalid coordinates %f,%f", lat, lon)
+	}
+	return LocationFix{Latitude: lat, Longitude: lon, Accuracy: ipLocationAccuracyM, Source: locationSourceIP}, nil
+}
+
+// setIPLocationFix stores fix as both the raw and current location unless a
+// GeoClue fix arrived in the meantime. It bypasses the accuracy filter and
+// the smoothing window.
+func setIPLocationFix(fix LocationFix) bool {
+	fix.Timestamp = time.Now().UTC()
+	fix.Source = locationSourceIP
+	locationMu.Lock()
+	defer locationMu.Unlock()
+	if locationValid {
+		return false
+	}
+	rawLocation = fix
+	currentLocation = fix
+	locationValid = true
+	return true
+}
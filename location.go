@@ -6,13 +6,17 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/godbus/dbus/v5"
+	"github.com/rubiojr/whereami/pkg/logger"
 )
 
 /*
@@ -35,9 +39,31 @@ Data exposed:
   currentLocation   (guarded by locationMu)
   locationValid     (true once we have at least one fix)
 
+Mock mode:
+  - WHEREAMI_MOCK_LOCATION="lat,lon[,accuracy_m]" serves that fixed fix and
+    disables GeoClue entirely (no D-Bus connection is made). Useful for CI,
+    headless servers and demos; POST /api/location moves the mock position
+    at runtime and is rejected when mock mode is off.
+
+Noise filtering:
+  - WHEREAMI_LOCATION_MAX_ACCURACY_M drops fixes with a larger accuracy
+    radius; WHEREAMI_LOCATION_MAX_SPEED_MPS (default 300, 0 disables) drops
+    fixes implying an impossible jump from the previous one. Rejections are
+    logged at debug level.
+
+Track log:
+  - WHEREAMI_TRACK_LOG=true appends each accepted GeoClue fix to
+    track.sqlite (see tracklog.go); GET /api/track reads it back.
+
+IP fallback:
+  - WHEREAMI_IP_LOCATION=1 queries WHEREAMI_IP_LOCATION_URL when GeoClue
+    has produced no fix after WHEREAMI_IP_LOCATION_AFTER (default 30s) and
+    serves that coarse position with source "ip" until a GeoClue fix
+    replaces it (see iplocation.go).
+
 Failure strategy:
   - If GeoClue is unavailable or permission denied, we log and
-    continue (API will return 204 No Content).
+    continue (API will return 204 No Content, or the IP fallback fix).
   - The goroutine retries a few times initially, then backs off.
 
 Security / Permissions:
@@ -67,6 +93,7 @@ type LocationFix struct {
 	Accuracy  float64   `json:"accuracy_m,omitempty"`
 	Altitude  float64   `json:"altitude_m,omitempty"`
 	Timestamp time.Time `json:"timestamp"`
+	Source    string    `json:"source,omitempty"` // "ip" for the IP fallback; empty for GeoClue / mock fixes
 }
 
 // Shared state.
@@ -76,10 +103,88 @@ var (
 	locationValid   bool
 
 	locationCancel context.CancelFunc
+
+	// Fixes with a worse (larger) accuracy radius are ignored; 0 accepts all.
+	// Configured via WHEREAMI_LOCATION_MAX_ACCURACY_M.
+	locationMaxAccuracyM float64
+
+	// Fixes implying a faster move than this from the previous accepted fix
+	// are ignored (teleport filter); 0 disables. WHEREAMI_LOCATION_MAX_SPEED_MPS.
+	locationMaxSpeedMPS = defaultLocationMaxSpeedMPS
+
+	// locationMock is set when WHEREAMI_MOCK_LOCATION replaces GeoClue.
+	locationMock bool
+
+	// Optional moving average over the last locationSmoothN accepted fixes
+	// (WHEREAMI_LOCATION_SMOOTH_N; <= 1 disables). rawLocation keeps the latest
+	// unsmoothed fix; both are guarded by locationMu.
+	locationSmoothN int
+	rawLocation     LocationFix
+	recentFixes     []LocationFix
+
+	// GeoClue client settings (WHEREAMI_LOCATION_ACCURACY, _DISTANCE_M, _TIME_S).
+	locationAccuracy  = defaultLocationAccuracy
+	locationDistanceM = defaultLocationDistanceM
+	locationTimeS     = defaultLocationTimeS
 )
 
+// GeoClue client defaults.
+const (
+	defaultLocationAccuracy  = uint32(5)  // GeoClue accuracy level (0-8)
+	defaultLocationDistanceM = uint32(25) // meters between updates
+	defaultLocationTimeS     = uint32(5)  // seconds between updates
+	maxLocationAccuracy      = 8          // GCLUE_ACCURACY_LEVEL_EXACT
+
+	// defaultLocationMaxSpeedMPS is roughly airliner cruise speed; anything
+	// faster between two fixes is treated as a GeoClue glitch.
+	defaultLocationMaxSpeedMPS = 300.0
+)
+
+// envUint32 reads a non-negative integer env var no larger than maxVal,
+// returning def (and logging) when it is unset or invalid.
+func envUint32(name string, def uint32, maxVal uint64) uint32 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.ParseUint(v, 10, 32)
+	if err != nil || n > maxVal {
+		log.Printf("location: ignoring invalid %s=%q (using %d)", name, v, def)
+		return def
+	}
+	return uint32(n)
+}
+
 // InitLocationTracking ensures a .desktop file is present and starts GeoClue client tracking.
 func InitLocationTracking(desktopID string) error {
+	if v := os.Getenv("WHEREAMI_LOCATION_MAX_ACCURACY_M"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 {
+			locationMaxAccuracyM = f
+		} else {
+			log.Printf("location: ignoring invalid WHEREAMI_LOCATION_MAX_ACCURACY_M=%q", v)
+		}
+	}
+	if v := os.Getenv("WHEREAMI_LOCATION_MAX_SPEED_MPS"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 {
+			locationMaxSpeedMPS = f
+		} else {
+			log.Printf("location: ignoring invalid WHEREAMI_LOCATION_MAX_SPEED_MPS=%q", v)
+		}
+	}
+	locationAccuracy = envUint32("WHEREAMI_LOCATION_ACCURACY", defaultLocationAccuracy, maxLocationAccuracy)
+	locationDistanceM = envUint32("WHEREAMI_LOCATION_DISTANCE_M", defaultLocationDistanceM, math.MaxUint32)
+	locationTimeS = envUint32("WHEREAMI_LOCATION_TIME_S", defaultLocationTimeS, math.MaxUint32)
+	locationSmoothN = int(envUint32("WHEREAMI_LOCATION_SMOOTH_N", 0, 100))
+	if v := os.Getenv("WHEREAMI_MOCK_LOCATION"); v != "" {
+		fix, err := parseMockLocation(v)
+		if err == nil {
+			locationMock = true
+			setLocationFix(fix)
+			log.Printf("location: mock mode lat=%.6f lon=%.6f (GeoClue disabled)", fix.Latitude, fix.Longitude)
+			return nil
+		}
+		log.Printf("location: ignoring invalid WHEREAMI_MOCK_LOCATION=%q: %v", v, err)
+	}
 	if err := ensureDesktopFile(desktopID); err != nil {
 		// Non-fatal but inform user.
 		log.Printf("location: failed to ensure desktop file: %v", err)
@@ -87,6 +192,9 @@ func InitLocationTracking(desktopID string) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	locationCancel = cancel
 	go runGeoClueLoop(ctx, desktopID)
+	if ipLocationEnabled() {
+		go runIPLocationFallback(ctx)
+	}
 	return nil
 }
 
@@ -156,9 +264,6 @@ func runGeoClueLoop(ctx context.Context, desktopID string) {
 	const (
 		maxInitialRetries = 5
 		retryBaseDelay    = 2 * time.Second
-		requestedAccuracy = uint32(5)  // "exact"
-		distanceThreshold = uint32(25) // meters between updates
-		timeThreshold     = uint32(5)  // seconds between updates
 	)
 
 	var attempt int
@@ -169,7 +274,7 @@ func runGeoClueLoop(ctx context.Context, desktopID string) {
 		default:
 		}
 		err := func() error {
-			cl, err := newGeoClueClient(desktopID, requestedAccuracy, distanceThreshold, timeThreshold)
+			cl, err := newGeoClueClient(desktopID, locationAccuracy, locationDistanceM, locationTimeS)
 			if err != nil {
 				return err
 			}
@@ -326,17 +431,121 @@ func (c *geoClient) readAndStoreLocation(locPath dbus.ObjectPath) {
 	if lat == 0 && lon == 0 {
 		return // ignore obviously invalid fix
 	}
+	if locationMaxAccuracyM > 0 && acc > locationMaxAccuracyM {
+		logger.Debug("location: rejecting fix lat=%.6f lon=%.6f accuracy=%.0fm (max %.0fm)", lat, lon, acc, locationMaxAccuracyM)
+		return
+	}
 
-	locationMu.Lock()
-	currentLocation = LocationFix{
+	fix := LocationFix{
 		Latitude:  lat,
 		Longitude: lon,
 		Accuracy:  acc,
 		Altitude:  alt,
-		Timestamp: time.Now().UTC(),
 	}
+	if prev, ok := GetRawLocation(); ok && prev.Source != locationSourceIP {
+		if speed, bad := implausibleSpeed(prev, fix, time.Now().UTC()); bad {
+			logger.Debug("location: rejecting fix lat=%.6f lon=%.6f: implied speed %.0fm/s (max %.0fm/s)", lat, lon, speed, locationMaxSpeedMPS)
+			return
+		}
+	}
+	setLocationFix(fix)
+	recordTrackFix(fix)
+}
+
+// implausibleSpeed returns the speed implied by moving from prev to fix at now
+// and whether it exceeds locationMaxSpeedMPS. Both accuracy radii are
+// subtracted from the distance so imprecise but consistent fixes pass, and
+// the elapsed time is at least one second.
+func implausibleSpeed(prev, fix LocationFix, now time.Time) (float64, bool) {
+	if locationMaxSpeedMPS <= 0 {
+		return 0, false
+	}
+	d := distanceMeters(prev.Latitude, prev.Longitude, fix.Latitude, fix.Longitude) - prev.Accuracy - fix.Accuracy
+	if d <= 0 {
+		return 0, false
+	}
+	dt := max(now.Sub(prev.Timestamp).Seconds(), 1)
+	speed := d / dt
+	return speed, speed > locationMaxSpeedMPS
+}
+
+// parseMockLocation parses "lat,lon" or "lat,lon,accuracy_m".
+func parseMockLocation(s string) (LocationFix, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return LocationFix{}, errors.New("want lat,lon[,accuracy_m]")
+	}
+	var v [3]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return LocationFix{}, fmt.Errorf("invalid number %q", p)
+		}
+		v[i] = f
+	}
+	if v[0] < -90 || v[0] > 90 || v[1] < -180 || v[1] > 180 || v[2] < 0 {
+		return LocationFix{}, errors.New("lat/lon out of range or negative accuracy")
+	}
+	return LocationFix{Latitude: v[0], Longitude: v[1], Accuracy: v[2]}, nil
+}
+
+// setLocationFix stores fix (stamped now) as the raw location and sets the
+// current location to it, or to the moving average of the recent fixes when
+// smoothing is enabled.
+func setLocationFix(fix LocationFix) {
+	fix.Timestamp = time.Now().UTC()
+	fix.Source = ""
+	locationMu.Lock()
+	defer locationMu.Unlock()
+	rawLocation = fix
 	locationValid = true
-	locationMu.Unlock()
+	if locationSmoothN <= 1 {
+		currentLocation = fix
+		return
+	}
+	recentFixes = append(recentFixes, fix)
+	if len(recentFixes) > locationSmoothN {
+		recentFixes = recentFixes[len(recentFixes)-locationSmoothN:]
+	}
+	currentLocation = averageFixes(recentFixes)
+}
+
+// averageFixes returns the mean position, accuracy and altitude of fixes,
+// stamped with the latest fix's time. Longitudes are averaged relative to the
+// latest fix so a window straddling the antimeridian stays continuous.
+func averageFixes(fixes []LocationFix) LocationFix {
+	last := fixes[len(fixes)-1]
+	var lat, dLon, acc, alt float64
+	for _, f := range fixes {
+		lat += f.Latitude
+		d := f.Longitude - last.Longitude
+		if d > 180 {
+			d -= 360
+		} else if d < -180 {
+			d += 360
+		}
+		dLon += d
+		acc += f.Accuracy
+		alt += f.Altitude
+	}
+	n := float64(len(fixes))
+	lon := last.Longitude + dLon/n
+	if lon > 180 {
+		lon -= 360
+	} else if lon < -180 {
+		lon += 360
+	}
+	return LocationFix{Latitude: lat / n, Longitude: lon, Accuracy: acc / n, Altitude: alt / n, Timestamp: last.Timestamp}
+}
+
+// GetRawLocation returns the latest unsmoothed fix.
+func GetRawLocation() (LocationFix, bool) {
+	locationMu.RLock()
+	defer locationMu.RUnlock()
+	if !locationValid {
+		return LocationFix{}, false
+	}
+	return rawLocation, true
 }
 
 // Helper so other packages (or QML integration wrappers later) can get current fix.
@@ -348,3 +557,16 @@ func GetCurrentLocation() (LocationFix, bool) {
 	}
 	return currentLocation, true
 }
+
+// earthRadiusMeters is the mean Earth radius used for great-circle distances.
+const earthRadiusMeters = 6371008.8
+
+// distanceMeters returns the haversine great-circle distance between two coordinates.
+func distanceMeters(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Min(1, math.Sqrt(a)))
+}
@@ -5,12 +5,14 @@ package main
 import (
 	_ "embed"
 	"flag"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"sync"
 
 	qt "github.com/mappu/miqt/qt6"
 	"github.com/mappu/miqt/qt6/qml"
@@ -26,10 +28,8 @@ var dataDir string
 var configDir string
 var cacheDir string
 
-// Global live waypoint store (bookmarks + other GPX waypoints).
-// Waypoint type & persistence helpers now live in storage.go.
-var allWaypoints []Waypoint
-var allWaypointsMu sync.RWMutex
+// The live waypoint set (bookmarks + other GPX waypoints) is waypointStore
+// (waypointstore.go); the Waypoint type & persistence helpers live in storage.go.
 
 func main() {
 	// Command-line flags
@@ -38,15 +38,36 @@ func main() {
 	dataDirFlag := flag.String("data-dir", "", "custom data directory (overrides XDG_DATA_HOME)")
 	configDirFlag := flag.String("config-dir", "", "custom config directory (overrides XDG_CONFIG_HOME)")
 	cacheDirFlag := flag.String("cache-dir", "", "custom cache directory (overrides XDG_CACHE_HOME)")
+	logLevelFlag := flag.String("log-level", "", "log level (error|warn|info|debug); --debug implies debug")
+	logFileFlag := flag.String("log-file", "", "append logs to this file instead of stderr")
+	apiOnlyFlag := flag.Bool("api-only-on-qml-failure", false, "keep serving the HTTP API when the QML UI fails to load (e.g. no display)")
+	apiAddrFlag := flag.String("api-addr", "", "API bind address (default 127.0.0.1; also WHEREAMI_API_ADDR, optionally host:port)")
+	apiPortFlag := flag.Int("api-port", 0, "API port (default 43098)")
 	flag.Parse()
 	debug := *debugFlag
 	themeVariant := *themeFlag
 
-	// Set debug logging
-	logger.SetDebug(debug)
+	// Set log output and level (--debug wins over --log-level)
+	if *logFileFlag != "" {
+		f, err := os.OpenFile(*logFileFlag, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			logger.Fatal("Failed to open log file %s: %v", *logFileFlag, err)
+		}
+		logger.SetOutput(f)
+	}
+	if *logLevelFlag != "" && !debug {
+		if err := logger.SetLevel(*logLevelFlag); err != nil {
+			logger.Fatal("%v", err)
+		}
+	} else {
+		logger.SetDebug(debug)
+	}
 
-	// Hardcoded API port (as requested)
-	const apiPort = 43098
+	// API bind address: flags > WHEREAMI_API_ADDR > 127.0.0.1:43098 (the URL the QML UI uses).
+	addr, err := resolveAPIAddr(*apiAddrFlag, *apiPortFlag)
+	if err != nil {
+		logger.Fatal("%v", err)
+	}
 
 	// Determine data directory for persistent app storage (bookmarks, imported GPX, databases).
 	// Precedence: --data-dir flag > $XDG_DATA_HOME > $HOME/.local/share/whereami > CWD fallback.
@@ -99,20 +120,22 @@ func main() {
 
 	// (Removed HTTP /qml/ handler — using local temp materialization instead)
 
-	// Start server on fixed port 43098
+	// Start API server
+	logger.Info("API listening on http://%s", addr)
+	if addr != defaultAPIHost+":"+strconv.Itoa(defaultAPIPort) {
+		logger.Warn("API address %s differs from the default the QML UI connects to", addr)
+	}
+	serverDone := make(chan struct{})
 	go func() {
-		addr := "127.0.0.1:43098"
-		if err := http.ListenAndServe(addr, nil); err != nil {
+		defer close(serverDone)
+		if err := http.ListenAndServe(addr, withRequestID(http.DefaultServeMux)); err != nil {
 			logger.Error("Bookmark API server error on %s: %v", addr, err)
 		}
 	}()
 
 	// Build initial waypoint list (bookmarks + imported GPX) using centralized dedupe helper.
-	initial := RebuildAllWaypoints(bookmarksPath, dataDir)
-
-	allWaypointsMu.Lock()
-	allWaypoints = initial
-	allWaypointsMu.Unlock()
+	waypointStore.Replace(RebuildAllWaypoints(bookmarksPath, dataDir))
+	addTracks(loadTracks(dataDir, bookmarksPath))
 
 	// Prepare arguments for Qt; append a synthetic --theme=<variant> so QML can always detect it
 	qtArgs := os.Args
@@ -131,12 +154,53 @@ func main() {
 	// Load QML from Qt resources (qrc:/)
 	engine.Load(qt.NewQUrl3("qrc:/components/MapView.qml"))
 	if len(engine.RootObjects()) == 0 {
-		logger.Fatal("QML load failed: no root objects (check QML errors / Qt Location).")
+		if !*apiOnlyFlag {
+			logger.Fatal("QML load failed: no root objects (check QML errors / Qt Location).")
+		}
+		// API-only mode: the backend is still useful without the GUI.
+		logger.Error("QML load failed: no root objects (check QML errors / Qt Location); continuing with HTTP API only on %s", addr)
+		<-serverDone
+		os.Exit(1)
 	}
-	logger.Debug("Bookmark API fixed port: http://127.0.0.1:%d/api/bookmarks", apiPort)
+	logger.Debug("Bookmark API: http://%s/api/bookmarks", addr)
 	qt.QApplication_Exec()
 }
 
+// Default API bind address; the QML UI connects to this fixed URL.
+const (
+	defaultAPIHost = "127.0.0.1"
+	defaultAPIPort = 43098
+)
+
+// resolveAPIAddr returns the host:port to listen on. addrFlag (or, when empty,
+// WHEREAMI_API_ADDR) may be a host or host:port; a non-zero portFlag wins over
+// any port given there.
+func resolveAPIAddr(addrFlag string, portFlag int) (string, error) {
+	host, port := defaultAPIHost, defaultAPIPort
+	v := addrFlag
+	if v == "" {
+		v = strings.TrimSpace(os.Getenv("WHEREAMI_API_ADDR"))
+	}
+	if v != "" {
+		if h, p, err := net.SplitHostPort(v); err == nil {
+			n, err := strconv.Atoi(p)
+			if err != nil {
+				return "", fmt.Errorf("invalid API address %q: bad port", v)
+			}
+			host, port = h, n
+		} else {
+			host = strings.Trim(v, "[]")
+		}
+	}
+	if portFlag != 0 {
+		port = portFlag
+	}
+	if port < 1 || port > 65535 {
+		return "", fmt.Errorf("invalid API port %d (must be 1-65535)", port)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
 // copyEmbeddedBookmarks writes the embedded bookmarks.gpx to the specified path.
 func copyEmbeddedBookmarks(destPath string) error {
 	// Ensure the parent directory exists
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/rubiojr/whereami/pkg/logger"
+)
+
+// dbIndexInfo is one index reported by the reindex endpoint.
+type dbIndexInfo struct {
+	Name  string `json:"name"`
+	Table string `json:"table"`
+}
+
+// dbReindexResult reports the outcome for one database.
+type dbReindexResult struct {
+	Database string        `json:"database"`
+	Open     bool          `json:"open"`
+	Error    string        `json:"error,omitempty"`
+	Indices  []dbIndexInfo `json:"indices"`
+}
+
+// listIndexes returns every index (including implicit PRIMARY KEY autoindexes) in db.
+func listIndexes(db *sql.DB) ([]dbIndexInfo, error) {
+	rows, err := db.Query(`SELECT name, tbl_name FROM sqlite_master WHERE type = 'index' ORDER BY tbl_name, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []dbIndexInfo{}
+	for rows.Next() {
+		var ix dbIndexInfo
+		if err := rows.Scan(&ix.Name, &ix.Table); err != nil {
+			return nil, err
+		}
+		out = append(out, ix)
+	}
+	return out, rows.Err()
+}
+
+// reindexDB re-creates the expected indices, rebuilds all of them and lists the result.
+func reindexDB(name string, db *sql.DB, stmts []string) dbReindexResult {
+	res := dbReindexResult{Database: name, Indices: []dbIndexInfo{}}
+	if db == nil {
+		res.Error = "database not open"
+		return res
+	}
+	res.Open = true
+	if err := ensureIndexes(db, stmts); err != nil {
+		res.Error = "create index: " + err.Error()
+	} else if _, err := db.Exec(`REINDEX`); err != nil {
+		res.Error = "reindex: " + err.Error()
+	}
+	if ix, err := listIndexes(db); err == nil {
+		res.Indices = ix
+	} else if res.Error == "" {
+		res.Error = "list indices: " + err.Error()
+	}
+	return res
+}
+
+// POST /api/maintenance/reindex-db
+// Re-issues the CREATE INDEX IF NOT EXISTS statements and runs REINDEX on the
+// tag, history and geocode databases, returning the indices present afterwards.
+func handlePostReindexDB(w http.ResponseWriter, r *http.Request) {
+	initTagDB()
+	initHistoryDB()
+	initGeocodeDB()
+
+	results := []dbReindexResult{
+		reindexDB("tags", tagDB.Load(), tagIndexes),
+		reindexDB("history", historyDB, historyIndexes),
+		reindexDB("geocode", geoDB, geocodeIndexes),
+	}
+	for _, res := range results {
+		if res.Error != "" {
+			logger.ErrorCtx(r.Context(), "reindex %s: %s", res.Database, res.Error)
+		} else {
+			logger.InfoCtx(r.Context(), "reindex %s: %d index(es)", res.Database, len(res.Indices))
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"databases": results})
+}
+
+// tagColumnInfo is one waypoint_tags column as reported by PRAGMA table_info.
+type tagColumnInfo struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	NotNull bool   `json:"not_null"`
+	PK      int    `json:"pk"` // 1-based position in the primary key, 0 when not part of it
+}
+
+// tagSchemaReport describes the current waypoint_tags table.
+type tagSchemaReport struct {
+	Exists   bool            `json:"exists"`
+	SQL      string          `json:"sql"`
+	Columns  []tagColumnInfo `json:"columns"`
+	Rows     int             `json:"rows"`
+	OK       bool            `json:"ok"`
+	Problems []string        `json:"problems"`
+}
+
+// tagKeyColumns are the expected waypoint_tags primary key columns, in order.
+var tagKeyColumns = []string{"name", "lat", "lon", "tag"}
+
+// inspectTagSchema compares waypoint_tags against waypointTagsColumns.
+func inspectTagSchema(db *sql.DB) (tagSchemaReport, error) {
+	rep := tagSchemaReport{Columns: []tagColumnInfo{}, Problems: []string{}}
+	err := db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'waypoint_tags'`).Scan(&rep.SQL)
+	if errors.Is(err, sql.ErrNoRows) {
+		rep.Problems = append(rep.Problems, "table waypoint_tags is missing")
+		return rep, nil
+	}
+	if err != nil {
+		return rep, err
+	}
+	rep.Exists = true
+	rows, err := db.Query(`PRAGMA table_info(waypoint_tags)`)
+	if err != nil {
+		return rep, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid int
+		var c tagColumnInfo
+		var notNull int
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &c.Name, &c.Type, &notNull, &dflt, &c.PK); err != nil {
+			return rep, err
+		}
+		c.NotNull = notNull != 0
+		rep.Columns = append(rep.Columns, c)
+	}
+	if err := rows.Err(); err != nil {
+		return rep, err
+	}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM waypoint_tags`).Scan(&rep.Rows); err != nil {
+		return rep, err
+	}
+
+	cols := make(map[string]tagColumnInfo, len(rep.Columns))
+	for _, c := range rep.Columns {
+		cols[strings.ToLower(c.Name)] = c
+	}
+	for i, name := range []string{"name", "lat", "lon", "tag", "color"} {
+		c, ok := cols[name]
+		switch {
+		case !ok:
+			rep.Problems = append(rep.Problems, "missing column "+name)
+		case !c.NotNull:
+			rep.Problems = append(rep.Problems, "column "+name+" allows NULL")
+		case name != "color" && c.PK != i+1:
+			rep.Problems = append(rep.Problems, "column "+name+" is not part of the PRIMARY KEY(name, lat, lon, tag)")
+		}
+	}
+	for _, c := range rep.Columns {
+		if c.PK > 0 && !slices.Contains(tagKeyColumns, strings.ToLower(c.Name)) {
+			rep.Problems = append(rep.Problems, "unexpected PRIMARY KEY column "+c.Name)
+		}
+	}
+	rep.OK = len(rep.Problems) == 0
+	return rep, nil
+}
+
+// repairTagSchema rebuilds waypoint_tags with the expected schema in one
+// transaction: rows are copied into a new table (rows with NULL keys or empty
+// tags are dropped, duplicates collapse into one), then the tables are
+// swapped. It returns the number of rows copied.
+func repairTagSchema(db *sql.DB, rep tagSchemaReport) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DROP TABLE IF EXISTS waypoint_tags_new`); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`CREATE TABLE waypoint_tags_new ` + waypointTagsColumns); err != nil {
+		return 0, err
+	}
+	var copied int64
+	if rep.Exists {
+		have := map[string]bool{}
+		for _, c := range rep.Columns {
+			have[strings.ToLower(c.Name)] = true
+		}
+		for _, name := range tagKeyColumns {
+			if !have[name] {
+				return 0, fmt.Errorf("cannot repair: column %s is missing", name)
+			}
+		}
+		color := `''`
+		if have["color"] {
+			color = `COALESCE(color, '')`
+		}
+		res, err := tx.Exec(`INSERT OR IGNORE INTO waypoint_tags_new(name, lat, lon, tag, color)
+			SELECT name, lat, lon, tag, ` + color + ` FROM waypoint_tags
+			WHERE name IS NOT NULL AND lat IS NOT NULL AND lon IS NOT NULL AND tag IS NOT NULL AND trim(tag) <> ''`)
+		if err != nil {
+			return 0, err
+		}
+		copied, _ = res.RowsAffected()
+		if _, err := tx.Exec(`DROP TABLE waypoint_tags`); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.Exec(`ALTER TABLE waypoint_tags_new RENAME TO waypoint_tags`); err != nil {
+		return 0, err
+	}
+	return copied, tx.Commit()
+}
+
+// GET /api/tags/schema
+// Reports the waypoint_tags definition, its columns and row count, and any
+// deviation from the expected schema ({ ok, problems }).
+func handleGetTagSchema(w http.ResponseWriter, r *http.Request) {
+	if !requireTagDB(w) {
+		return
+	}
+	rep, err := inspectTagSchema(tagDB.Load())
+	if err != nil {
+		http.Error(w, "schema query error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(rep)
+}
+
+// POST /api/tags/repair[?force=true]
+// Migrates waypoint_tags to the expected schema (new table, copy, swap) when
+// GET /api/tags/schema reports problems, or always with force=true. Returns
+// { repaired, rows_before, rows_copied, schema }.
+func handlePostTagRepair(w http.ResponseWriter, r *http.Request) {
+	if !requireTagDB(w) {
+		return
+	}
+	before, err := inspectTagSchema(tagDB.Load())
+	if err != nil {
+		http.Error(w, "schema query error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	repaired := false
+	var copied int64
+	if !before.OK || strings.EqualFold(r.URL.Query().Get("force"), "true") {
+		copied, err = repairTagSchema(tagDB.Load(), before)
+		if err != nil {
+			logger.ErrorCtx(r.Context(), "tag schema repair failed: %v", err)
+			http.Error(w, "repair error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		repaired = true
+		_ = ensureIndexes(tagDB.Load(), tagIndexes) // dropped with the old table
+		markTagsChanged()
+		logger.InfoCtx(r.Context(), "tag schema repaired: problems=%v rows_before=%d rows_copied=%d", before.Problems, before.Rows, copied)
+	}
+	after, err := inspectTagSchema(tagDB.Load())
+	if err != nil {
+		http.Error(w, "schema query error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"repaired":    repaired,
+		"rows_before": before.Rows,
+		"rows_copied": copied,
+		"schema":      after,
+	})
+}
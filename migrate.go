@@ -0,0 +1,402 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rubiojr/whereami/pkg/logger"
+)
+
+// Data directory migration: merges another whereami data directory (e.g. an
+// old --data-dir) into the current one. Nothing in the source is modified.
+
+// migrateSummary is the POST /api/maintenance/migrate-data response.
+type migrateSummary struct {
+	Source           string   `json:"source"`
+	BookmarksAdded   int      `json:"bookmarks_added"`
+	ImportsCopied    []string `json:"imports_copied"`
+	ImportsSkipped   []string `json:"imports_skipped"`
+	TagsAdded        int64    `json:"tags_added"`
+	HistoryAdded     int64    `json:"history_added"`
+	TrackPointsAdded int64    `json:"track_points_added"`
+	AttachmentsAdded int64    `json:"attachments_added"`
+	Errors           []string `json:"errors"`
+	Waypoints        int      `json:"waypoints"`
+}
+
+// openSourceDB opens a SQLite file of the source directory read-only; it
+// returns nil (and no error) when the file does not exist.
+func openSourceDB(path string) (*sql.DB, error) {
+	if !fileExists(path) {
+		return nil, nil
+	}
+	return sql.Open("sqlite", "file:"+path+"?mode=ro")
+}
+
+// sourceColumns returns the lower-cased column names of table in db (empty if missing).
+func sourceColumns(db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		cols[strings.ToLower(name)] = true
+	}
+	return cols, rows.Err()
+}
+
+// migrateBookmarks merges the source bookmarks.gpx into bookmarksPath (first
+// occurrence wins, so existing bookmarks are kept) and returns how many were added.
+func migrateBookmarks(srcPath, bookmarksPath string) (int, error) {
+	if !fileExists(srcPath) {
+		return 0, nil
+	}
+	src, err := parseGPXFile(srcPath)
+	if err != nil {
+		return 0, err
+	}
+	bookmarkMu.Lock()
+	defer bookmarkMu.Unlock()
+	var current []Waypoint
+	if fileExists(bookmarksPath) {
+		if current, err = parseGPXFile(bookmarksPath); err != nil {
+			return 0, err
+		}
+	}
+	merged := MergeAndDedupe(current, src)
+	added := len(merged) - len(DedupeWaypoints(current))
+	if added <= 0 {
+		return 0, nil
+	}
+	return added, writeBookmarks(bookmarksPath, merged)
+}
+
+// migrateImports copies waypoint files from srcDir into importsDir, skipping
+// names that already exist there.
+func migrateImports(srcDir, importsDir string) (copied, skipped []string, err error) {
+	copied, skipped = []string{}, []string{}
+	entries, err := os.ReadDir(srcDir)
+	if os.IsNotExist(err) {
+		return copied, skipped, nil
+	}
+	if err != nil {
+		return copied, skipped, err
+	}
+	if err := os.MkdirAll(importsDir, 0o755); err != nil {
+		return copied, skipped, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !isWaypointFile(e.Name()) {
+			continue
+		}
+		dest := filepath.Join(importsDir, e.Name())
+		if fileExists(dest) {
+			skipped = append(skipped, e.Name())
+			continue
+		}
+		if err := copyFile(filepath.Join(srcDir, e.Name()), dest); err != nil {
+			return copied, skipped, err
+		}
+		copied = append(copied, e.Name())
+	}
+	return copied, skipped, nil
+}
+
+// migrateTags inserts the source waypoint_tags rows (INSERT OR IGNORE).
+func migrateTags(src *sql.DB) (int64, error) {
+	cols, err := sourceColumns(src, "waypoint_tags")
+	if err != nil || len(cols) == 0 {
+		return 0, err
+	}
+	color := `''`
+	if cols["color"] {
+		color = `COALESCE(color, '')`
+	}
+	rows, err := src.Query(`SELECT name, lat, lon, tag, ` + color + ` FROM waypoint_tags
+		WHERE name IS NOT NULL AND lat IS NOT NULL AND lon IS NOT NULL AND tag IS NOT NULL`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	tx, err := tagDB.Load().Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var added int64
+	for rows.Next() {
+		var (
+			name, tag, c string
+			lat, lon     float64
+		)
+		if err := rows.Scan(&name, &lat, &lon, &tag, &c); err != nil {
+			return 0, err
+		}
+		res, err := tx.Exec(`INSERT OR IGNORE INTO waypoint_tags(name, lat, lon, tag, color) VALUES(?,?,?,?,?)`, name, lat, lon, tag, c)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := res.RowsAffected()
+		added += n
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	return added, tx.Commit()
+}
+
+// migrateHistory copies search history rows not already present (same query and time).
+func migrateHistory(src *sql.DB) (int64, error) {
+	cols, err := sourceColumns(src, "search_history")
+	if err != nil || len(cols) == 0 {
+		return 0, err
+	}
+	latLon := `NULL, NULL`
+	if cols["lat"] && cols["lon"] {
+		latLon = `lat, lon`
+	}
+	rows, err := src.Query(`SELECT query, ` + latLon + `, CAST(at AS TEXT) FROM search_history ORDER BY id`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	tx, err := historyDB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var added int64
+	for rows.Next() {
+		var (
+			q        string
+			lat, lon sql.NullFloat64
+			at       string
+		)
+		if err := rows.Scan(&q, &lat, &lon, &at); err != nil {
+			return 0, err
+		}
+		res, err := tx.Exec(`INSERT INTO search_history(query, lat, lon, at)
+			SELECT ?, ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM search_history WHERE query = ? AND at = ?)`,
+			q, lat, lon, at, q, at)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := res.RowsAffected()
+		added += n
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	return added, tx.Commit()
+}
+
+// migrateTrackLog copies location_track rows not already present (same time and position).
+func migrateTrackLog(src *sql.DB) (int64, error) {
+	cols, err := sourceColumns(src, "location_track")
+	if err != nil || len(cols) == 0 {
+		return 0, err
+	}
+	rows, err := src.Query(`SELECT CAST(ts AS TEXT), lat, lon, accuracy FROM location_track ORDER BY id`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	tx, err := trackLogDB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var added int64
+	for rows.Next() {
+		var (
+			ts       string
+			lat, lon float64
+			acc      sql.NullFloat64
+		)
+		if err := rows.Scan(&ts, &lat, &lon, &acc); err != nil {
+			return 0, err
+		}
+		res, err := tx.Exec(`INSERT INTO location_track(ts, lat, lon, accuracy)
+			SELECT ?, ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM location_track WHERE ts = ? AND lat = ? AND lon = ?)`,
+			ts, lat, lon, acc, ts, lat, lon)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := res.RowsAffected()
+		added += n
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	return added, tx.Commit()
+}
+
+// migrateAttachments copies the attachment files of srcDir whose bookmark id
+// has no attachment yet, together with their attachments rows.
+func migrateAttachments(srcDir string) func(*sql.DB) (int64, error) {
+	return func(src *sql.DB) (int64, error) {
+		cols, err := sourceColumns(src, "attachments")
+		if err != nil || len(cols) == 0 {
+			return 0, err
+		}
+		rows, err := src.Query(`SELECT bookmark_id, content_type, size, created_at FROM attachments`)
+		if err != nil {
+			return 0, err
+		}
+		defer rows.Close()
+		dir := attachmentsDir()
+		if err := ensureDir(dir); err != nil {
+			return 0, err
+		}
+		attachmentsMu.Lock()
+		defer attachmentsMu.Unlock()
+		var added int64
+		for rows.Next() {
+			var (
+				id, ctype string
+				size      int64
+				created   time.Time
+			)
+			if err := rows.Scan(&id, &ctype, &size, &created); err != nil {
+				return added, err
+			}
+			if _, err := hex.DecodeString(id); err != nil || id == "" {
+				continue
+			}
+			var exists bool
+			if err := attachmentsDB.QueryRow(`SELECT EXISTS(SELECT 1 FROM attachments WHERE bookmark_id = ?)`, id).Scan(&exists); err != nil {
+				return added, err
+			}
+			srcFile := filepath.Join(srcDir, "attachments", id)
+			if exists || !fileExists(srcFile) {
+				continue
+			}
+			if err := copyFile(srcFile, filepath.Join(dir, id)); err != nil {
+				return added, err
+			}
+			if _, err := attachmentsDB.Exec(`INSERT INTO attachments(bookmark_id, content_type, size, created_at) VALUES(?,?,?,?)`,
+				id, ctype, size, created); err != nil {
+				return added, err
+			}
+			added++
+		}
+		return added, rows.Err()
+	}
+}
+
+// migrateDB opens name in the source directory and applies fn when both it and
+// the destination database are available.
+func migrateDB(sum *migrateSummary, name string, dest *sql.DB, fn func(*sql.DB) (int64, error)) int64 {
+	src, err := openSourceDB(filepath.Join(sum.Source, name))
+	if err != nil {
+		sum.Errors = append(sum.Errors, fmt.Sprintf("%s: %v", name, err))
+		return 0
+	}
+	if src == nil {
+		return 0
+	}
+	defer src.Close()
+	if dest == nil {
+		sum.Errors = append(sum.Errors, name+": destination database not open")
+		return 0
+	}
+	n, err := fn(src)
+	if err != nil {
+		sum.Errors = append(sum.Errors, fmt.Sprintf("%s: %v", name, err))
+	}
+	return n
+}
+
+// POST /api/maintenance/migrate-data  JSON: { source }
+// Merges another data directory into the current one: bookmarks.gpx (deduped),
+// imports/ (new file names only), the tags/history/track SQLite DBs (rows
+// not already present) and bookmark attachments (files and rows of bookmarks
+// without one). Returns a per-part summary; failures of one part are
+// listed in errors without aborting the others.
+func handlePostMigrateData(bookmarksPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Source string `json:"source"`
+		}
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+		if strings.TrimSpace(req.Source) == "" {
+			http.Error(w, "source required", http.StatusBadRequest)
+			return
+		}
+		dir := effectiveDataDir()
+		if dir == "" {
+			http.Error(w, "no data directory available", http.StatusInternalServerError)
+			return
+		}
+		src, err := filepath.Abs(req.Source)
+		if err != nil {
+			http.Error(w, "invalid source: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if info, err := os.Stat(src); err != nil || !info.IsDir() {
+			http.Error(w, "not a directory", http.StatusBadRequest)
+			return
+		}
+		// Compare resolved paths so a symlink to the data dir is rejected too.
+		realSrc, err := filepath.EvalSymlinks(src)
+		if err != nil {
+			http.Error(w, "invalid source: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if realDir, err := filepath.EvalSymlinks(dir); err == nil && realDir == realSrc {
+			http.Error(w, "source is the current data directory", http.StatusBadRequest)
+			return
+		}
+
+		sum := migrateSummary{Source: src, Errors: []string{}}
+		if sum.BookmarksAdded, err = migrateBookmarks(filepath.Join(src, "bookmarks.gpx"), bookmarksPath); err != nil {
+			sum.Errors = append(sum.Errors, "bookmarks.gpx: "+err.Error())
+		}
+		if sum.ImportsCopied, sum.ImportsSkipped, err = migrateImports(filepath.Join(src, "imports"), filepath.Join(dir, "imports")); err != nil {
+			sum.Errors = append(sum.Errors, "imports: "+err.Error())
+		}
+		initTagDB()
+		initHistoryDB()
+		initTrackLogDB()
+		initAttachmentsDB()
+		sum.TagsAdded = migrateDB(&sum, "tags.sqlite", tagDB.Load(), migrateTags)
+		sum.HistoryAdded = migrateDB(&sum, "history.sqlite", historyDB, migrateHistory)
+		sum.TrackPointsAdded = migrateDB(&sum, "track.sqlite", trackLogDB, migrateTrackLog)
+		sum.AttachmentsAdded = migrateDB(&sum, "attachments.sqlite", attachmentsDB, migrateAttachments(src))
+
+		sum.Waypoints = waypointStore.Replace(RebuildAllWaypoints(bookmarksPath, dir))
+		if sum.TagsAdded > 0 {
+			markTagsChanged()
+		}
+		for _, f := range sum.ImportsCopied {
+			if strings.EqualFold(filepath.Ext(f), ".gpx") {
+				if tracks, err := parseGPXTracks(filepath.Join(dir, "imports", f)); err == nil {
+					addTracks(tracks)
+					if err := persistTracks(tracks); err != nil {
+						sum.Errors = append(sum.Errors, "tracks: "+err.Error())
+					}
+				}
+			}
+		}
+
+		logger.InfoCtx(r.Context(), "migrate-data from %s: bookmarks=%d imports=%d tags=%d history=%d track=%d attachments=%d errors=%d",
+			src, sum.BookmarksAdded, len(sum.ImportsCopied), sum.TagsAdded, sum.HistoryAdded, sum.TrackPointsAdded, sum.AttachmentsAdded, len(sum.Errors))
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(sum)
+	}
+}
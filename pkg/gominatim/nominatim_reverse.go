@@ -42,6 +42,8 @@ type ReverseResult struct {
 	Lat         string  `json:"lat"`
 	Lon         string  `json:"lon"`
 	DisplayName string  `json:"display_name"`
+	Class       string  `json:"class"`
+	Type        string  `json:"type"`
 	Address     Address `json:"address"`
 }
 
@@ -1,43 +1,129 @@
 package logger
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// Log levels, from least to most verbose.
+const (
+	LevelError int32 = iota
+	LevelWarn
+	LevelInfo
+	LevelDebug
+)
+
+var levelNames = map[int32]string{
+	LevelError: "ERROR",
+	LevelWarn:  "WARN",
+	LevelInfo:  "INFO",
+	LevelDebug: "DEBUG",
+}
+
 var (
-	debugEnabled bool
-	infoLogger   *log.Logger
-	errorLogger  *log.Logger
-	debugLogger  *log.Logger
+	level      atomic.Int32 // current threshold (default LevelInfo)
+	configured atomic.Int32 // last level set by SetLevel, restored by SetDebug(false)
+
+	outMu sync.Mutex
+	out   io.Writer = os.Stderr
 )
 
 func init() {
-	infoLogger = log.New(os.Stderr, "", 0)
-	errorLogger = log.New(os.Stderr, "", 0)
-	debugLogger = log.New(os.Stderr, "[DEBUG] ", 0)
+	level.Store(LevelInfo)
+	configured.Store(LevelInfo)
+}
+
+// SetLevel sets the threshold by name: "error", "warn", "info" or "debug"
+// (case-insensitive; safe to call at runtime)
+func SetLevel(name string) error {
+	var lvl int32
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "error":
+		lvl = LevelError
+	case "warn", "warning":
+		lvl = LevelWarn
+	case "info":
+		lvl = LevelInfo
+	case "debug":
+		lvl = LevelDebug
+	default:
+		return fmt.Errorf("unknown log level %q", name)
+	}
+	configured.Store(lvl)
+	level.Store(lvl)
+	return nil
+}
+
+// Level returns the name of the current level
+func Level() string {
+	return strings.ToLower(levelNames[level.Load()])
 }
 
-// SetDebug enables or disables debug logging
+// SetOutput redirects all log output to w (e.g. a file); nil restores stderr
+func SetOutput(w io.Writer) {
+	if w == nil {
+		w = os.Stderr
+	}
+	outMu.Lock()
+	out = w
+	outMu.Unlock()
+}
+
+// SetDebug enables or disables debug logging (safe to call at runtime).
+// Disabling restores the level last set by SetLevel (info if that was debug).
 func SetDebug(enabled bool) {
-	debugEnabled = enabled
+	if enabled {
+		level.Store(LevelDebug)
+		return
+	}
+	lvl := configured.Load()
+	if lvl >= LevelDebug {
+		lvl = LevelInfo
+	}
+	level.Store(lvl)
+}
+
+// DebugEnabled reports whether debug logging is currently enabled
+func DebugEnabled() bool {
+	return level.Load() >= LevelDebug
+}
+
+// logf writes one "<RFC3339 timestamp> [LEVEL] message" line if lvl is enabled
+func logf(lvl int32, format string, args ...interface{}) {
+	if lvl > level.Load() {
+		return
+	}
+	msg := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+	line := time.Now().Format(time.RFC3339) + " [" + levelNames[lvl] + "] " + msg + "\n"
+	outMu.Lock()
+	_, _ = io.WriteString(out, line)
+	outMu.Unlock()
 }
 
 // Info logs an informational message
 func Info(format string, args ...interface{}) {
-	infoLogger.Printf(format, args...)
+	logf(LevelInfo, format, args...)
+}
+
+// Warn logs a warning message
+func Warn(format string, args ...interface{}) {
+	logf(LevelWarn, format, args...)
 }
 
 // Error logs an error message
 func Error(format string, args ...interface{}) {
-	errorLogger.Printf("[ERROR] "+format, args...)
+	logf(LevelError, format, args...)
 }
 
 // Debug logs a debug message if debug logging is enabled
 func Debug(format string, args ...interface{}) {
-	if debugEnabled {
-		debugLogger.Printf(format, args...)
-	}
+	logf(LevelDebug, format, args...)
 }
 
 // Infof is an alias for Info for consistency
@@ -45,6 +131,11 @@ func Infof(format string, args ...interface{}) {
 	Info(format, args...)
 }
 
+// Warnf is an alias for Warn for consistency
+func Warnf(format string, args ...interface{}) {
+	Warn(format, args...)
+}
+
 // Errorf is an alias for Error for consistency
 func Errorf(format string, args ...interface{}) {
 	Error(format, args...)
@@ -57,7 +148,7 @@ func Debugf(format string, args ...interface{}) {
 
 // Fatal logs an error message and exits with status 1
 func Fatal(format string, args ...interface{}) {
-	errorLogger.Printf(format, args...)
+	logf(LevelError, format, args...)
 	os.Exit(1)
 }
 
@@ -65,3 +156,54 @@ func Fatal(format string, args ...interface{}) {
 func Fatalf(format string, args ...interface{}) {
 	Fatal(format, args...)
 }
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the given request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID stored in ctx, or "" if none
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestPrefix prepends "[req=<id>] " to the message when ctx carries a
+// request ID. The ID is passed as an argument so it is never parsed as a verb.
+func withRequestPrefix(ctx context.Context, format string, args []interface{}) (string, []interface{}) {
+	if id := RequestID(ctx); id != "" {
+		return "[req=%s] " + format, append([]interface{}{id}, args...)
+	}
+	return format, args
+}
+
+// InfoCtx logs an informational message tagged with the request ID from ctx
+func InfoCtx(ctx context.Context, format string, args ...interface{}) {
+	format, args = withRequestPrefix(ctx, format, args)
+	Info(format, args...)
+}
+
+// ErrorCtx logs an error message tagged with the request ID from ctx
+func ErrorCtx(ctx context.Context, format string, args ...interface{}) {
+	format, args = withRequestPrefix(ctx, format, args)
+	Error(format, args...)
+}
+
+// WarnCtx logs a warning message tagged with the request ID from ctx
+func WarnCtx(ctx context.Context, format string, args ...interface{}) {
+	format, args = withRequestPrefix(ctx, format, args)
+	Warn(format, args...)
+}
+
+// DebugCtx logs a debug message tagged with the request ID from ctx
+func DebugCtx(ctx context.Context, format string, args ...interface{}) {
+	if DebugEnabled() {
+		format, args = withRequestPrefix(ctx, format, args)
+		Debug(format, args...)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rubiojr/whereami/pkg/logger"
+)
+
+// Point-in-polygon selection of bookmarks (map lasso).
+//
+// A polygon is a list of rings: the first is the outer boundary, any further
+// rings are holes. Rings are tested with ray casting in plain lat/lon space,
+// which is accurate enough for lasso-sized areas. Open rings are closed
+// automatically.
+
+// polygonVertex is one ring vertex in request payloads.
+type polygonVertex struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// closeRing returns ring with the first vertex appended when it is not already closed.
+func closeRing(ring []polygonVertex) []polygonVertex {
+	if len(ring) > 0 && ring[0] != ring[len(ring)-1] {
+		ring = append(ring, ring[0])
+	}
+	return ring
+}
+
+// pointInRing reports whether lat/lon lies inside a closed ring (ray casting).
+func pointInRing(ring []polygonVertex, lat, lon float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.Lat > lat) != (b.Lat > lat) &&
+			lon < (b.Lon-a.Lon)*(lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// pointInPolygon reports whether lat/lon is inside the outer ring and outside every hole.
+func pointInPolygon(rings [][]polygonVertex, lat, lon float64) bool {
+	if len(rings) == 0 || !pointInRing(rings[0], lat, lon) {
+		return false
+	}
+	for _, hole := range rings[1:] {
+		if pointInRing(hole, lat, lon) {
+			return false
+		}
+	}
+	return true
+}
+
+// POST /api/bookmarks/in-polygon  JSON: { "rings": [ [ {lat, lon}, ... ], ... ] }
+// Returns the bookmarks inside the polygon (first ring outer, others holes).
+func handlePostBookmarksInPolygon(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Rings [][]polygonVertex `json:"rings"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if len(req.Rings) == 0 {
+		http.Error(w, "rings required", http.StatusBadRequest)
+		return
+	}
+	for i, ring := range req.Rings {
+		ring = closeRing(ring)
+		if len(ring) < 4 {
+			http.Error(w, "each ring needs at least 3 distinct vertices", http.StatusBadRequest)
+			return
+		}
+		req.Rings[i] = ring
+	}
+
+	out := waypointStore.Filter(func(wp Waypoint) bool {
+		return wp.Bookmark && pointInPolygon(req.Rings, wp.Lat, wp.Lon)
+	})
+
+	logger.DebugCtx(r.Context(), "POST /api/bookmarks/in-polygon rings=%d matched=%d", len(req.Rings), len(out))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// Douglas-Peucker polyline simplification with a tolerance in meters, served
+// for parsed tracks (see tracks.go) by GET /api/tracks/{id}/simplify.
+
+// maxSimplifyTolerance bounds the tolerance accepted by the simplify endpoint.
+const maxSimplifyTolerance = 100000.0
+
+// trackPoint is a single coordinate of a track or route.
+type trackPoint struct {
+	Lat  float64 `xml:"lat,attr" json:"lat"`
+	Lon  float64 `xml:"lon,attr" json:"lon"`
+	Ele  float64 `xml:"ele" json:"ele,omitempty"`
+	Time string  `xml:"time" json:"time,omitempty"`
+}
+
+// simplifyPolyline returns the subset of pts retained by Douglas-Peucker at
+// toleranceM meters. The first and last points are always kept.
+func simplifyPolyline(pts []trackPoint, toleranceM float64) []trackPoint {
+	if len(pts) <= 2 || toleranceM <= 0 {
+		return append([]trackPoint(nil), pts...)
+	}
+	keep := make([]bool, len(pts))
+	keep[0], keep[len(pts)-1] = true, true
+
+	// Iterative stack avoids deep recursion on very long tracks.
+	type span struct{ first, last int }
+	stack := []span{{0, len(pts) - 1}}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		maxDist, idx := 0.0, -1
+		for i := s.first + 1; i < s.last; i++ {
+			if d := crossTrackMeters(pts[i], pts[s.first], pts[s.last]); d > maxDist {
+				maxDist, idx = d, i
+			}
+		}
+		if idx >= 0 && maxDist > toleranceM {
+			keep[idx] = true
+			stack = append(stack, span{s.first, idx}, span{idx, s.last})
+		}
+	}
+
+	out := make([]trackPoint, 0, len(pts))
+	for i, p := range pts {
+		if keep[i] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// crossTrackMeters approximates the distance from p to segment a-b using an
+// equirectangular projection centred on a (accurate for track-scale segments).
+func crossTrackMeters(p, a, b trackPoint) float64 {
+	rad := math.Pi / 180
+	cosLat := math.Cos(a.Lat * rad)
+	project := func(q trackPoint) (float64, float64) {
+		return (q.Lon - a.Lon) * rad * cosLat * earthRadiusMeters, (q.Lat - a.Lat) * rad * earthRadiusMeters
+	}
+	px, py := project(p)
+	bx, by := project(b)
+	segLen2 := bx*bx + by*by
+	if segLen2 == 0 {
+		return math.Hypot(px, py)
+	}
+	t := math.Max(0, math.Min(1, (px*bx+py*by)/segLen2))
+	return math.Hypot(px-t*bx, py-t*by)
+}
+
+// GET /api/tracks/{id}/simplify?tolerance=10
+// Returns the track with every segment simplified at tolerance meters, plus
+// { points_in, points_out, ratio } where ratio = points_out / points_in.
+func handleGetTrackSimplify(w http.ResponseWriter, r *http.Request) {
+	tol, err := strconv.ParseFloat(r.URL.Query().Get("tolerance"), 64)
+	if err != nil || tol <= 0 || tol > maxSimplifyTolerance || math.IsNaN(tol) {
+		http.Error(w, "tolerance must be a number of meters in (0, 100000]", http.StatusBadRequest)
+		return
+	}
+	t, ok := findTrack(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "track not found", http.StatusNotFound)
+		return
+	}
+	in, out := 0, 0
+	segs := make([]TrackSegment, len(t.Segments))
+	for i, seg := range t.Segments {
+		pts := simplifyPolyline(seg.Points, tol)
+		in += len(seg.Points)
+		out += len(pts)
+		segs[i] = TrackSegment{Name: seg.Name, Points: pts}
+	}
+	t.Segments = segs
+	ratio := 1.0
+	if in > 0 {
+		ratio = float64(out) / float64(in)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"track":       t,
+		"tolerance_m": tol,
+		"points_in":   in,
+		"points_out":  out,
+		"ratio":       ratio,
+	})
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,7 +20,7 @@ import (
 // Persistence / storage layer for waypoints & bookmarks.
 //
 // Responsibilities:
-//   - Parse GPX files into in‑memory `Waypoint` slices.
+//   - Parse GPX (and KML placemark) files into in‑memory `Waypoint` slices.
 //   - Collect waypoints from a directory tree (optionally recursive).
 //   - Append/delete bookmark waypoints with duplicate prevention and atomic writes.
 //   - Serialize bookmarks back to GPX safely.
@@ -25,8 +28,8 @@ import (
 //
 // Concurrency:
 //   - `bookmarkMu` guards writes to the bookmarks GPX file.
-//   - Callers that mutate in‑memory global slices (e.g. `allWaypoints`) must
-//     still use their own synchronization (`allWaypointsMu` in main.go).
+//   - The in-memory waypoint set is updated separately through `waypointStore`
+//     (waypointstore.go), which does its own locking.
 //
 // Atomic write pattern:
 //   - Write to `file.tmp` then `os.Rename` over the original to avoid partial files.
@@ -45,6 +48,9 @@ var bookmarkMu sync.Mutex
 
 // Waypoint represents a GPX waypoint (<wpt>).
 type Waypoint struct {
+	// ID is derived from name + coordinates (see waypointID); it is filled in
+	// when the waypoint is encoded as JSON and changes on rename or move.
+	ID       string  `xml:"-" json:"id,omitempty"`
 	Name     string  `xml:"name" json:"name,omitempty"`
 	Lat      float64 `xml:"lat,attr" json:"lat"`
 	Lon      float64 `xml:"lon,attr" json:"lon"`
@@ -53,11 +59,46 @@ type Waypoint struct {
 	Desc     string  `xml:"desc" json:"desc,omitempty"`
 	Bookmark bool    `xml:"-" json:"bookmark,omitempty"` // true if sourced from / destined to bookmarks.gpx
 	Deleted  bool    `xml:"-" json:"-"`                  // internal helper (soft delete when rewriting)
+
+	// Extensions holds the raw (uninterpreted) inner XML of <extensions>.
+	Extensions string `xml:"-" json:"extensions,omitempty"`
+	// ExtensionsNS carries the xmlns:prefix declarations the extension XML relies on
+	// so writeBookmarks can emit a self-contained <extensions> element.
+	ExtensionsNS []xml.Attr `xml:"-" json:"-"`
+}
+
+// waypointJSON is Waypoint without its MarshalJSON method. Response structs
+// that add fields to a waypoint embed it instead of Waypoint.
+type waypointJSON Waypoint
+
+// MarshalJSON encodes the waypoint with its derived ID.
+func (w Waypoint) MarshalJSON() ([]byte, error) {
+	return json.Marshal(w.toJSON())
+}
+
+// toJSON returns the waypoint's JSON form with ID set.
+func (w Waypoint) toJSON() waypointJSON {
+	p := waypointJSON(w)
+	p.ID = waypointID(w)
+	return p
 }
 
 // gpxRoot is the root structure used for GPX (de)serialization.
 type gpxRoot struct {
-	Waypoints []Waypoint `xml:"wpt"`
+	Attrs     []xml.Attr    `xml:",any,attr"`
+	Waypoints []gpxWaypoint `xml:"wpt"`
+}
+
+// gpxWaypoint decorates Waypoint with the raw <extensions> element.
+type gpxWaypoint struct {
+	Waypoint
+	Ext *gpxExtensions `xml:"extensions"`
+}
+
+// gpxExtensions captures <extensions> verbatim (attributes + inner XML).
+type gpxExtensions struct {
+	Attrs []xml.Attr `xml:",any,attr"`
+	Inner string     `xml:",innerxml"`
 }
 
 // parseGPXFile loads a GPX file and returns normalized waypoints (timestamps -> RFC3339 UTC).
@@ -70,20 +111,160 @@ func parseGPXFile(path string) ([]Waypoint, error) {
 	if err := xml.Unmarshal(data, &root); err != nil {
 		return nil, err
 	}
-	for i := range root.Waypoints {
-		if ts := root.Waypoints[i].Time; ts != "" {
+	wps := make([]Waypoint, len(root.Waypoints))
+	for i, gw := range root.Waypoints {
+		wp := gw.Waypoint
+		if ts := wp.Time; ts != "" {
 			if t, err := time.Parse(time.RFC3339, ts); err == nil {
-				root.Waypoints[i].Time = t.UTC().Format(time.RFC3339)
+				wp.Time = t.UTC().Format(time.RFC3339)
+			}
+		}
+		if gw.Ext != nil {
+			if inner := strings.TrimSpace(gw.Ext.Inner); inner != "" {
+				wp.Extensions = inner
+				wp.ExtensionsNS = usedNamespaces(inner, root.Attrs, gw.Ext.Attrs)
 			}
 		}
+		wps[i] = wp
 	}
-	return root.Waypoints, nil
+	return wps, nil
 }
 
-// collectGPXWaypoints walks a directory collecting waypoints from *.gpx files,
-// optionally recursively. It skips the `exclude` path if provided.
-func collectGPXWaypoints(dir string, recursive bool, exclude string) ([]Waypoint, error) {
+// kmlPlacemark is the subset of a KML <Placemark> we understand (point placemarks only).
+type kmlPlacemark struct {
+	Name        string `xml:"name"`
+	Description string `xml:"description"`
+	When        string `xml:"TimeStamp>when"`
+	Coordinates string `xml:"Point>coordinates"`
+}
+
+// parseKMLFile loads point placemarks from a KML file (e.g. Google Maps saved
+// places). Placemarks may be nested in any Document/Folder; ones without a
+// <Point> are skipped. KML coordinates are "lon,lat[,ele]".
+func parseKMLFile(path string) ([]Waypoint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var wps []Waypoint
+	dec := xml.NewDecoder(f)
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != "Placemark" {
+			continue
+		}
+		var pm kmlPlacemark
+		if err := dec.DecodeElement(&pm, &se); err != nil {
+			return nil, err
+		}
+		parts := strings.Split(strings.TrimSpace(pm.Coordinates), ",")
+		if len(parts) < 2 {
+			continue
+		}
+		lon, err1 := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		lat, err2 := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		wp := Waypoint{
+			Name: strings.TrimSpace(pm.Name),
+			Lat:  lat,
+			Lon:  lon,
+			Desc: strings.TrimSpace(pm.Description),
+		}
+		if len(parts) >= 3 {
+			wp.Ele, _ = strconv.ParseFloat(strings.TrimSpace(parts[2]), 64)
+		}
+		if t, err := time.Parse(time.RFC3339, strings.TrimSpace(pm.When)); err == nil {
+			wp.Time = t.UTC().Format(time.RFC3339)
+		}
+		wps = append(wps, wp)
+	}
+	return wps, nil
+}
+
+// isWaypointFile reports whether name has an importable extension (.gpx or .kml).
+func isWaypointFile(name string) bool {
+	ext := filepath.Ext(name)
+	return strings.EqualFold(ext, ".gpx") || strings.EqualFold(ext, ".kml")
+}
+
+// parseWaypointFile dispatches to the GPX or KML parser based on the file extension.
+func parseWaypointFile(path string) ([]Waypoint, error) {
+	if strings.EqualFold(filepath.Ext(path), ".kml") {
+		return parseKMLFile(path)
+	}
+	return parseGPXFile(path)
+}
+
+// usedNamespaces returns the xmlns:prefix declarations (from the document root
+// and the <extensions> element itself) whose prefix appears in inner.
+func usedNamespaces(inner string, attrSets ...[]xml.Attr) []xml.Attr {
+	var out []xml.Attr
+	seen := make(map[string]bool)
+	for _, attrs := range attrSets {
+		for _, a := range attrs {
+			if a.Name.Space != "xmlns" || seen[a.Name.Local] {
+				continue
+			}
+			if strings.Contains(inner, "<"+a.Name.Local+":") {
+				seen[a.Name.Local] = true
+				out = append(out, a)
+			}
+		}
+	}
+	return out
+}
+
+// fileParseError records a waypoint file that could not be parsed.
+type fileParseError struct {
+	Path  string    `json:"path"`
+	Error string    `json:"error"`
+	At    time.Time `json:"at"`
+}
+
+// Parse errors from the last rebuild plus later imports (guarded by parseErrorsMu).
+var (
+	parseErrorsMu sync.Mutex
+	parseErrors   []fileParseError
+)
+
+// setParseErrors replaces the recorded parse errors (called on every rebuild).
+func setParseErrors(errs []fileParseError) {
+	parseErrorsMu.Lock()
+	parseErrors = errs
+	parseErrorsMu.Unlock()
+}
+
+// addParseError records one more parse failure (e.g. during /api/import).
+func addParseError(path string, err error) {
+	parseErrorsMu.Lock()
+	parseErrors = append(parseErrors, fileParseError{Path: path, Error: err.Error(), At: time.Now().UTC()})
+	parseErrorsMu.Unlock()
+}
+
+// getParseErrors returns a copy of the recorded parse errors.
+func getParseErrors() []fileParseError {
+	parseErrorsMu.Lock()
+	defer parseErrorsMu.Unlock()
+	return append([]fileParseError{}, parseErrors...)
+}
+
+// collectGPXWaypoints walks a directory collecting waypoints from *.gpx and *.kml files,
+// optionally recursively. It skips the `exclude` path if provided. Files that fail
+// to parse are skipped and reported in the returned parse errors.
+func collectGPXWaypoints(dir string, recursive bool, exclude string) ([]Waypoint, []fileParseError, error) {
 	var all []Waypoint
+	var parseErrs []fileParseError
 	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, walkErr error) error {
 		if walkErr != nil {
 			return walkErr
@@ -97,22 +278,32 @@ func collectGPXWaypoints(dir string, recursive bool, exclude string) ([]Waypoint
 		if filepath.Clean(p) == filepath.Clean(exclude) {
 			return nil
 		}
-		if strings.EqualFold(filepath.Ext(d.Name()), ".gpx") {
-			wps, err := parseGPXFile(p)
+		if isWaypointFile(d.Name()) {
+			wps, err := parseWaypointFile(p)
 			if err != nil {
 				logger.Error("Skipping %s: %v", p, err)
+				parseErrs = append(parseErrs, fileParseError{Path: p, Error: err.Error(), At: time.Now().UTC()})
 				return nil
 			}
 			all = append(all, wps...)
 		}
 		return nil
 	})
-	return all, err
+	return all, parseErrs, err
 }
 
 // writeBookmarks rewrites the bookmark list (skipping Deleted entries) to path using
 // an atomic temp-file + rename pattern. Caller must hold bookmarkMu.
 func writeBookmarks(path string, wps []Waypoint) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(serializeBookmarksGPX(wps)), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// serializeBookmarksGPX renders waypoints (skipping Deleted entries) as a GPX 1.1 document.
+func serializeBookmarksGPX(wps []Waypoint) string {
 	var b strings.Builder
 	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
 	b.WriteString(`<gpx version="1.1" creator="whereami" xmlns="http://www.topografix.com/GPX/1/1">` + "\n")
@@ -122,7 +313,7 @@ func writeBookmarks(path string, wps []Waypoint) error {
 		}
 		fmt.Fprintf(&b, "  <wpt lat=\"%f\" lon=\"%f\">\n", e.Lat, e.Lon)
 		if e.Time != "" {
-			fmt.Fprintf(&b, "    <time>%s</time>\n", e.Time)
+			fmt.Fprintf(&b, "    <time>%s</time>\n", escapeXML(e.Time))
 		}
 		if e.Name != "" {
 			name := escapeXML(e.Name)
@@ -132,15 +323,18 @@ func writeBookmarks(path string, wps []Waypoint) error {
 			desc := escapeXML(e.Desc)
 			fmt.Fprintf(&b, "    <desc>%s</desc>\n", desc)
 		}
+		if e.Extensions != "" {
+			// Raw XML round-trip: namespace declarations travel with the element.
+			b.WriteString("    <extensions")
+			for _, ns := range e.ExtensionsNS {
+				fmt.Fprintf(&b, " xmlns:%s=\"%s\"", ns.Name.Local, escapeXML(ns.Value))
+			}
+			fmt.Fprintf(&b, ">%s</extensions>\n", e.Extensions)
+		}
 		b.WriteString("  </wpt>\n")
 	}
 	b.WriteString("</gpx>\n")
-
-	tmp := path + ".tmp"
-	if err := os.WriteFile(tmp, []byte(b.String()), 0o644); err != nil {
-		return err
-	}
-	return os.Rename(tmp, path)
+	return b.String()
 }
 
 // appendBookmark adds a new waypoint into bookmarks.gpx (creating or extending
@@ -253,11 +447,104 @@ func renameBookmark(bookmarksPath, oldName string, lat, lon float64, newName str
 	return true, nil
 }
 
-// escapeXML performs minimal escaping for XML content nodes (not attributes).
+// bookmarkUpdate lists the fields to change on a bookmark; nil fields are kept.
+type bookmarkUpdate struct {
+	Name *string
+	Desc *string
+	Lat  *float64
+	Lon  *float64
+}
+
+// updateBookmark applies upd to the bookmark matching oldName + lat/lon (within
+// epsilon) and rewrites the file. It returns the updated waypoint, whether it
+// was found, and ErrDuplicate when another bookmark already has the resulting
+// name and coordinates.
+func updateBookmark(bookmarksPath, oldName string, lat, lon float64, upd bookmarkUpdate) (Waypoint, bool, error) {
+	bookmarkMu.Lock()
+	defer bookmarkMu.Unlock()
+
+	wps, err := parseGPXFile(bookmarksPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Waypoint{}, false, nil
+		}
+		return Waypoint{}, false, err
+	}
+
+	const eps = 1e-6
+	idx := -1
+	for i := range wps {
+		if wps[i].Name == oldName && abs(wps[i].Lat-lat) < eps && abs(wps[i].Lon-lon) < eps {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return Waypoint{}, false, nil
+	}
+	wp := wps[idx]
+	if upd.Name != nil {
+		wp.Name = *upd.Name
+	}
+	if upd.Desc != nil {
+		wp.Desc = *upd.Desc
+	}
+	if upd.Lat != nil {
+		wp.Lat = *upd.Lat
+	}
+	if upd.Lon != nil {
+		wp.Lon = *upd.Lon
+	}
+	for i, e := range wps {
+		if i != idx && e.Name == wp.Name && abs(e.Lat-wp.Lat) < eps && abs(e.Lon-wp.Lon) < eps {
+			return Waypoint{}, true, ErrDuplicate
+		}
+	}
+	wps[idx] = wp
+	if err := writeBookmarks(bookmarksPath, wps); err != nil {
+		return Waypoint{}, true, err
+	}
+	wp.Bookmark = true
+	return wp, true, nil
+}
+
+// updateBookmarkDescs rewrites bookmark descriptions in a single pass. descFor is
+// called for every bookmark and returns the new description and whether it
+// should be applied. Returns the number of bookmarks changed.
+func updateBookmarkDescs(bookmarksPath string, descFor func(Waypoint) (string, bool)) (int, error) {
+	bookmarkMu.Lock()
+	defer bookmarkMu.Unlock()
+
+	wps, err := parseGPXFile(bookmarksPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	changed := 0
+	for i := range wps {
+		if desc, ok := descFor(wps[i]); ok && desc != wps[i].Desc {
+			wps[i].Desc = desc
+			changed++
+		}
+	}
+	if changed == 0 {
+		return 0, nil
+	}
+	if err := writeBookmarks(bookmarksPath, wps); err != nil {
+		return 0, err
+	}
+	return changed, nil
+}
+
+// escapeXML performs minimal escaping for XML content nodes and double-quoted attributes.
 func escapeXML(s string) string {
 	s = strings.ReplaceAll(s, "&", "&amp;")
 	s = strings.ReplaceAll(s, "<", "&lt;")
 	s = strings.ReplaceAll(s, ">", "&gt;")
+	s = strings.ReplaceAll(s, `"`, "&quot;")
 	return s
 }
 
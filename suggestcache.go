@@ -0,0 +1,115 @@
+package main
+
+import (
+	"container/list"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rubiojr/whereami/pkg/logger"
+)
+
+// Suggestion response cache.
+//
+// /api/suggest is called on every keystroke; backspacing and retyping produce
+// the same queries again. Final suggestion lists are kept in a small LRU keyed
+// by the normalized query, for a short TTL (WHEREAMI_SUGGEST_CACHE_TTL, a Go
+// duration; "0" disables the cache). Entries also record the waypoint and tag
+// store versions they were built from, so any bookmark or tag change
+// invalidates them immediately.
+
+const (
+	suggestCacheTTLEnv     = "WHEREAMI_SUGGEST_CACHE_TTL"
+	defaultSuggestCacheTTL = 5 * time.Second
+	suggestCacheMaxEntries = 256
+)
+
+// tagsVersion increments on every mutation of the tag DB (see markTagsChanged).
+var tagsVersion atomic.Uint64
+
+// markTagsChanged invalidates data derived from waypoint tags (suggestions).
+func markTagsChanged() {
+	tagsVersion.Add(1)
+}
+
+type suggestCacheEntry struct {
+	key         string
+	results     []suggestResult
+	created     time.Time
+	wpVersion   uint64
+	tagsVersion uint64
+}
+
+var (
+	suggestCacheMu    sync.Mutex
+	suggestCacheOnce  sync.Once
+	suggestCacheTTL   time.Duration
+	suggestCacheLRU   = list.New() // front = most recently used
+	suggestCacheIndex = make(map[string]*list.Element)
+)
+
+// suggestCacheKey normalizes a query: lowercased with collapsed whitespace.
+func suggestCacheKey(q string) string {
+	return strings.Join(strings.Fields(strings.ToLower(q)), " ")
+}
+
+// getSuggestCacheTTL resolves WHEREAMI_SUGGEST_CACHE_TTL once.
+func getSuggestCacheTTL() time.Duration {
+	suggestCacheOnce.Do(func() {
+		suggestCacheTTL = defaultSuggestCacheTTL
+		if v := os.Getenv(suggestCacheTTLEnv); v != "" {
+			if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+				suggestCacheTTL = d
+			} else {
+				logger.Warn("ignoring invalid %s=%q", suggestCacheTTLEnv, v)
+			}
+		}
+	})
+	return suggestCacheTTL
+}
+
+// suggestCacheGet returns the cached suggestions for key when still fresh.
+func suggestCacheGet(key string) ([]suggestResult, bool) {
+	ttl := getSuggestCacheTTL()
+	if ttl == 0 {
+		return nil, false
+	}
+	suggestCacheMu.Lock()
+	defer suggestCacheMu.Unlock()
+	el, ok := suggestCacheIndex[key]
+	if !ok {
+		return nil, false
+	}
+	ent := el.Value.(*suggestCacheEntry)
+	if time.Since(ent.created) >= ttl || ent.wpVersion != waypointsVersion.Load() || ent.tagsVersion != tagsVersion.Load() {
+		suggestCacheLRU.Remove(el)
+		delete(suggestCacheIndex, key)
+		return nil, false
+	}
+	suggestCacheLRU.MoveToFront(el)
+	return ent.results, true
+}
+
+// suggestCachePut stores results built at the given store versions, evicting
+// the least recently used entry beyond suggestCacheMaxEntries.
+func suggestCachePut(key string, results []suggestResult, wpVersion, tagVersion uint64) {
+	if getSuggestCacheTTL() == 0 {
+		return
+	}
+	suggestCacheMu.Lock()
+	defer suggestCacheMu.Unlock()
+	ent := &suggestCacheEntry{key: key, results: results, created: time.Now(), wpVersion: wpVersion, tagsVersion: tagVersion}
+	if el, ok := suggestCacheIndex[key]; ok {
+		el.Value = ent
+		suggestCacheLRU.MoveToFront(el)
+		return
+	}
+	suggestCacheIndex[key] = suggestCacheLRU.PushFront(ent)
+	for suggestCacheLRU.Len() > suggestCacheMaxEntries {
+		oldest := suggestCacheLRU.Back()
+		suggestCacheLRU.Remove(oldest)
+		delete(suggestCacheIndex, oldest.Value.(*suggestCacheEntry).key)
+	}
+}
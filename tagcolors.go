@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/rubiojr/whereami/pkg/logger"
+)
+
+// Automatic tag colors (GET /api/tags/colors).
+//
+// Every distinct tag gets a stable color derived from its normalized key
+// (FNV-1a hashed into an HSL hue at fixed saturation and lightness), so all
+// variants of a tag ("coffee", "☕") and every session see the same color
+// without configuration. A color assigned via POST /api/tags wins over the
+// generated one.
+
+const (
+	tagColorSaturation = 0.65
+	tagColorLightness  = 0.50
+)
+
+// autoTagColor returns the generated "#rrggbb" color for a normalized tag key.
+func autoTagColor(key string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return hslToHex(float64(h.Sum32()%360), tagColorSaturation, tagColorLightness)
+}
+
+// hslToHex converts hue (degrees) and saturation/lightness (0-1) to "#rrggbb".
+func hslToHex(hue, s, l float64) string {
+	c := (1 - math.Abs(2*l-1)) * s
+	hp := hue / 60
+	x := c * (1 - math.Abs(math.Mod(hp, 2)-1))
+	var r, g, b float64
+	switch {
+	case hp < 1:
+		r, g, b = c, x, 0
+	case hp < 2:
+		r, g, b = x, c, 0
+	case hp < 3:
+		r, g, b = 0, c, x
+	case hp < 4:
+		r, g, b = 0, x, c
+	case hp < 5:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	m := l - c/2
+	to8 := func(v float64) int { return int(math.Round((v + m) * 255)) }
+	return fmt.Sprintf("#%02x%02x%02x", to8(r), to8(g), to8(b))
+}
+
+// GET /api/tags/colors
+// Returns [ { tag, key, color, custom } ] for every distinct tag (variants
+// unified as in GET /api/tags?distinct=true), sorted by key. color is the
+// color assigned via POST /api/tags when there is one (custom: true),
+// otherwise the generated hex color for the normalized key.
+func handleGetTagColors(w http.ResponseWriter, r *http.Request) {
+	if !requireTagDB(w) {
+		return
+	}
+	raw, err := getDistinctTags()
+	if err != nil {
+		http.Error(w, "query error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Assigned colors by normalized key; the first one seen for a key wins.
+	assigned := map[string]string{}
+	rows, err := tagDB.Load().QueryContext(r.Context(), `SELECT tag, color FROM waypoint_tags WHERE color <> '' GROUP BY tag ORDER BY tag COLLATE NOCASE`)
+	if err != nil {
+		http.Error(w, "query error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var tag, color string
+		if err := rows.Scan(&tag, &color); err != nil {
+			rows.Close()
+			http.Error(w, "scan error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if k := normalizeTagKey(tag); k != "" {
+			if _, ok := assigned[k]; !ok {
+				assigned[k] = color
+			}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		http.Error(w, "query error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	type tagColorEntry struct {
+		Tag    string `json:"tag"`
+		Key    string `json:"key"`
+		Color  string `json:"color"`
+		Custom bool   `json:"custom"`
+	}
+	out := []tagColorEntry{}
+	for _, t := range unifyDistinctTags(raw) {
+		key := normalizeTagKey(t)
+		e := tagColorEntry{Tag: t, Key: key, Color: assigned[key], Custom: true}
+		if e.Color == "" {
+			e.Color, e.Custom = autoTagColor(key), false
+		}
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	logger.DebugCtx(r.Context(), "GET /api/tags/colors tags=%d custom=%d", len(out), len(assigned))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
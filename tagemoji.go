@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rubiojr/whereami/pkg/logger"
+)
+
+// User-defined tag emoji mappings.
+//
+// An optional tag-emoji.json in the config directory is merged over the
+// built-in tagEmojiMap at startup, so enrichTag, normalizeTagKey and the tag
+// listings pick the entries up. Values are either an emoji string or an object
+// with an explicit display name:
+//
+//	{ "dive-site": "🤿", "bar": { "emoji": "🍺", "name": "beer" } }
+//
+// A missing file is ignored; a malformed one is logged and skipped.
+
+const tagEmojiFile = "tag-emoji.json"
+
+var (
+	tagEmojiOnce sync.Once
+	// customTagEmojiKeys records the keys that came from tag-emoji.json.
+	customTagEmojiKeys = map[string]bool{}
+)
+
+// loadCustomTagEmoji merges tag-emoji.json from the config directory into
+// tagEmojiMap. RegisterAPI runs it once, before any request is served.
+func loadCustomTagEmoji() {
+	path := filepath.Join(effectiveConfigDir(), tagEmojiFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn("cannot read %s: %v", path, err)
+		}
+		return
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		logger.Warn("ignoring malformed %s: %v", path, err)
+		return
+	}
+	loaded := 0
+	for k, v := range raw {
+		key := strings.ToLower(strings.TrimSpace(k))
+		var entry struct {
+			Emoji string `json:"emoji"`
+			Name  string `json:"name"`
+		}
+		if err := json.Unmarshal(v, &entry.Emoji); err != nil {
+			if err := json.Unmarshal(v, &entry); err != nil {
+				logger.Warn("%s: ignoring entry %q: %v", path, k, err)
+				continue
+			}
+		}
+		entry.Emoji = strings.TrimSpace(entry.Emoji)
+		if key == "" || entry.Emoji == "" {
+			logger.Warn("%s: ignoring entry %q without a key or emoji", path, k)
+			continue
+		}
+		if entry.Name == "" {
+			entry.Name = key
+		}
+		tagEmojiMap[key] = struct{ Emoji, Name string }{entry.Emoji, entry.Name}
+		customTagEmojiKeys[key] = true
+		loaded++
+	}
+	logger.Info("loaded %d custom tag emoji mapping(s) from %s", loaded, path)
+}
+
+// GET /api/tags/emoji-map
+// Returns the merged (built-in + tag-emoji.json) mapping as a legend sorted by
+// key: [ { key, emoji, name, custom } ].
+func handleGetTagEmojiMap(w http.ResponseWriter, r *http.Request) {
+	type legendEntry struct {
+		Key    string `json:"key"`
+		Emoji  string `json:"emoji"`
+		Name   string `json:"name"`
+		Custom bool   `json:"custom"`
+	}
+	out := make([]legendEntry, 0, len(tagEmojiMap))
+	for k, v := range tagEmojiMap {
+		out = append(out, legendEntry{Key: k, Emoji: v.Emoji, Name: v.Name, Custom: customTagEmojiKeys[k]})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	logger.DebugCtx(r.Context(), "GET /api/tags/emoji-map entries=%d", len(out))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/rubiojr/whereami/pkg/logger"
+)
+
+// Tag expressions for GET /api/tags/waypoints and tag: queries on
+// /api/suggest.
+//
+// Grammar (operators are case-insensitive and must be space separated, so
+// multi-word tags keep working):
+//
+//	expr := clause { " OR " clause }
+//	clause := term { " AND " term }
+//	term := [ "NOT " ] tag
+//
+// AND binds tighter than OR. Tags match exactly after normalizeTagKey.
+
+// tagTerm is one (possibly negated) normalized tag.
+type tagTerm struct {
+	tag    string
+	negate bool
+}
+
+// tagExpr is a parsed expression in disjunctive normal form.
+type tagExpr [][]tagTerm
+
+// splitOperator splits s on a space-delimited, case-insensitive operator.
+func splitOperator(s, op string) []string {
+	sep := " " + op + " "
+	upper := strings.ToUpper(s)
+	var parts []string
+	for {
+		i := strings.Index(upper, sep)
+		if i < 0 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s, upper = s[i+len(sep):], upper[i+len(sep):]
+	}
+}
+
+// parseTagExpr parses an AND/OR/NOT tag expression.
+func parseTagExpr(s string) (tagExpr, error) {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = strings.TrimSpace(s[1 : len(s)-1])
+	}
+	if s == "" {
+		return nil, errors.New("empty tag expression")
+	}
+	var expr tagExpr
+	for _, clause := range splitOperator(s, "OR") {
+		var terms []tagTerm
+		for _, raw := range splitOperator(clause, "AND") {
+			raw = strings.TrimSpace(raw)
+			t := tagTerm{}
+			if len(raw) > 4 && strings.EqualFold(raw[:4], "NOT ") {
+				t.negate = true
+				raw = strings.TrimSpace(raw[4:])
+			}
+			if t.tag = normalizeTagKey(raw); t.tag == "" {
+				return nil, errors.New("missing tag in expression")
+			}
+			terms = append(terms, t)
+		}
+		expr = append(expr, terms)
+	}
+	return expr, nil
+}
+
+// match reports whether a waypoint with the normalized tag set satisfies e.
+func (e tagExpr) match(tags map[string]struct{}) bool {
+	for _, clause := range e {
+		ok := true
+		for _, t := range clause {
+			if _, has := tags[t.tag]; has == t.negate {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// mode names the shape of e for suggestion types: "single", "AND", "OR",
+// or "expr" for anything mixing operators or using NOT.
+func (e tagExpr) mode() string {
+	switch {
+	case len(e) == 1 && len(e[0]) == 1 && !e[0][0].negate:
+		return "single"
+	case len(e) == 1:
+		for _, t := range e[0] {
+			if t.negate {
+				return "expr"
+			}
+		}
+		return "AND"
+	}
+	for _, clause := range e {
+		if len(clause) != 1 || clause[0].negate {
+			return "expr"
+		}
+	}
+	return "OR"
+}
+
+// GET /api/tags/waypoints?tag=<expr>  (e.g. "coffee", "coffee AND NOT chain")
+// Returns the full waypoint objects (name, lat, lon, bookmark, ele?, time?,
+// desc?, tags) of every tagged waypoint matching the expression, sorted by
+// name. Only waypoints with at least one tag are considered, so a pure NOT
+// expression never returns untagged waypoints.
+func handleGetTagWaypoints(w http.ResponseWriter, r *http.Request) {
+	expr, err := parseTagExpr(r.URL.Query().Get("tag"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !requireTagDB(w) {
+		return
+	}
+	rows, err := tagDB.Load().QueryContext(r.Context(), `SELECT name, lat, lon, tag FROM waypoint_tags ORDER BY tag COLLATE NOCASE`)
+	if err != nil {
+		http.Error(w, "query error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	normalized := make(map[waypointRef]map[string]struct{})
+	raw := make(map[waypointRef][]string)
+	for rows.Next() {
+		var ref waypointRef
+		var tag string
+		if err := rows.Scan(&ref.Name, &ref.Lat, &ref.Lon, &tag); err != nil {
+			http.Error(w, "scan error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if normalized[ref] == nil {
+			normalized[ref] = make(map[string]struct{})
+		}
+		normalized[ref][normalizeTagKey(tag)] = struct{}{}
+		raw[ref] = append(raw[ref], tag)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "query error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	matches := waypointStore.Filter(func(wp Waypoint) bool {
+		tags, ok := normalized[waypointRef{wp.Name, wp.Lat, wp.Lon}]
+		return ok && expr.match(tags)
+	})
+	sort.SliceStable(matches, func(i, j int) bool {
+		return strings.ToLower(matches[i].Name) < strings.ToLower(matches[j].Name)
+	})
+
+	out := make([]map[string]any, 0, len(matches))
+	for _, wp := range matches {
+		obj := map[string]any{
+			"id":       waypointID(wp),
+			"name":     wp.Name,
+			"lat":      wp.Lat,
+			"lon":      wp.Lon,
+			"bookmark": wp.Bookmark,
+			"tags":     raw[waypointRef{wp.Name, wp.Lat, wp.Lon}],
+		}
+		if wp.Ele != 0 {
+			obj["ele"] = wp.Ele
+		}
+		if wp.Time != "" {
+			obj["time"] = wp.Time
+		}
+		if wp.Desc != "" {
+			obj["desc"] = wp.Desc
+		}
+		out = append(out, obj)
+	}
+	logger.DebugCtx(r.Context(), "GET /api/tags/waypoints tag=%q matches=%d", r.URL.Query().Get("tag"), len(out))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/rubiojr/whereami/pkg/logger"
+)
+
+// Stored tile format.
+//
+// WHEREAMI_TILE_FORMAT=webp asks the proxy to transcode fetched PNG tiles to
+// WebP before they are cached (memory and disk) and served. Transcoding needs
+// a WebP encoder registered in webpEncode, which builds with -tags webp get
+// from libwebp (webp_cgo.go); otherwise the proxy logs a warning at startup
+// and keeps storing PNG. A tile that fails to encode
+// is stored and served as the original PNG. Disk files use the extension of
+// the configured format, and the Content-Type is sniffed from the tile bytes,
+// so PNG fallbacks are still labelled correctly.
+
+const (
+	tileFormatEnv = "WHEREAMI_TILE_FORMAT"
+
+	tileFormatPNG  = "png"
+	tileFormatWebP = "webp"
+)
+
+// errNoWebPEncoder is returned by transcodeTile when no encoder is registered.
+var errNoWebPEncoder = errors.New("no WebP encoder available")
+
+var (
+	tileFormat = tileFormatPNG
+
+	// webpEncode encodes an image as WebP. It is nil in builds without -tags webp.
+	webpEncode func(image.Image) ([]byte, error)
+
+	tileBytesSaved uint64 // atomic: original minus transcoded size, summed over stored tiles
+	tileTranscoded uint64 // atomic: tiles stored as WebP
+	tileFallbacks  uint64 // atomic: tiles kept as PNG because encoding failed
+)
+
+// resolveTileFormat reads WHEREAMI_TILE_FORMAT ("png" or "webp").
+func resolveTileFormat() {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv(tileFormatEnv)))
+	switch v {
+	case "", tileFormatPNG:
+		tileFormat = tileFormatPNG
+	case tileFormatWebP:
+		if webpEncode == nil {
+			logger.Warn("%s=webp requested but %v; storing PNG tiles", tileFormatEnv, errNoWebPEncoder)
+			tileFormat = tileFormatPNG
+			return
+		}
+		tileFormat = tileFormatWebP
+	default:
+		logger.Warn("ignoring invalid %s=%q", tileFormatEnv, v)
+	}
+}
+
+// transcodeTile converts a fetched PNG tile to the configured format. The
+// original bytes are returned (with the error) whenever conversion is not
+// possible or would not make the tile smaller.
+func transcodeTile(data []byte) ([]byte, error) {
+	if tileFormat != tileFormatWebP {
+		return data, nil
+	}
+	if webpEncode == nil {
+		return data, errNoWebPEncoder
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		atomic.AddUint64(&tileFallbacks, 1)
+		return data, err
+	}
+	out, err := webpEncode(img)
+	if err != nil || len(out) == 0 {
+		atomic.AddUint64(&tileFallbacks, 1)
+		if err == nil {
+			err = errors.New("empty WebP output")
+		}
+		return data, err
+	}
+	if len(out) >= len(data) {
+		return data, nil
+	}
+	atomic.AddUint64(&tileTranscoded, 1)
+	atomic.AddUint64(&tileBytesSaved, uint64(len(data)-len(out)))
+	return out, nil
+}
+
+// tileFileExt returns the on-disk extension for cached tiles.
+func tileFileExt() string {
+	return "." + tileFormat
+}
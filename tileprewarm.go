@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rubiojr/whereami/pkg/logger"
+)
+
+// Tile cache prewarm along a route.
+//
+// When WHEREAMI_TILE_PREWARM_GPX points to a GPX file, the proxy prefetches
+// (in the background, after startup) every tile within a corridor around its
+// tracks/routes so the planned route is available offline. Zooms and the
+// corridor half-width are configurable:
+//
+//	WHEREAMI_TILE_PREWARM_GPX       path to the GPX file (trk/trkpt, rte/rtept, or wpt as a fallback)
+//	WHEREAMI_TILE_PREWARM_ZOOMS     zoom range "min-max" or a single zoom (default 10-15)
+//	WHEREAMI_TILE_PREWARM_CORRIDOR  corridor half-width in meters (default 1000)
+
+const (
+	tilePrewarmGPXEnv      = "WHEREAMI_TILE_PREWARM_GPX"
+	tilePrewarmZoomsEnv    = "WHEREAMI_TILE_PREWARM_ZOOMS"
+	tilePrewarmCorridorEnv = "WHEREAMI_TILE_PREWARM_CORRIDOR"
+
+	defaultPrewarmMinZoom   = 10
+	defaultPrewarmMaxZoom   = 15
+	defaultPrewarmCorridorM = 1000.0
+)
+
+// parseGPXRoute returns the polylines of a GPX file: one per track segment and
+// route. Files with neither yield their waypoints as a single polyline.
+func parseGPXRoute(path string) ([][]trackPoint, error) {
+	tracks, err := parseGPXTracks(path)
+	if err != nil {
+		return nil, err
+	}
+	var lines [][]trackPoint
+	for _, t := range tracks {
+		for _, seg := range t.Segments {
+			lines = append(lines, seg.Points)
+		}
+	}
+	if len(lines) == 0 {
+		wps, err := parseGPXFile(path)
+		if err != nil {
+			return nil, err
+		}
+		pts := make([]trackPoint, len(wps))
+		for i, wp := range wps {
+			pts[i] = trackPoint{Lat: wp.Lat, Lon: wp.Lon}
+		}
+		if len(pts) > 0 {
+			lines = append(lines, pts)
+		}
+	}
+	if len(lines) == 0 {
+		return nil, errors.New("no track, route or waypoint points")
+	}
+	return lines, nil
+}
+
+// prewarmZooms parses WHEREAMI_TILE_PREWARM_ZOOMS ("12" or "10-15").
+func prewarmZooms() (minZoom, maxZoom int) {
+	minZoom, maxZoom = defaultPrewarmMinZoom, defaultPrewarmMaxZoom
+	v := strings.TrimSpace(os.Getenv(tilePrewarmZoomsEnv))
+	if v == "" {
+		return
+	}
+	lo, hi, found := strings.Cut(v, "-")
+	a, errA := strconv.Atoi(strings.TrimSpace(lo))
+	b := a
+	var errB error
+	if found {
+		b, errB = strconv.Atoi(strings.TrimSpace(hi))
+	}
+	if errA != nil || errB != nil || checkZoomRange(a, b) != nil {
+		logger.Warn("ignoring invalid %s=%q", tilePrewarmZoomsEnv, v)
+		return
+	}
+	return a, b
+}
+
+// prewarmCorridor parses WHEREAMI_TILE_PREWARM_CORRIDOR (meters, >= 0).
+func prewarmCorridor() float64 {
+	if v := os.Getenv(tilePrewarmCorridorEnv); v != "" {
+		if m, err := strconv.ParseFloat(v, 64); err == nil && m >= 0 {
+			return m
+		}
+		logger.Warn("ignoring invalid %s=%q", tilePrewarmCorridorEnv, v)
+	}
+	return defaultPrewarmCorridorM
+}
+
+// routeTiles returns the distinct tiles at zoom z within corridorM meters of
+// the polylines. Segments are sampled at most half a tile (or corridor) apart
+// and each sample contributes the tiles overlapping its corridor box. It stops
+// once more than limit tiles were collected.
+func routeTiles(lines [][]trackPoint, z int, corridorM float64, limit int) []tileKey {
+	seen := make(map[tileKey]struct{})
+	var keys []tileKey
+	add := func(lat, lon float64) {
+		dLat := corridorM / 111320.0
+		dLon := corridorM / (111320.0 * math.Max(math.Cos(lat*math.Pi/180), 0.01))
+		x0, y0, x1, y1 := tileRange(max(lon-dLon, -180), max(lat-dLat, -90), min(lon+dLon, 180), min(lat+dLat, 90), z)
+		for x := x0; x <= x1; x++ {
+			for y := y0; y <= y1; y++ {
+				k := tileKey{z, x, y}
+				if _, ok := seen[k]; !ok {
+					seen[k] = struct{}{}
+					keys = append(keys, k)
+				}
+			}
+		}
+	}
+	// Tile width in meters at the equator; an upper bound elsewhere.
+	step := 40075016.0 / math.Exp2(float64(z)) / 2
+	if corridorM > 0 {
+		step = min(step, corridorM)
+	}
+	for _, line := range lines {
+		for i, p := range line {
+			if len(keys) > limit {
+				return keys
+			}
+			if i == 0 {
+				add(p.Lat, p.Lon)
+				continue
+			}
+			prev := line[i-1]
+			n := int(math.Ceil(distanceMeters(prev.Lat, prev.Lon, p.Lat, p.Lon) / step))
+			for s := 1; s <= n; s++ {
+				t := float64(s) / float64(n)
+				add(prev.Lat+(p.Lat-prev.Lat)*t, prev.Lon+(p.Lon-prev.Lon)*t)
+			}
+			if n == 0 {
+				add(p.Lat, p.Lon)
+			}
+		}
+	}
+	return keys
+}
+
+// startRoutePrewarm launches the background prewarm when WHEREAMI_TILE_PREWARM_GPX is set.
+func (p *tileProxy) startRoutePrewarm() {
+	path := os.Getenv(tilePrewarmGPXEnv)
+	if path == "" {
+		return
+	}
+	go p.prewarmRoute(context.Background(), path)
+}
+
+// prewarmRoute prefetches the route corridor one zoom at a time, logging
+// progress after each zoom. The total is capped at maxPrefetchTiles.
+func (p *tileProxy) prewarmRoute(ctx context.Context, path string) {
+	lines, err := parseGPXRoute(path)
+	if err != nil {
+		logger.Error("tile prewarm: cannot read %s: %v", path, err)
+		return
+	}
+	minZoom, maxZoom := prewarmZooms()
+	corridor := prewarmCorridor()
+	logger.Info("tile prewarm: starting route=%s lines=%d zooms=%d-%d corridor=%.0fm", path, len(lines), minZoom, maxZoom, corridor)
+
+	start := time.Now()
+	var total prefetchResult
+	for z := minZoom; z <= maxZoom; z++ {
+		budget := maxPrefetchTiles - total.Requested
+		keys := routeTiles(lines, z, corridor, budget)
+		if len(keys) > budget {
+			logger.Warn("tile prewarm: stopping at zoom %d, route needs more than %d tiles in total", z, maxPrefetchTiles)
+			break
+		}
+		res := p.prefetchTiles(ctx, keys)
+		total.Requested += res.Requested
+		total.Fetched += res.Fetched
+		total.Cached += res.Cached
+		total.Errors += res.Errors
+		logger.Info("tile prewarm: zoom %d done tiles=%d fetched=%d cached=%d errors=%d", z, res.Requested, res.Fetched, res.Cached, res.Errors)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	logger.Info("tile prewarm: finished route=%s requested=%d fetched=%d cached=%d errors=%d elapsed=%v",
+		path, total.Requested, total.Fetched, total.Cached, total.Errors, time.Since(start).Round(time.Second))
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rubiojr/whereami/pkg/logger"
+)
+
+// Location track log (breadcrumb trail).
+//
+// With WHEREAMI_TRACK_LOG=true every fix accepted by readAndStoreLocation is
+// appended to the location_track table of track.sqlite in the data dir.
+// Consecutive identical fixes are collapsed so a stationary device does not
+// grow the log. GET /api/track and GET /api/track/export read it back.
+
+var (
+	trackLogDB     *sql.DB
+	trackLogDBOnce sync.Once
+
+	// Last logged fix, used to skip consecutive duplicates.
+	trackLogMu   sync.Mutex
+	trackLogLast LocationFix
+	trackLogHave bool
+)
+
+// Speeds up ?since= range scans.
+var trackLogIndexes = []string{
+	`CREATE INDEX IF NOT EXISTS idx_location_track_ts ON location_track(ts)`,
+}
+
+// trackTimeLayout is fixed-width (always UTC) so stored timestamps sort lexically.
+const trackTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+const (
+	defaultTrackLimit = 1000
+	maxTrackLimit     = 100000
+)
+
+// trackLogEnabled reports whether WHEREAMI_TRACK_LOG turns on fix recording.
+func trackLogEnabled() bool {
+	v, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv("WHEREAMI_TRACK_LOG")))
+	return v
+}
+
+// initTrackLogDB opens (idempotently) the track log DB (track.sqlite).
+func initTrackLogDB() {
+	trackLogDBOnce.Do(func() {
+		dir := effectiveDataDir()
+		if dir == "" {
+			logger.Error("initTrackLogDB: no data directory resolved")
+			return
+		}
+		db, err := sql.Open("sqlite", filepath.Join(dir, "track.sqlite"))
+		if err != nil {
+			logger.Error("initTrackLogDB: open failed: %v", err)
+			return
+		}
+		if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS location_track (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			ts TIMESTAMP NOT NULL,
+			lat REAL NOT NULL,
+			lon REAL NOT NULL,
+			accuracy REAL
+		)`); err != nil {
+			logger.Error("initTrackLogDB: schema error: %v", err)
+			_ = db.Close()
+			return
+		}
+		_ = ensureIndexes(db, trackLogIndexes)
+		trackLogDB = db
+	})
+}
+
+// recordTrackFix appends fix to the track log unless logging is disabled or
+// it repeats the previously logged position and accuracy.
+func recordTrackFix(fix LocationFix) {
+	if !trackLogEnabled() {
+		return
+	}
+	initTrackLogDB()
+	if trackLogDB == nil {
+		return
+	}
+	trackLogMu.Lock()
+	defer trackLogMu.Unlock()
+	if trackLogHave && trackLogLast.Latitude == fix.Latitude &&
+		trackLogLast.Longitude == fix.Longitude && trackLogLast.Accuracy == fix.Accuracy {
+		return
+	}
+	ts := fix.Timestamp
+	if ts.IsZero() {
+		ts = time.Now().UTC()
+	}
+	if _, err := trackLogDB.Exec(`INSERT INTO location_track(ts, lat, lon, accuracy) VALUES(?,?,?,?)`,
+		ts.UTC().Format(trackTimeLayout), fix.Latitude, fix.Longitude, fix.Accuracy); err != nil {
+		logger.Error("track log insert failed: %v", err)
+		return
+	}
+	trackLogLast, trackLogHave = fix, true
+}
+
+// queryTrackLog returns up to limit logged fixes at or after since (zero =
+// from the start), oldest first.
+func queryTrackLog(since time.Time, limit int) ([]LocationFix, error) {
+	out := []LocationFix{}
+	err := eachTrackFix(since, limit, func(fix LocationFix) error {
+		out = append(out, fix)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// eachTrackFix calls fn for the logged fixes at or after since, oldest first,
+// stopping after limit rows (limit <= 0 = every row). An error from fn stops
+// the iteration and is returned.
+func eachTrackFix(since time.Time, limit int, fn func(LocationFix) error) error {
+	if limit <= 0 {
+		limit = -1 // SQLite: no limit
+	}
+	rows, err := trackLogDB.Query(`SELECT ts, lat, lon, accuracy FROM location_track
+		WHERE ts >= ? ORDER BY ts ASC, id ASC LIMIT ?`, since.UTC().Format(trackTimeLayout), limit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			ts  string
+			fix LocationFix
+			acc sql.NullFloat64
+		)
+		if err := rows.Scan(&ts, &fix.Latitude, &fix.Longitude, &acc); err != nil {
+			return err
+		}
+		fix.Timestamp, _ = time.Parse(time.RFC3339Nano, ts)
+		fix.Accuracy = acc.Float64
+		if err := fn(fix); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// parseTrackQuery reads ?since= (RFC3339 or YYYY-MM-DD) and ?limit= for the
+// track log endpoints, writing a 400 and returning false when invalid.
+func parseTrackQuery(w http.ResponseWriter, r *http.Request, defLimit int) (since time.Time, limit int, ok bool) {
+	q := r.URL.Query()
+	if v := strings.TrimSpace(q.Get("since")); v != "" {
+		t, err := parseTimeBound(v, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return time.Time{}, 0, false
+		}
+		since = t
+	}
+	limit = defLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxTrackLimit {
+			http.Error(w, fmt.Sprintf("limit must be 1-%d", maxTrackLimit), http.StatusBadRequest)
+			return time.Time{}, 0, false
+		}
+		limit = n
+	}
+	return since, limit, true
+}
+
+// GET /api/track?since=&limit=
+// Returns logged fixes [ { lat, lon, accuracy_m?, timestamp } ] oldest first
+// (limit default 1000, max 100000).
+func handleGetTrack(w http.ResponseWriter, r *http.Request) {
+	since, limit, ok := parseTrackQuery(w, r, defaultTrackLimit)
+	if !ok {
+		return
+	}
+	initTrackLogDB()
+	if trackLogDB == nil {
+		http.Error(w, "track log unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	fixes, err := queryTrackLog(since, limit)
+	if err != nil {
+		logger.ErrorCtx(r.Context(), "GET /api/track query failed: %v", err)
+		http.Error(w, "query failed", http.StatusInternalServerError)
+		return
+	}
+	logger.DebugCtx(r.Context(), "GET /api/track since=%v count=%d", since, len(fixes))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(fixes)
+}
+
+// GET /api/track/export?since=&limit=
+// Downloads the logged fixes, oldest first, as a single-segment GPX track.
+// Without limit every fix since `since` is streamed; with it only the oldest
+// limit fixes are.
+func handleGetTrackExport(w http.ResponseWriter, r *http.Request) {
+	since, limit, ok := parseTrackQuery(w, r, 0)
+	if !ok {
+		return
+	}
+	initTrackLogDB()
+	if trackLogDB == nil {
+		http.Error(w, "track log unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	// The header goes out with the first fix, so a failing query still gets a 500.
+	count := 0
+	started := false
+	start := func() {
+		w.Header().Set("Content-Type", "application/gpx+xml")
+		w.Header().Set("Content-Disposition", `attachment; filename="track.gpx"`)
+		_, _ = io.WriteString(w, trackLogGPXHead)
+		started = true
+	}
+	err := eachTrackFix(since, limit, func(f LocationFix) error {
+		if !started {
+			start()
+		}
+		count++
+		return writeTrackLogGPXPoint(w, f)
+	})
+	if err != nil {
+		logger.ErrorCtx(r.Context(), "GET /api/track/export query failed after %d fixes: %v", count, err)
+		if !started {
+			http.Error(w, "query failed", http.StatusInternalServerError)
+		}
+		return
+	}
+	if !started {
+		start()
+	}
+	_, _ = io.WriteString(w, trackLogGPXTail)
+	logger.DebugCtx(r.Context(), "GET /api/track/export since=%v count=%d", since, count)
+}
+
+// GPX 1.1 framing of the location log export (one <trk> with one <trkseg>).
+const (
+	trackLogGPXHead = `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<gpx version="1.1" creator="whereami" xmlns="http://www.topografix.com/GPX/1/1">` + "\n" +
+		"  <trk>\n    <name>whereami location log</name>\n    <trkseg>\n"
+	trackLogGPXTail = "    </trkseg>\n  </trk>\n</gpx>\n"
+)
+
+// writeTrackLogGPXPoint writes one fix as a <trkpt>.
+func writeTrackLogGPXPoint(w io.Writer, f LocationFix) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "      <trkpt lat=\"%f\" lon=\"%f\">\n", f.Latitude, f.Longitude)
+	if !f.Timestamp.IsZero() {
+		fmt.Fprintf(&b, "        <time>%s</time>\n", f.Timestamp.UTC().Format(time.RFC3339))
+	}
+	b.WriteString("      </trkpt>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
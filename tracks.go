@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rubiojr/whereami/pkg/logger"
+)
+
+// GPX tracks (<trk>/<trkseg>/<trkpt>) and routes (<rte>/<rtept>).
+//
+// These are parsed separately from <wpt> waypoints (parseGPXFile is unchanged)
+// and kept in their own in-memory store, loaded at startup from gpxtracks.sqlite
+// (see trackstore.go) and extended by /api/import.
+
+// Track is one <trk> or <rte> of a GPX file.
+type Track struct {
+	ID       string         `json:"id"` // stable: derived from source path + position in file
+	Name     string         `json:"name,omitempty"`
+	Kind     string         `json:"kind"` // "track" | "route"
+	Source   string         `json:"source"`
+	Segments []TrackSegment `json:"segments"`
+}
+
+// TrackSegment is a continuous point sequence (a <trkseg>, or a whole <rte>).
+type TrackSegment struct {
+	Name   string       `json:"name,omitempty"`
+	Points []trackPoint `json:"points"`
+}
+
+type gpxTracksRoot struct {
+	Tracks []struct {
+		Name     string `xml:"name"`
+		Segments []struct {
+			Points []trackPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+	Routes []struct {
+		Name   string       `xml:"name"`
+		Points []trackPoint `xml:"rtept"`
+	} `xml:"rte"`
+}
+
+// Global track store.
+var (
+	allTracks   []Track
+	allTracksMu sync.RWMutex
+)
+
+// trackID derives a stable id from the source path and the track's index in it.
+func trackID(path string, idx int) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s#%d", filepath.Clean(path), idx)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// normalizeTrackTimes rewrites parseable RFC3339 point times in UTC (as parseGPXFile does).
+func normalizeTrackTimes(pts []trackPoint) {
+	for i := range pts {
+		if pts[i].Time == "" {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, pts[i].Time); err == nil {
+			pts[i].Time = t.UTC().Format(time.RFC3339)
+		}
+	}
+}
+
+// parseGPXTracks returns the tracks and routes of a GPX file. Segments without
+// points are dropped; a track with no remaining segments is skipped.
+func parseGPXTracks(path string) ([]Track, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var root gpxTracksRoot
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	var out []Track
+	idx := 0
+	for _, trk := range root.Tracks {
+		t := Track{ID: trackID(path, idx), Name: strings.TrimSpace(trk.Name), Kind: "track", Source: path}
+		idx++
+		for i, seg := range trk.Segments {
+			if len(seg.Points) == 0 {
+				continue
+			}
+			normalizeTrackTimes(seg.Points)
+			name := t.Name
+			if len(trk.Segments) > 1 {
+				name = strings.TrimSpace(fmt.Sprintf("%s #%d", t.Name, i+1))
+			}
+			t.Segments = append(t.Segments, TrackSegment{Name: name, Points: seg.Points})
+		}
+		if len(t.Segments) > 0 {
+			out = append(out, t)
+		}
+	}
+	for _, rte := range root.Routes {
+		t := Track{ID: trackID(path, idx), Name: strings.TrimSpace(rte.Name), Kind: "route", Source: path}
+		idx++
+		if len(rte.Points) == 0 {
+			continue
+		}
+		normalizeTrackTimes(rte.Points)
+		t.Segments = []TrackSegment{{Name: t.Name, Points: rte.Points}}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// collectGPXTracks parses tracks from every .gpx file under dir (recursively),
+// skipping exclude (the bookmarks file) and any path in skip. Unreadable files
+// are logged and skipped; their errors are already reported by the waypoint pass.
+func collectGPXTracks(dir, exclude string, skip map[string]bool) []Track {
+	var all []Track
+	_ = filepath.WalkDir(dir, func(p string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil || d.IsDir() {
+			return nil
+		}
+		if filepath.Clean(p) == filepath.Clean(exclude) || skip[filepath.Clean(p)] || !strings.EqualFold(filepath.Ext(p), ".gpx") {
+			return nil
+		}
+		tracks, err := parseGPXTracks(p)
+		if err != nil {
+			logger.Debug("Skipping tracks in %s: %v", p, err)
+			return nil
+		}
+		all = append(all, tracks...)
+		return nil
+	})
+	return all
+}
+
+// addTracks appends tracks to the store, replacing any with the same id.
+func addTracks(tracks []Track) {
+	if len(tracks) == 0 {
+		return
+	}
+	allTracksMu.Lock()
+	defer allTracksMu.Unlock()
+	pos := make(map[string]int, len(allTracks))
+	for i, t := range allTracks {
+		pos[t.ID] = i
+	}
+	for _, t := range tracks {
+		if i, ok := pos[t.ID]; ok {
+			allTracks[i] = t
+			continue
+		}
+		pos[t.ID] = len(allTracks)
+		allTracks = append(allTracks, t)
+	}
+}
+
+// removeTracksBySource drops every stored track parsed from path.
+func removeTracksBySource(path string) {
+	allTracksMu.Lock()
+	defer allTracksMu.Unlock()
+	kept := allTracks[:0]
+	for _, t := range allTracks {
+		if filepath.Clean(t.Source) != filepath.Clean(path) {
+			kept = append(kept, t)
+		}
+	}
+	allTracks = kept
+}
+
+// findTrack returns the stored track with the given id.
+func findTrack(id string) (Track, bool) {
+	allTracksMu.RLock()
+	defer allTracksMu.RUnlock()
+	for _, t := range allTracks {
+		if t.ID == id {
+			return t, true
+		}
+	}
+	return Track{}, false
+}
+
+// GET /api/tracks
+// Returns every parsed track/route: [ { id, name, kind, source, segments: [ { name, points: [ {lat,lon,ele?,time?} ] } ] } ].
+func handleGetTracks(w http.ResponseWriter, r *http.Request) {
+	allTracksMu.RLock()
+	out := make([]Track, len(allTracks))
+	copy(out, allTracks)
+	allTracksMu.RUnlock()
+	logger.DebugCtx(r.Context(), "GET /api/tracks count=%d", len(out))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
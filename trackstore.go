@@ -0,0 +1,269 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rubiojr/whereami/pkg/logger"
+)
+
+// Persistent track store (gpxtracks.sqlite in the data dir; track.sqlite next
+// to it is the unrelated location log of tracklog.go).
+//
+// Tracks parsed on import are written here (metadata in tracks, points in
+// track_points) and loaded back at startup, so the in-memory store of
+// tracks.go no longer depends on re-parsing every GPX file. At startup only
+// GPX files whose path is not yet a tracks.source are parsed and stored; a
+// file without tracks leaves no row and is checked again next time. Deleting
+// a track keeps a tombstone row so the startup scan does not bring it back;
+// importing the same file again clears it.
+
+var (
+	tracksDB     *sql.DB
+	tracksDBOnce sync.Once
+)
+
+var errTracksDBUnavailable = errors.New("track database unavailable")
+
+// initTracksDB opens (idempotently) the track store DB (gpxtracks.sqlite).
+func initTracksDB() {
+	tracksDBOnce.Do(func() {
+		dir := effectiveDataDir()
+		if dir == "" {
+			logger.Error("initTracksDB: no data directory resolved")
+			return
+		}
+		db, err := sql.Open("sqlite", filepath.Join(dir, "gpxtracks.sqlite"))
+		if err != nil {
+			logger.Error("initTracksDB: open failed: %v", err)
+			return
+		}
+		for _, stmt := range []string{
+			`CREATE TABLE IF NOT EXISTS tracks (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL DEFAULT '',
+				kind TEXT NOT NULL,
+				source TEXT NOT NULL DEFAULT '',
+				imported_at TIMESTAMP NOT NULL,
+				deleted INTEGER NOT NULL DEFAULT 0
+			)`,
+			`CREATE TABLE IF NOT EXISTS track_points (
+				track_id TEXT NOT NULL,
+				seg INTEGER NOT NULL,
+				seg_name TEXT NOT NULL DEFAULT '',
+				idx INTEGER NOT NULL,
+				lat REAL NOT NULL,
+				lon REAL NOT NULL,
+				ele REAL NOT NULL DEFAULT 0,
+				time TEXT NOT NULL DEFAULT '',
+				PRIMARY KEY(track_id, seg, idx)
+			)`,
+		} {
+			if _, err := db.Exec(stmt); err != nil {
+				logger.Error("initTracksDB: schema error: %v", err)
+				_ = db.Close()
+				return
+			}
+		}
+		_ = ensureIndexes(db, []string{`CREATE INDEX IF NOT EXISTS idx_tracks_source ON tracks(source)`})
+		tracksDB = db
+	})
+}
+
+// persistTracks stores (or replaces) tracks, clearing any tombstone.
+func persistTracks(tracks []Track) error {
+	if len(tracks) == 0 {
+		return nil
+	}
+	initTracksDB()
+	if tracksDB == nil {
+		return errTracksDBUnavailable
+	}
+	tx, err := tracksDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	for _, t := range tracks {
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO tracks(id, name, kind, source, imported_at, deleted) VALUES(?,?,?,?,?,0)`,
+			t.ID, t.Name, t.Kind, t.Source, now); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM track_points WHERE track_id = ?`, t.ID); err != nil {
+			return err
+		}
+		for si, seg := range t.Segments {
+			for pi, p := range seg.Points {
+				if _, err := tx.Exec(`INSERT INTO track_points(track_id, seg, seg_name, idx, lat, lon, ele, time) VALUES(?,?,?,?,?,?,?,?)`,
+					t.ID, si, seg.Name, pi, p.Lat, p.Lon, p.Ele, p.Time); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// forgetTracksBySource drops every stored track (and tombstone) of source,
+// used when an import replaces the file.
+func forgetTracksBySource(source string) error {
+	initTracksDB()
+	if tracksDB == nil {
+		return errTracksDBUnavailable
+	}
+	if _, err := tracksDB.Exec(`DELETE FROM track_points WHERE track_id IN (SELECT id FROM tracks WHERE source = ?)`, source); err != nil {
+		return err
+	}
+	_, err := tracksDB.Exec(`DELETE FROM tracks WHERE source = ?`, source)
+	return err
+}
+
+// deletePersistedTrack removes a track's points and tombstones its row.
+func deletePersistedTrack(id string) error {
+	initTracksDB()
+	if tracksDB == nil {
+		return errTracksDBUnavailable
+	}
+	tx, err := tracksDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM track_points WHERE track_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE tracks SET deleted = 1 WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// loadPersistedTracks returns the stored tracks in import order and the
+// cleaned source paths of every known track, deleted ones included.
+func loadPersistedTracks() ([]Track, map[string]bool, error) {
+	rows, err := tracksDB.Query(`SELECT id, name, kind, source, deleted FROM tracks ORDER BY imported_at, rowid`)
+	if err != nil {
+		return nil, nil, err
+	}
+	var out []Track
+	known := map[string]bool{}
+	pos := map[string]int{}
+	for rows.Next() {
+		var t Track
+		var deleted bool
+		if err := rows.Scan(&t.ID, &t.Name, &t.Kind, &t.Source, &deleted); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		known[filepath.Clean(t.Source)] = true
+		if !deleted {
+			pos[t.ID] = len(out)
+			out = append(out, t)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	pts, err := tracksDB.Query(`SELECT track_id, seg, seg_name, lat, lon, ele, time FROM track_points ORDER BY track_id, seg, idx`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer pts.Close()
+	for pts.Next() {
+		var (
+			id, segName string
+			seg         int
+			p           trackPoint
+		)
+		if err := pts.Scan(&id, &seg, &segName, &p.Lat, &p.Lon, &p.Ele, &p.Time); err != nil {
+			return nil, nil, err
+		}
+		i, ok := pos[id]
+		if !ok {
+			continue
+		}
+		t := &out[i]
+		for len(t.Segments) <= seg {
+			t.Segments = append(t.Segments, TrackSegment{})
+		}
+		t.Segments[seg].Name = segName
+		t.Segments[seg].Points = append(t.Segments[seg].Points, p)
+	}
+	return out, known, pts.Err()
+}
+
+// loadTracks returns the tracks for the in-memory store at startup: the
+// persisted ones plus the tracks of GPX files under dataDir with no stored
+// source yet (which are persisted now). Falls back to parsing every file when
+// the DB is unavailable.
+func loadTracks(dataDir, bookmarksPath string) []Track {
+	initTracksDB()
+	if tracksDB == nil {
+		return collectGPXTracks(dataDir, bookmarksPath, nil)
+	}
+	stored, known, err := loadPersistedTracks()
+	if err != nil {
+		logger.Error("loading persisted tracks failed: %v", err)
+		return collectGPXTracks(dataDir, bookmarksPath, nil)
+	}
+	fresh := collectGPXTracks(dataDir, bookmarksPath, known)
+	if err := persistTracks(fresh); err != nil {
+		logger.Error("persisting %d discovered tracks failed: %v", len(fresh), err)
+	} else if len(fresh) > 0 {
+		logger.Debug("persisted %d tracks found on disk", len(fresh))
+	}
+	return append(stored, fresh...)
+}
+
+// removeTrack drops the track with id from the in-memory store.
+func removeTrack(id string) bool {
+	allTracksMu.Lock()
+	defer allTracksMu.Unlock()
+	for i, t := range allTracks {
+		if t.ID == id {
+			allTracks = append(allTracks[:i], allTracks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// GET /api/tracks/{id}
+// Returns one track in the GET /api/tracks shape, or 404.
+func handleGetTrackByID(w http.ResponseWriter, r *http.Request) {
+	t, ok := findTrack(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "track not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(t)
+}
+
+// DELETE /api/tracks/{id}
+// Removes the track from the store and gpxtracks.sqlite (the source GPX file and
+// its waypoints are left alone). Returns { deleted: true, id }.
+func handleDeleteTrack(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := findTrack(id); !ok {
+		http.Error(w, "track not found", http.StatusNotFound)
+		return
+	}
+	if err := deletePersistedTrack(id); err != nil {
+		logger.ErrorCtx(r.Context(), "DELETE /api/tracks/%s failed: %v", id, err)
+		http.Error(w, "delete failed", http.StatusInternalServerError)
+		return
+	}
+	removeTrack(id)
+	logger.DebugCtx(r.Context(), "DELETE /api/tracks/%s", id)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"deleted": true, "id": id})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"math"
+	"sync"
+)
+
+// WaypointStore is the in-memory waypoint set (bookmarks + imported files)
+// served by the API. All access goes through its methods, which take the lock
+// and, for mutations, call markWaypointsChanged so derived indices (the
+// cluster tree) are rebuilt.
+//
+// Waypoints are matched by exact name and coordinates within waypointEpsilon,
+// the same tolerance the bookmark file helpers in storage.go use.
+type WaypointStore struct {
+	mu  sync.RWMutex
+	wps []Waypoint
+}
+
+// waypointStore is the process-wide store.
+var waypointStore = &WaypointStore{}
+
+// matches reports whether wp is the waypoint identified by name, lat, lon.
+func (wp Waypoint) matches(name string, lat, lon float64) bool {
+	return wp.Name == name && math.Abs(wp.Lat-lat) < waypointEpsilon && math.Abs(wp.Lon-lon) < waypointEpsilon
+}
+
+// Snapshot returns a copy of every waypoint.
+func (s *WaypointStore) Snapshot() []Waypoint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Waypoint, len(s.wps))
+	copy(out, s.wps)
+	return out
+}
+
+// Filter returns a copy of the waypoints for which keep returns true (never nil).
+func (s *WaypointStore) Filter(keep func(Waypoint) bool) []Waypoint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := []Waypoint{}
+	for _, wp := range s.wps {
+		if keep(wp) {
+			out = append(out, wp)
+		}
+	}
+	return out
+}
+
+// View calls fn with the live slice under the read lock. fn must not retain
+// or modify it, nor call back into the store.
+func (s *WaypointStore) View(fn func([]Waypoint)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fn(s.wps)
+}
+
+// Len returns the number of waypoints.
+func (s *WaypointStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.wps)
+}
+
+// Find returns the waypoint identified by name, lat, lon.
+func (s *WaypointStore) Find(name string, lat, lon float64) (Waypoint, bool) {
+	return s.FindFunc(func(wp Waypoint) bool { return wp.matches(name, lat, lon) })
+}
+
+// FindFunc returns the first waypoint for which match returns true.
+func (s *WaypointStore) FindFunc(match func(Waypoint) bool) (Waypoint, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, wp := range s.wps {
+		if match(wp) {
+			return wp, true
+		}
+	}
+	return Waypoint{}, false
+}
+
+// Add appends wp.
+func (s *WaypointStore) Add(wp Waypoint) {
+	s.mu.Lock()
+	s.wps = append(s.wps, wp)
+	s.mu.Unlock()
+	markWaypointsChanged()
+}
+
+// Merge appends wps and dedupes the result (DedupeWaypoints, then
+// DedupeNearBookmarks with the configured radius). It returns the new size.
+func (s *WaypointStore) Merge(wps []Waypoint) int {
+	s.mu.Lock()
+	combined := append(s.wps, wps...)
+	s.wps = DedupeNearBookmarks(DedupeWaypoints(combined), dedupeRadiusMeters())
+	n := len(s.wps)
+	s.mu.Unlock()
+	markWaypointsChanged()
+	return n
+}
+
+// Replace swaps in wps as the whole set and returns its size.
+func (s *WaypointStore) Replace(wps []Waypoint) int {
+	s.mu.Lock()
+	s.wps = wps
+	n := len(wps)
+	s.mu.Unlock()
+	markWaypointsChanged()
+	return n
+}
+
+// RemoveMatch removes every waypoint identified by name, lat, lon and returns
+// how many were removed.
+func (s *WaypointStore) RemoveMatch(name string, lat, lon float64) int {
+	s.mu.Lock()
+	kept := s.wps[:0]
+	for _, wp := range s.wps {
+		if !wp.matches(name, lat, lon) {
+			kept = append(kept, wp)
+		}
+	}
+	n := len(s.wps) - len(kept)
+	clear(s.wps[len(kept):])
+	s.wps = kept
+	s.mu.Unlock()
+	if n > 0 {
+		markWaypointsChanged()
+	}
+	return n
+}
+
+// Rename sets the name of the waypoint identified by oldName, lat, lon.
+func (s *WaypointStore) Rename(oldName string, lat, lon float64, newName string) bool {
+	return s.Update(oldName, lat, lon, func(wp *Waypoint) { wp.Name = newName })
+}
+
+// Update applies fn to the waypoint identified by name, lat, lon.
+func (s *WaypointStore) Update(name string, lat, lon float64, fn func(*Waypoint)) bool {
+	s.mu.Lock()
+	found := false
+	for i := range s.wps {
+		if s.wps[i].matches(name, lat, lon) {
+			fn(&s.wps[i])
+			found = true
+			break
+		}
+	}
+	s.mu.Unlock()
+	if found {
+		markWaypointsChanged()
+	}
+	return found
+}
+
+// UpdateAll calls fn on every waypoint; fn reports whether it changed it. It
+// returns the number of changed waypoints.
+func (s *WaypointStore) UpdateAll(fn func(*Waypoint) bool) int {
+	s.mu.Lock()
+	n := 0
+	for i := range s.wps {
+		if fn(&s.wps[i]) {
+			n++
+		}
+	}
+	s.mu.Unlock()
+	if n > 0 {
+		markWaypointsChanged()
+	}
+	return n
+}
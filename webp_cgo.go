@@ -0,0 +1,45 @@
+//go:build webp
+
+package main
+
+// WebP encoder backed by libwebp, registered in webpEncode for
+// WHEREAMI_TILE_FORMAT=webp. Build with -tags webp (libwebp headers and
+// pkg-config file required, e.g. libwebp-devel on Fedora).
+
+/*
+#cgo pkg-config: libwebp
+#include <webp/encode.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"unsafe"
+)
+
+func init() {
+	webpEncode = encodeWebP
+}
+
+// encodeWebP losslessly encodes img, so transcoded tiles stay pixel-identical.
+func encodeWebP(img image.Image) ([]byte, error) {
+	b := img.Bounds()
+	rgba, ok := img.(*image.NRGBA)
+	if !ok || rgba.Rect.Min != (image.Point{}) {
+		rgba = image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(rgba, rgba.Rect, img, b.Min, draw.Src)
+	}
+	if len(rgba.Pix) == 0 {
+		return nil, errors.New("empty image")
+	}
+	var out *C.uint8_t
+	n := C.WebPEncodeLosslessRGBA((*C.uint8_t)(unsafe.Pointer(&rgba.Pix[0])),
+		C.int(b.Dx()), C.int(b.Dy()), C.int(rgba.Stride), &out)
+	if n == 0 || out == nil {
+		return nil, errors.New("libwebp encode failed")
+	}
+	defer C.WebPFree(unsafe.Pointer(out))
+	return C.GoBytes(unsafe.Pointer(out), C.int(n)), nil
+}